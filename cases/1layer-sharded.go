@@ -11,6 +11,7 @@ package cases
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"varnish_sim/model"
 )
 
@@ -18,22 +19,33 @@ import (
 // it holds the amount of Varnish proxies and cache size on each layer
 
 type OneLayerSharded struct {
-	// default backend that serves `all` requests
-	backend *model.Backend
+	// origins serve requests that missed all proxies
+	originTier
 
 	// layers of Varnish proxies
 	proxies []*model.VarnishProxy
 
 	// configuration for the case
 	config LayerConfig
+	// sharding is parameters of the shard director of the layer
+	sharding model.DirectorParams
+	director *model.ShardDirector
+	shardReports
 }
 
-func (o *OneLayerSharded) Step() error {
+// SetSharding sets parameters of the shard director of the layer, it is used by SetUp
+func (o *OneLayerSharded) SetSharding(params model.DirectorParams) *OneLayerSharded {
+	o.sharding = params
+	return o
+}
+
+func (o *OneLayerSharded) Step(now time.Time) error {
 	var err error
 
 	for _, proxy := range o.proxies {
-		err = WriteStep(proxy)
+		err = WriteStep(proxy, now)
 	}
+	err = o.stepOrigins(now)
 
 	return err
 }
@@ -49,11 +61,45 @@ func (o *OneLayerSharded) PrintResultsCB(isJson bool) func() error {
 				}
 				fmt.Println(string(raw))
 			}
-			raw, err := json.Marshal(o.backend.Export())
+			for _, origin := range o.exportOrigins() {
+				raw, err := json.Marshal(origin)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(raw))
+			}
+			raw, err := json.Marshal(model.NewOriginOffload(o.proxies, o.Origins()).Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(o.latencyReport().Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(model.NewSaturation(o.proxies, o.Origins()).Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(model.NewShardPlacement(o.director).Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(model.NewShardLoad(o.hotKeys, o.director).Export())
 			if err != nil {
 				return err
 			}
 			fmt.Println(string(raw))
+			if replication := model.NewReplication(o.director); replication.Enabled() {
+				raw, err = json.Marshal(replication.Export())
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(raw))
+			}
 			return nil
 		}
 	}
@@ -61,26 +107,48 @@ func (o *OneLayerSharded) PrintResultsCB(isJson bool) func() error {
 		for _, proxy := range o.proxies {
 			proxy.PrintResult()
 		}
+		model.PrintTable(model.NewOriginOffload(o.proxies, o.Origins()))
+		o.printSites()
+		printLatency(o.latencyReport())
+		printSaturation(model.NewSaturation(o.proxies, o.Origins()))
+		printPlacement(model.NewShardPlacement(o.director))
+		printShardLoad(model.NewShardLoad(o.hotKeys, o.director))
+		printReplication(model.NewReplication(o.director))
 		return nil
 	}
 }
 
 func (o *OneLayerSharded) SetUp() ([]*model.VarnishProxy, error) {
-	o.backend = &model.Backend{Hostname: "default"}
+	backend, err := o.setUpOrigins()
+	if err != nil {
+		return nil, err
+	}
 
-	director := model.NewShardDirector()
+	director, err := model.NewShardDirectorWithParams(o.sharding)
+	if err != nil {
+		return nil, err
+	}
+	o.director = director
 
 	proxies := make([]*model.VarnishProxy, 0)
 	for i := 0; i < o.config.Amount; i++ {
 		proxy, err := model.NewVarnishProxy(
 			fmt.Sprintf("proxy-%d", i),
 			o.config.CacheSize,
+			o.config.Eviction,
 		)
-		proxy.SetBackend(o.backend)
-
 		if err != nil {
 			return nil, err
 		}
+		admission, err := model.NewAdmission(o.config.Admission)
+		if err != nil {
+			return nil, err
+		}
+		proxy.SetBackend(backend).SetLifetime(o.config.Lifetime).SetUplink(o.config.Link).SetCapacity(o.config.Capacity).SetAdmission(admission)
+		if err := proxy.SetPools(o.config.Pools, o.config.PoolRules); err != nil {
+			return nil, err
+		}
+
 		proxies = append(proxies, proxy)
 
 		// add backend to common hash-circle
@@ -90,13 +158,27 @@ func (o *OneLayerSharded) SetUp() ([]*model.VarnishProxy, error) {
 		proxy.SetDirector(director)
 	}
 	o.proxies = proxies
+	o.routeSites(proxies)
 	return proxies, nil
 }
 
+// latencyReport returns response times of the layer
+func (o *OneLayerSharded) latencyReport() *model.LatencyReport {
+	return model.NewLatencyReport(o.proxies).AddLayer("proxies", o.proxies)
+}
+
+// Proxies returns Varnish proxies of the layer
+func (o *OneLayerSharded) Proxies() []*model.VarnishProxy {
+	return o.proxies
+}
+
 func (o *OneLayerSharded) Validate() error {
+	if err := o.sharding.Validate(); err != nil {
+		return fmt.Errorf("sharding: %w", err)
+	}
 	return o.config.Validate()
 }
 
 func NewOneLayerSharded(config LayerConfig) *OneLayerSharded {
-	return &OneLayerSharded{config: config}
+	return &OneLayerSharded{config: config, shardReports: shardReports{hotKeys: model.DefaultHotKeys}}
 }
@@ -11,14 +11,29 @@ package cases
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"varnish_sim/model"
 )
 
 // LayerConfig is a helper struct for TwoLayerShardedConfig
 // it holds the amount of Varnish proxies and cache size on each layer
 type LayerConfig struct {
-	Amount    int `json:"amount"`
-	CacheSize int `json:"cacheSize"`
+	Amount    int    `json:"amount" yaml:"amount"`
+	CacheSize int    `json:"cacheSize" yaml:"cacheSize"`
+	Eviction  string `json:"eviction" yaml:"eviction"`
+	// Admission is a spec of the admission policy of caches of the layer, empty admits all objects
+	Admission string `json:"admission,omitempty" yaml:"admission"`
+	// Pools are storage pools of caches of the layer besides the main one sized by CacheSize,
+	// PoolRules select the pool each object is stored in
+	Pools     []model.PoolConfig `json:"pools,omitempty" yaml:"pools"`
+	PoolRules []model.PoolRule   `json:"poolRules,omitempty" yaml:"poolRules"`
+
+	// Lifetime is a default TTL, grace and keep of objects cached on the layer
+	Lifetime model.Lifetime `json:"lifetime" yaml:"lifetime"`
+	// Link is a link of the layer to its backends
+	Link model.Link `json:"link" yaml:"link"`
+	// Capacity is workers and queue length of each proxy of the layer
+	Capacity model.Capacity `json:"capacity" yaml:"capacity"`
 }
 
 func (l *LayerConfig) String() string {
@@ -32,6 +47,29 @@ func (l *LayerConfig) Validate() error {
 	if l.CacheSize < 0 {
 		return fmt.Errorf("cache size must be greater than 0")
 	}
+	if !model.IsEvictionPolicy(l.Eviction) {
+		return fmt.Errorf("unknown eviction policy %q", l.Eviction)
+	}
+	if _, err := model.NewAdmission(l.Admission); err != nil {
+		return err
+	}
+	if err := model.ValidatePools(l.Pools, l.PoolRules); err != nil {
+		return err
+	}
+	if err := validateLifetime(l.Lifetime); err != nil {
+		return err
+	}
+	if err := l.Link.Validate(); err != nil {
+		return err
+	}
+	return l.Capacity.Validate()
+}
+
+// validateLifetime checks that durations of the lifetime are not negative
+func validateLifetime(l model.Lifetime) error {
+	if l.TTL < 0 || l.Grace < 0 || l.Keep < 0 {
+		return fmt.Errorf("ttl, grace and keep must not be negative")
+	}
 	return nil
 }
 
@@ -40,8 +78,8 @@ func (l *LayerConfig) Store() error {
 }
 
 type OneLayer struct {
-	// default backend that serves `all` requests
-	backend *model.Backend
+	// origins serve requests that missed all proxies
+	originTier
 
 	// layers of Varnish proxies
 	proxies []*model.VarnishProxy
@@ -50,12 +88,13 @@ type OneLayer struct {
 	config LayerConfig
 }
 
-func (o *OneLayer) Step() error {
+func (o *OneLayer) Step(now time.Time) error {
 	var err error
 
 	for _, proxy := range o.proxies {
-		err = WriteStep(proxy)
+		err = WriteStep(proxy, now)
 	}
+	err = o.stepOrigins(now)
 
 	return err
 }
@@ -71,7 +110,24 @@ func (o *OneLayer) PrintResultsCB(isJson bool) func() error {
 				}
 				fmt.Println(string(raw))
 			}
-			raw, err := json.Marshal(o.backend.Export())
+			for _, origin := range o.exportOrigins() {
+				raw, err := json.Marshal(origin)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(raw))
+			}
+			raw, err := json.Marshal(model.NewOriginOffload(o.proxies, o.Origins()).Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(o.latencyReport().Export())
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(raw))
+			raw, err = json.Marshal(model.NewSaturation(o.proxies, o.Origins()).Export())
 			if err != nil {
 				return err
 			}
@@ -83,30 +139,56 @@ func (o *OneLayer) PrintResultsCB(isJson bool) func() error {
 		for _, proxy := range o.proxies {
 			proxy.PrintResult()
 		}
+		model.PrintTable(model.NewOriginOffload(o.proxies, o.Origins()))
+		o.printSites()
+		printLatency(o.latencyReport())
+		printSaturation(model.NewSaturation(o.proxies, o.Origins()))
 		return nil
 	}
 }
 
 func (o *OneLayer) SetUp() ([]*model.VarnishProxy, error) {
-	o.backend = &model.Backend{Hostname: "default"}
+	backend, err := o.setUpOrigins()
+	if err != nil {
+		return nil, err
+	}
 
 	proxies := make([]*model.VarnishProxy, 0)
 	for i := 0; i < o.config.Amount; i++ {
 		proxy, err := model.NewVarnishProxy(
 			fmt.Sprintf("proxy-%d", i),
 			o.config.CacheSize,
+			o.config.Eviction,
 		)
-		proxy.SetBackend(o.backend)
-
 		if err != nil {
 			return nil, err
 		}
+		admission, err := model.NewAdmission(o.config.Admission)
+		if err != nil {
+			return nil, err
+		}
+		proxy.SetBackend(backend).SetLifetime(o.config.Lifetime).SetUplink(o.config.Link).SetCapacity(o.config.Capacity).SetAdmission(admission)
+		if err := proxy.SetPools(o.config.Pools, o.config.PoolRules); err != nil {
+			return nil, err
+		}
+
 		proxies = append(proxies, proxy)
 	}
 	o.proxies = proxies
+	o.routeSites(proxies)
 	return proxies, nil
 }
 
+// latencyReport returns response times of the layer
+func (o *OneLayer) latencyReport() *model.LatencyReport {
+	return model.NewLatencyReport(o.proxies).AddLayer("proxies", o.proxies)
+}
+
+// Proxies returns Varnish proxies of the layer
+func (o *OneLayer) Proxies() []*model.VarnishProxy {
+	return o.proxies
+}
+
 func (o *OneLayer) Validate() error {
 	return o.config.Validate()
 }
@@ -11,6 +11,7 @@ package cases
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"varnish_sim/model"
 )
 
@@ -49,23 +50,31 @@ func (c *TwoLayerShardedConfig) Store() error {
 // fillVarnishProxies is a helper function to fill a list with Varnish proxies
 // [out] proxyList: a list of Varnish proxies
 // [in] prefix: a prefix for the Varnish proxy name
-// [in] amount: the amount of Varnish proxies to create
-// [in] cacheSize: the cache size for each Varnish proxy
+// [in] layer: the amount of Varnish proxies to create, their cache size, eviction and admission policies,
+// storage pools, lifetime of objects, the link to their backends and their capacity
 func fillVarnishProxies(
 	proxyList *[]*model.VarnishProxy,
 	prefix string,
-	amount int,
-	cacheSize int,
+	layer LayerConfig,
 ) error {
-	for i := 0; i < amount; i++ {
+	for i := 0; i < layer.Amount; i++ {
 		proxy, err := model.NewVarnishProxy(
 			fmt.Sprintf("%s-%d", prefix, i),
-			cacheSize,
+			layer.CacheSize,
+			layer.Eviction,
 		)
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
+		admission, err := model.NewAdmission(layer.Admission)
+		if err != nil {
+			return err
+		}
+		proxy.SetLifetime(layer.Lifetime).SetUplink(layer.Link).SetCapacity(layer.Capacity).SetAdmission(admission)
+		if err := proxy.SetPools(layer.Pools, layer.PoolRules); err != nil {
+			return err
+		}
 		*proxyList = append(*proxyList, proxy)
 	}
 
@@ -77,8 +86,8 @@ func fillVarnishProxies(
 //
 //	has a backend that serves requests
 type TwoLayerSharded struct {
-	// default backend that serves `all` requests
-	backend *model.Backend
+	// origins serve requests that missed all proxies
+	originTier
 
 	// layers of Varnish proxies
 	firstL  []*model.VarnishProxy
@@ -86,11 +95,21 @@ type TwoLayerSharded struct {
 
 	// configuration for the case
 	config TwoLayerShardedConfig
+	// sharding is parameters of shard directors of the first layer
+	sharding  model.DirectorParams
+	directors []*model.ShardDirector
+	shardReports
+}
+
+// SetSharding sets parameters of shard directors of the first layer, it is used by SetUp
+func (t *TwoLayerSharded) SetSharding(params model.DirectorParams) *TwoLayerSharded {
+	t.sharding = params
+	return t
 }
 
 // NewTwoLayerSharded is a constructor for TwoLayerSharded
 func NewTwoLayerSharded(config TwoLayerShardedConfig) *TwoLayerSharded {
-	return &TwoLayerSharded{config: config}
+	return &TwoLayerSharded{config: config, shardReports: shardReports{hotKeys: model.DefaultHotKeys}}
 }
 
 // Validate checks if the configuration is valid
@@ -108,61 +127,116 @@ func (c *TwoLayerShardedConfig) Validate() error {
 	if c.SecondLayer.CacheSize < 1 {
 		return fmt.Errorf("second layer cache size should be greater than 0")
 	}
+	if !model.IsEvictionPolicy(c.FirstLayer.Eviction) {
+		return fmt.Errorf("first layer eviction policy %q is unknown", c.FirstLayer.Eviction)
+	}
+	if !model.IsEvictionPolicy(c.SecondLayer.Eviction) {
+		return fmt.Errorf("second layer eviction policy %q is unknown", c.SecondLayer.Eviction)
+	}
+	if _, err := model.NewAdmission(c.FirstLayer.Admission); err != nil {
+		return fmt.Errorf("first layer: %w", err)
+	}
+	if _, err := model.NewAdmission(c.SecondLayer.Admission); err != nil {
+		return fmt.Errorf("second layer: %w", err)
+	}
+	if err := model.ValidatePools(c.FirstLayer.Pools, c.FirstLayer.PoolRules); err != nil {
+		return fmt.Errorf("first layer: %w", err)
+	}
+	if err := model.ValidatePools(c.SecondLayer.Pools, c.SecondLayer.PoolRules); err != nil {
+		return fmt.Errorf("second layer: %w", err)
+	}
+	if err := validateLifetime(c.FirstLayer.Lifetime); err != nil {
+		return fmt.Errorf("first layer: %w", err)
+	}
+	if err := validateLifetime(c.SecondLayer.Lifetime); err != nil {
+		return fmt.Errorf("second layer: %w", err)
+	}
+	if err := c.FirstLayer.Link.Validate(); err != nil {
+		return fmt.Errorf("first layer: %w", err)
+	}
+	if err := c.SecondLayer.Link.Validate(); err != nil {
+		return fmt.Errorf("second layer: %w", err)
+	}
+	if err := c.FirstLayer.Capacity.Validate(); err != nil {
+		return fmt.Errorf("first layer: %w", err)
+	}
+	if err := c.SecondLayer.Capacity.Validate(); err != nil {
+		return fmt.Errorf("second layer: %w", err)
+	}
 
 	return nil
 }
 
+// Proxies returns Varnish proxies of both layers
+func (t *TwoLayerSharded) Proxies() []*model.VarnishProxy {
+	return append(append([]*model.VarnishProxy{}, t.firstL...), t.secondL...)
+}
+
 // Validate checks if the case is valid, validates its configuration
 func (t *TwoLayerSharded) Validate() error {
+	if err := t.sharding.Validate(); err != nil {
+		return fmt.Errorf("sharding: %w", err)
+	}
 	return t.config.Validate()
 }
 
 // SetUp initializes the case and returns a list of VarnishProxy instances
 // returns an error if the case cannot be initialized
 func (t *TwoLayerSharded) SetUp() ([]*model.VarnishProxy, error) {
-	// initialize default backend that serves `all` requests
-	t.backend = &model.Backend{Hostname: "default"}
+	// initialize origins that serve `all` requests
+	backend, err := t.setUpOrigins()
+	if err != nil {
+		return nil, err
+	}
 
 	// fill layers with Varnish proxies
-	err := fillVarnishProxies(&t.secondL, "2", t.config.SecondLayer.Amount, t.config.SecondLayer.CacheSize)
+	err = fillVarnishProxies(&t.secondL, "2", t.config.SecondLayer)
 	if err != nil {
 		return nil, err
 	}
 
-	err = fillVarnishProxies(&t.firstL, "1", t.config.FirstLayer.Amount, t.config.FirstLayer.CacheSize)
+	err = fillVarnishProxies(&t.firstL, "1", t.config.FirstLayer)
 	if err != nil {
 		return nil, err
 	}
 
 	// set default backend for the second layer
 	for _, varnish := range t.secondL {
-		varnish.SetBackend(t.backend)
+		varnish.SetBackend(backend)
 	}
 
 	// set director distributing requests to the second layer
+	t.directors = nil
 	for _, varnish := range t.firstL {
-		director := model.NewShardDirector()
+		director, err := model.NewShardDirectorWithParams(t.sharding)
+		if err != nil {
+			return nil, err
+		}
 		for _, secondLayerVarnish := range t.secondL {
 			director.AddBackend(secondLayerVarnish)
 		}
 
 		varnish.SetDirector(director)
+		t.directors = append(t.directors, director)
 	}
 
+	t.routeSites(t.firstL, t.secondL)
+
 	// return all Varnish proxies that are placed in front.
 	// requests will be made to these proxies
 	return t.firstL, nil
 }
 
-func (t *TwoLayerSharded) Step() error {
+func (t *TwoLayerSharded) Step(now time.Time) error {
 	var err error
 	for _, varnish := range t.firstL {
-		err = WriteStep(varnish)
+		err = WriteStep(varnish, now)
 	}
 
 	for _, varnish := range t.secondL {
-		err = WriteStep(varnish)
+		err = WriteStep(varnish, now)
 	}
+	err = t.stepOrigins(now)
 
 	return err
 }
@@ -175,6 +249,11 @@ func (t *TwoLayerSharded) PrintResultsCB(isJson bool) func() error {
 	return t.PrintResultsTable
 }
 
+// latencyReport returns response times of both layers
+func (t *TwoLayerSharded) latencyReport() *model.LatencyReport {
+	return model.NewLatencyReport(t.firstL).AddLayer("first", t.firstL).AddLayer("second", t.secondL)
+}
+
 // PrintResultsTable prints the results in a table format
 func (t *TwoLayerSharded) PrintResultsTable() error {
 	for _, varnish := range t.firstL {
@@ -185,6 +264,14 @@ func (t *TwoLayerSharded) PrintResultsTable() error {
 		varnish.PrintResult()
 	}
 
+	model.PrintTable(model.NewOriginOffload(t.firstL, t.Origins()))
+	t.printSites()
+	printLatency(t.latencyReport())
+	printSaturation(model.NewSaturation(t.Proxies(), t.Origins()))
+	printPlacement(model.NewShardPlacement(t.directors...))
+	printShardLoad(model.NewShardLoad(t.hotKeys, t.directors...))
+	printReplication(model.NewReplication(t.directors...))
+
 	return nil
 }
 
@@ -200,8 +287,16 @@ func (t *TwoLayerSharded) PrintResultsJSON() error {
 		proxies = append(proxies, varnish.Export())
 	}
 
-	// add default backend to the list
-	proxies = append(proxies, t.backend.Export())
+	// add origins to the list
+	proxies = append(proxies, t.exportOrigins()...)
+	proxies = append(proxies, model.NewOriginOffload(t.firstL, t.Origins()).Export())
+	proxies = append(proxies, t.latencyReport().Export())
+	proxies = append(proxies, model.NewSaturation(t.Proxies(), t.Origins()).Export())
+	proxies = append(proxies, model.NewShardPlacement(t.directors...).Export())
+	proxies = append(proxies, model.NewShardLoad(t.hotKeys, t.directors...).Export())
+	if replication := model.NewReplication(t.directors...); replication.Enabled() {
+		proxies = append(proxies, replication.Export())
+	}
 
 	raw, err := json.MarshalIndent(proxies, "", " ")
 	if err != nil {
@@ -11,12 +11,13 @@ package cases
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"varnish_sim/model"
 )
 
 type TwoLayer struct {
-	// default backend that serves `all` requests
-	backend *model.Backend
+	// origins serve requests that missed all proxies
+	originTier
 
 	// layers of Varnish proxies
 	firstL  []*model.VarnishProxy
@@ -27,23 +28,26 @@ type TwoLayer struct {
 }
 
 func (t *TwoLayer) SetUp() ([]*model.VarnishProxy, error) {
-	// initialize default backend that serves `all` requests
-	t.backend = &model.Backend{Hostname: "default"}
+	// initialize origins that serve `all` requests
+	backend, err := t.setUpOrigins()
+	if err != nil {
+		return nil, err
+	}
 
 	// fill layers with Varnish proxies
-	err := fillVarnishProxies(&t.secondL, "2", t.config.SecondLayer.Amount, t.config.SecondLayer.CacheSize)
+	err = fillVarnishProxies(&t.secondL, "2", t.config.SecondLayer)
 	if err != nil {
 		return nil, err
 	}
 
-	err = fillVarnishProxies(&t.firstL, "1", t.config.FirstLayer.Amount, t.config.FirstLayer.CacheSize)
+	err = fillVarnishProxies(&t.firstL, "1", t.config.FirstLayer)
 	if err != nil {
 		return nil, err
 	}
 
 	// set default backend for the second layer
 	for _, varnish := range t.secondL {
-		varnish.SetBackend(t.backend)
+		varnish.SetBackend(backend)
 	}
 
 	// set respective backends for the first layer
@@ -51,24 +55,32 @@ func (t *TwoLayer) SetUp() ([]*model.VarnishProxy, error) {
 		varnish.SetBackend(t.secondL[i])
 	}
 
+	t.routeSites(t.firstL, t.secondL)
+
 	// return all Varnish proxies that are placed in front.
 	// requests will be made to these proxies
 	return t.firstL, nil
 }
 
+// Proxies returns Varnish proxies of both layers
+func (t *TwoLayer) Proxies() []*model.VarnishProxy {
+	return append(append([]*model.VarnishProxy{}, t.firstL...), t.secondL...)
+}
+
 func (t *TwoLayer) Validate() error {
 	return t.config.Validate()
 }
 
-func (t *TwoLayer) Step() error {
+func (t *TwoLayer) Step(now time.Time) error {
 	var err error
 	for _, varnish := range t.firstL {
-		err = WriteStep(varnish)
+		err = WriteStep(varnish, now)
 	}
 
 	for _, varnish := range t.secondL {
-		err = WriteStep(varnish)
+		err = WriteStep(varnish, now)
 	}
+	err = t.stepOrigins(now)
 
 	return err
 }
@@ -80,6 +92,11 @@ func (t *TwoLayer) PrintResultsCB(isJson bool) func() error {
 	return t.PrintResultsTable
 }
 
+// latencyReport returns response times of both layers
+func (t *TwoLayer) latencyReport() *model.LatencyReport {
+	return model.NewLatencyReport(t.firstL).AddLayer("first", t.firstL).AddLayer("second", t.secondL)
+}
+
 // PrintResultsTable prints the results in a table format
 func (t *TwoLayer) PrintResultsTable() error {
 	for _, varnish := range t.firstL {
@@ -90,6 +107,11 @@ func (t *TwoLayer) PrintResultsTable() error {
 		varnish.PrintResult()
 	}
 
+	model.PrintTable(model.NewOriginOffload(t.firstL, t.Origins()))
+	t.printSites()
+	printLatency(t.latencyReport())
+	printSaturation(model.NewSaturation(t.Proxies(), t.Origins()))
+
 	return nil
 }
 
@@ -105,8 +127,11 @@ func (t *TwoLayer) PrintResultsJSON() error {
 		proxies = append(proxies, varnish.Export())
 	}
 
-	// add default backend to the list
-	proxies = append(proxies, t.backend.Export())
+	// add origins to the list
+	proxies = append(proxies, t.exportOrigins()...)
+	proxies = append(proxies, model.NewOriginOffload(t.firstL, t.Origins()).Export())
+	proxies = append(proxies, t.latencyReport().Export())
+	proxies = append(proxies, model.NewSaturation(t.Proxies(), t.Origins()).Export())
 
 	raw, err := json.MarshalIndent(proxies, "", " ")
 	if err != nil {
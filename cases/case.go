@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 	"varnish_sim/model"
 )
 
@@ -23,11 +24,39 @@ type Case interface {
 	// Validate checks if the case is valid
 	Validate() error
 
-	Step() error
+	// Origins returns backends serving requests that missed all proxies
+	Origins() []*model.Backend
+
+	// Proxies returns all Varnish proxies of the case
+	Proxies() []*model.VarnishProxy
+
+	// SetRoutes sets the routing table of requests to origins by hosts
+	// and URL prefixes, it is used by SetUp
+	SetRoutes([]model.Route)
+
+	// Step writes metrics of the case at the time of virtual clock
+	Step(time.Time) error
 
 	PrintResultsCB(bool) func() error
 }
 
+// ShardedCase is a case with shard directors, it reports load of their shards
+type ShardedCase interface {
+	// SetHotKeys sets an amount of the hottest keys reported for each shard
+	SetHotKeys(int)
+}
+
+// shardReports are settings of reports of shard directors of a case
+type shardReports struct {
+	// hotKeys is an amount of the hottest keys reported for each shard
+	hotKeys int
+}
+
+// SetHotKeys sets an amount of the hottest keys reported for each shard
+func (r *shardReports) SetHotKeys(n int) {
+	r.hotKeys = n
+}
+
 // CaseConfig is an interface for a configuration of a simulation case
 type CaseConfig interface {
 	// String returns the name of the case
@@ -63,16 +92,59 @@ func store(c CaseConfig) error {
 }
 
 type StepConfig struct {
-	StepInterval int `json:"step_interval"`
+	StepInterval int           `json:"step_interval"`
+	StepTime     time.Duration `json:"step_time"`
+}
+
+// Stepper is a node of the topology writing its metrics every step
+type Stepper interface {
+	String() string
+	Step() string
+}
+
+// printLatency prints response times, if the latency is modelled
+func printLatency(report *model.LatencyReport) {
+	if report.Measured() {
+		model.PrintTable(report)
+	}
+}
+
+// printSaturation prints usage of capacity, if any node has limited workers
+func printSaturation(saturation *model.Saturation) {
+	if saturation.Limited() {
+		model.PrintTable(saturation)
+	}
+}
+
+// printPlacement prints placement of keys by shard directors, if any key was placed
+func printPlacement(placement *model.ShardPlacement) {
+	if placement.Measured() {
+		model.PrintTable(placement)
+	}
+}
+
+// printShardLoad prints load of shards, if any request was sent to a shard
+func printShardLoad(load *model.ShardLoad) {
+	if load.Measured() {
+		model.PrintTable(load)
+	}
+}
+
+// printReplication prints the cost of replication of hot keys, if shard directors replicate them
+func printReplication(replication *model.Replication) {
+	if replication.Enabled() {
+		model.PrintTable(replication)
+	}
 }
 
-func WriteStep(v *model.VarnishProxy) error {
-	f, err := os.OpenFile(fmt.Sprintf("steps/%s.step", v.Hostname()), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+// WriteStep appends a step of the node at the time to its step file
+func WriteStep(v Stepper, now time.Time) error {
+	f, err := os.OpenFile(fmt.Sprintf("steps/%s.step", v.String()), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = f.WriteString(v.Step() + "\n")
+	_, err = f.WriteString(fmt.Sprintf("%d %s\n", now.Unix(), v.Step()))
 	return err
 }
@@ -0,0 +1,97 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package cases
+
+import (
+	"time"
+	"varnish_sim/model"
+)
+
+// originTier is origins of a case, requests are routed to them by hosts
+// and URL prefixes of the routing table, or all go to the default origin
+type originTier struct {
+	routes []model.Route
+
+	// origins are the default origin followed by origins of routes
+	origins []*model.Backend
+	// router is nil if the case has no routes
+	router *model.Router
+}
+
+// SetRoutes sets the routing table of requests to origins, it is used by SetUp
+func (o *originTier) SetRoutes(routes []model.Route) {
+	o.routes = routes
+}
+
+// setUpOrigins creates the default origin and origins of routes,
+// returns the web interface proxies fetch misses from
+func (o *originTier) setUpOrigins() (model.WebInterface, error) {
+	o.origins = []*model.Backend{{Hostname: model.DefaultOrigin}}
+	o.router = nil
+	if len(o.routes) == 0 {
+		return o.origins[0], nil
+	}
+
+	seen := map[string]bool{model.DefaultOrigin: true}
+	for _, route := range o.routes {
+		if !seen[route.Origin] {
+			seen[route.Origin] = true
+			o.origins = append(o.origins, &model.Backend{Hostname: route.Origin})
+		}
+	}
+
+	router, err := model.NewRouter(o.routes, o.origins)
+	if err != nil {
+		return nil, err
+	}
+	o.router = router
+	return router, nil
+}
+
+// routeSites lets the proxies count client traffic of each site
+func (o *originTier) routeSites(proxies ...[]*model.VarnishProxy) {
+	if o.router == nil {
+		return
+	}
+	for _, layer := range proxies {
+		for _, proxy := range layer {
+			proxy.SetRouter(o.router)
+		}
+	}
+}
+
+// Origins returns the default origin followed by origins of routes
+func (o *originTier) Origins() []*model.Backend {
+	return o.origins
+}
+
+// stepOrigins writes steps of all origins
+func (o *originTier) stepOrigins(now time.Time) error {
+	var err error
+	for _, origin := range o.origins {
+		err = WriteStep(origin, now)
+	}
+	return err
+}
+
+// exportOrigins returns exports of all origins
+func (o *originTier) exportOrigins() []map[string]interface{} {
+	exports := make([]map[string]interface{}, 0)
+	for _, origin := range o.origins {
+		exports = append(exports, origin.Export())
+	}
+	return exports
+}
+
+// printSites prints offload of every site, if requests are routed
+func (o *originTier) printSites() {
+	if o.router != nil {
+		model.PrintTable(model.Sites(o.origins))
+	}
+}
@@ -0,0 +1,565 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package cases
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+	"varnish_sim/model"
+)
+
+// GroupConfig is a configuration of a named group of Varnish proxies
+// that share the cache configuration and the way they route misses.
+type GroupConfig struct {
+	Name        string `json:"name" yaml:"name"`
+	LayerConfig `yaml:",inline"`
+
+	// Director is a type of director each proxy uses to pick a backend
+	// among nodes of Backends groups and origins, empty if no director is used.
+	Director string   `json:"director,omitempty" yaml:"director"`
+	Backends []string `json:"backends,omitempty" yaml:"backends"`
+	// Params, Weights and Directors configure the director as in DirectorConfig
+	Params    model.DirectorParams `json:"params,omitempty" yaml:"params"`
+	Weights   map[string]float64   `json:"weights,omitempty" yaml:"weights"`
+	Directors []DirectorConfig     `json:"directors,omitempty" yaml:"directors"`
+
+	// Backend is a name of an origin or a group the proxies fetch from without director,
+	// or if director picks the proxy itself (e.g. shard director among peers).
+	// i-th proxy of the group fetches from i-th node of a backend group (modulo its size).
+	Backend string `json:"backend,omitempty" yaml:"backend"`
+}
+
+// DirectorConfig is a configuration of a director of vmod_directors.
+// Directors nest, a nested director is a backend of its parent (e.g. a fallback of shards).
+type DirectorConfig struct {
+	// Name is a name of the nested director, unique among backends of its parent
+	Name string `json:"name" yaml:"name"`
+	// Director is a type of the director
+	Director string               `json:"director" yaml:"director"`
+	Params   model.DirectorParams `json:"params,omitempty" yaml:"params"`
+
+	// Backends are names of groups and origins, whose nodes are backends of the director
+	Backends []string `json:"backends,omitempty" yaml:"backends"`
+	// Weights are weights of backends by names of groups, origins and nested directors,
+	// 1 if not set, only random, hash and shard directors have weights
+	Weights map[string]float64 `json:"weights,omitempty" yaml:"weights"`
+	// Directors are nested directors, that are backends of the director after Backends
+	Directors []DirectorConfig `json:"directors,omitempty" yaml:"directors"`
+}
+
+// Validate checks if the director configuration is valid,
+// names holds all names of groups and origins of the topology
+func (d *DirectorConfig) Validate(names map[string]bool) error {
+	director, err := model.NewDirector(d.Director, d.Params)
+	if err != nil {
+		return err
+	}
+	if len(d.Backends) == 0 && len(d.Directors) == 0 {
+		return fmt.Errorf("director has no backends")
+	}
+
+	members := make(map[string]bool)
+	for _, backend := range d.Backends {
+		if !names[backend] {
+			return fmt.Errorf("director backend %q is unknown", backend)
+		}
+		members[backend] = true
+	}
+	for i := range d.Directors {
+		nested := &d.Directors[i]
+		if nested.Name == "" {
+			return fmt.Errorf("nested director %d has no name", i)
+		}
+		if members[nested.Name] || names[nested.Name] {
+			return fmt.Errorf("name %q of nested director is not unique", nested.Name)
+		}
+		members[nested.Name] = true
+		if err := nested.Validate(names); err != nil {
+			return fmt.Errorf("director %s: %w", nested.Name, err)
+		}
+	}
+
+	if len(d.Weights) == 0 {
+		return nil
+	}
+	if _, ok := director.(model.WeightedDirector); !ok {
+		return fmt.Errorf("%s director has no weights", d.Director)
+	}
+	for name, weight := range d.Weights {
+		if !members[name] {
+			return fmt.Errorf("weight of unknown backend %q", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// names returns names of groups and origins used by the director and nested ones
+func (d *DirectorConfig) names() []string {
+	names := append([]string{}, d.Backends...)
+	for _, nested := range d.Directors {
+		names = append(names, nested.names()...)
+	}
+	return names
+}
+
+// TopologyConfig is a declarative description of N-layer topology
+// of Varnish proxies and origins, loaded from YAML or JSON file.
+type TopologyConfig struct {
+	// Origins are names of backends that serve all requests
+	Origins []string      `json:"origins" yaml:"origins"`
+	Groups  []GroupConfig `json:"groups" yaml:"groups"`
+
+	// Front is a name of the group receiving client requests, first group by default
+	Front string `json:"front,omitempty" yaml:"front"`
+
+	// Routes route requests to origins by hosts and URL prefixes, groups fetch
+	// through the routing table by the `router` node, the first origin is the default one
+	Routes []model.Route `json:"routes,omitempty" yaml:"routes"`
+}
+
+// LoadTopologyConfig reads topology configuration from the file
+// JSON is a subset of YAML, so both formats are read the same way.
+func LoadTopologyConfig(path string) (*TopologyConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &TopologyConfig{}
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("topology %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// String returns a string representation of TopologyConfig.
+// CaseConfig's implementation name
+func (c *TopologyConfig) String() string {
+	return "topology"
+}
+
+// Store stores the TopologyConfig to a file
+func (c *TopologyConfig) Store() error {
+	return store(c)
+}
+
+// FrontGroup returns the name of the group receiving client requests
+func (c *TopologyConfig) FrontGroup() string {
+	if c.Front == "" && len(c.Groups) > 0 {
+		return c.Groups[0].Name
+	}
+	return c.Front
+}
+
+// Validate checks if the configuration is valid
+// returns an error if a name is not unique or unknown,
+// or if the topology has a cycle
+func (c *TopologyConfig) Validate() error {
+	if len(c.Origins) == 0 {
+		return fmt.Errorf("topology should have at least one origin")
+	}
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("topology should have at least one group")
+	}
+
+	names := make(map[string]bool)
+	for _, origin := range c.Origins {
+		if names[origin] || origin == model.RouterName {
+			return fmt.Errorf("name %q is not unique", origin)
+		}
+		names[origin] = true
+	}
+	for _, route := range c.Routes {
+		if !names[route.Origin] {
+			return fmt.Errorf("route %q: unknown origin %q", route, route.Origin)
+		}
+	}
+
+	groups := make(map[string]*GroupConfig)
+	for i := range c.Groups {
+		group := &c.Groups[i]
+		if group.Name == "" {
+			return fmt.Errorf("group %d has no name", i)
+		}
+		if names[group.Name] || group.Name == model.RouterName {
+			return fmt.Errorf("name %q is not unique", group.Name)
+		}
+		names[group.Name] = true
+		groups[group.Name] = group
+	}
+	// router is a node only if there are routes
+	names[model.RouterName] = len(c.Routes) > 0
+
+	if _, ok := groups[c.FrontGroup()]; !ok {
+		return fmt.Errorf("front group %q is unknown", c.FrontGroup())
+	}
+
+	for _, group := range c.Groups {
+		if err := group.Validate(names); err != nil {
+			return fmt.Errorf("group %s: %w", group.Name, err)
+		}
+	}
+
+	return c.checkCycles(groups)
+}
+
+// checkCycles checks that every path of misses ends in an origin.
+// Group referencing itself by director is not a cycle,
+// as the proxy picked by director being itself fetches from its backend.
+func (c *TopologyConfig) checkCycles(groups map[string]*GroupConfig) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		group, ok := groups[name]
+		if !ok {
+			// origin
+			return nil
+		}
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("topology has a cycle through group %q", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		director := group.director()
+		for _, next := range append([]string{group.Backend}, director.names()...) {
+			if next == "" || next == name {
+				continue
+			}
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for _, group := range c.Groups {
+		if err := visit(group.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks if the group configuration is valid,
+// names holds all names of groups and origins of the topology
+func (g *GroupConfig) Validate(names map[string]bool) error {
+	if g.Amount < 1 {
+		return fmt.Errorf("amount should be greater than 0")
+	}
+	if err := g.LayerConfig.Validate(); err != nil {
+		return err
+	}
+
+	if g.Backend != "" && !names[g.Backend] {
+		return fmt.Errorf("backend %q is unknown", g.Backend)
+	}
+	if g.Backend == g.Name {
+		return fmt.Errorf("backend can not be the group itself")
+	}
+
+	director := g.director()
+	if g.Director == "" {
+		if len(director.names()) > 0 || len(g.Weights) > 0 {
+			return fmt.Errorf("backends are set, but director is not")
+		}
+		if g.Backend == "" {
+			return fmt.Errorf("either director or backend should be set")
+		}
+		return nil
+	}
+
+	if err := director.Validate(names); err != nil {
+		return err
+	}
+	for _, backend := range director.names() {
+		if backend == g.Name && g.Backend == "" {
+			return fmt.Errorf("director includes the group itself, backend should be set")
+		}
+	}
+	return nil
+}
+
+// director returns the configuration of the director of the group
+func (g *GroupConfig) director() DirectorConfig {
+	return DirectorConfig{
+		Name:      g.Name,
+		Director:  g.Director,
+		Params:    g.Params,
+		Backends:  g.Backends,
+		Weights:   g.Weights,
+		Directors: g.Directors,
+	}
+}
+
+// Topology is a case built from a declarative topology configuration
+type Topology struct {
+	// origins by name
+	origins map[string]*model.Backend
+	// router routes requests to origins, nil if there are no routes
+	router *model.Router
+	// proxies of each group by the group name
+	groups map[string][]*model.VarnishProxy
+
+	// configuration for the case
+	config TopologyConfig
+	shardReports
+}
+
+// NewTopology is a constructor for Topology
+func NewTopology(config TopologyConfig) *Topology {
+	return &Topology{config: config, shardReports: shardReports{hotKeys: model.DefaultHotKeys}}
+}
+
+// Validate checks if the case is valid, validates its configuration
+func (t *Topology) Validate() error {
+	return t.config.Validate()
+}
+
+// SetUp initializes origins and groups of proxies, connects them
+// and returns proxies of the front group
+func (t *Topology) SetUp() ([]*model.VarnishProxy, error) {
+	t.origins = make(map[string]*model.Backend)
+	for _, name := range t.config.Origins {
+		t.origins[name] = &model.Backend{Hostname: name}
+	}
+	t.router = nil
+	if len(t.config.Routes) > 0 {
+		router, err := model.NewRouter(t.config.Routes, t.Origins())
+		if err != nil {
+			return nil, err
+		}
+		t.router = router
+	}
+
+	t.groups = make(map[string][]*model.VarnishProxy)
+	for _, group := range t.config.Groups {
+		proxies := make([]*model.VarnishProxy, 0)
+		if err := fillVarnishProxies(&proxies, group.Name, group.LayerConfig); err != nil {
+			return nil, err
+		}
+		t.groups[group.Name] = proxies
+	}
+
+	for _, group := range t.config.Groups {
+		for i, proxy := range t.groups[group.Name] {
+			if group.Backend != "" {
+				proxy.SetBackend(t.node(group.Backend, i))
+			}
+
+			if group.Director == "" {
+				continue
+			}
+			director, err := t.director(group.director())
+			if err != nil {
+				return nil, err
+			}
+			proxy.SetDirector(director)
+		}
+	}
+
+	if t.router != nil {
+		for _, proxy := range t.Proxies() {
+			proxy.SetRouter(t.router)
+		}
+	}
+
+	return t.groups[t.config.FrontGroup()], nil
+}
+
+// director returns a new director of the configuration, with nested directors
+func (t *Topology) director(config DirectorConfig) (model.Director, error) {
+	director, err := model.NewDirector(config.Director, config.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(name string, node model.WebInterface) {
+		weight, ok := config.Weights[name]
+		if weighted, isWeighted := director.(model.WeightedDirector); ok && isWeighted {
+			weighted.AddWeightedBackend(node, weight)
+			return
+		}
+		director.AddBackend(node)
+	}
+	for _, name := range config.Backends {
+		for _, node := range t.nodes(name) {
+			add(name, node)
+		}
+	}
+	for _, nested := range config.Directors {
+		backend, err := t.director(nested)
+		if err != nil {
+			return nil, err
+		}
+		add(nested.Name, model.NewDirectorBackend(nested.Name, backend))
+	}
+	return director, nil
+}
+
+// SetRoutes adds routes to the ones of the configuration
+func (t *Topology) SetRoutes(routes []model.Route) {
+	t.config.Routes = append(t.config.Routes, routes...)
+}
+
+// Origins returns origins in order of configuration
+func (t *Topology) Origins() []*model.Backend {
+	origins := make([]*model.Backend, 0)
+	for _, name := range t.config.Origins {
+		origins = append(origins, t.origins[name])
+	}
+	return origins
+}
+
+// nodes returns all web interfaces of a group or an origin by name
+func (t *Topology) nodes(name string) []model.WebInterface {
+	if origin, ok := t.origins[name]; ok {
+		return []model.WebInterface{origin}
+	}
+	if name == model.RouterName && t.router != nil {
+		return []model.WebInterface{t.router}
+	}
+
+	nodes := make([]model.WebInterface, 0)
+	for _, proxy := range t.groups[name] {
+		nodes = append(nodes, proxy)
+	}
+	return nodes
+}
+
+// node returns i-th node of a group (modulo its size) or an origin by name
+func (t *Topology) node(name string, i int) model.WebInterface {
+	nodes := t.nodes(name)
+	return nodes[i%len(nodes)]
+}
+
+// Proxies returns proxies of all groups in order of configuration
+func (t *Topology) Proxies() []*model.VarnishProxy {
+	proxies := make([]*model.VarnishProxy, 0)
+	for _, group := range t.config.Groups {
+		proxies = append(proxies, t.groups[group.Name]...)
+	}
+	return proxies
+}
+
+func (t *Topology) Step(now time.Time) error {
+	var err error
+	for _, varnish := range t.Proxies() {
+		err = WriteStep(varnish, now)
+	}
+	for _, origin := range t.Origins() {
+		err = WriteStep(origin, now)
+	}
+
+	return err
+}
+
+// PrintResultsCB returns a callback for printing results
+func (t *Topology) PrintResultsCB(isJson bool) func() error {
+	if isJson {
+		return t.PrintResultsJSON
+	}
+	return t.PrintResultsTable
+}
+
+// latencyReport returns response times of every group
+func (t *Topology) latencyReport() *model.LatencyReport {
+	report := model.NewLatencyReport(t.groups[t.config.FrontGroup()])
+	for _, group := range t.config.Groups {
+		report.AddLayer(group.Name, t.groups[group.Name])
+	}
+	return report
+}
+
+// shardDirectors returns shard directors of every group using one
+func (t *Topology) shardDirectors() [][]*model.ShardDirector {
+	groups := make([][]*model.ShardDirector, 0)
+	for _, group := range t.config.Groups {
+		directors := make([]*model.ShardDirector, 0)
+		for _, proxy := range t.groups[group.Name] {
+			if director, ok := proxy.Director().(*model.ShardDirector); ok {
+				directors = append(directors, director)
+			}
+		}
+		if len(directors) > 0 {
+			groups = append(groups, directors)
+		}
+	}
+	return groups
+}
+
+// PrintResultsTable prints the results in a table format
+func (t *Topology) PrintResultsTable() error {
+	for _, varnish := range t.Proxies() {
+		varnish.PrintResult()
+	}
+
+	model.PrintTable(model.NewOriginOffload(t.Proxies(), t.Origins()))
+	if t.router != nil {
+		model.PrintTable(model.Sites(t.Origins()))
+	}
+	printLatency(t.latencyReport())
+	printSaturation(model.NewSaturation(t.Proxies(), t.Origins()))
+	for _, directors := range t.shardDirectors() {
+		printPlacement(model.NewShardPlacement(directors...))
+		printShardLoad(model.NewShardLoad(t.hotKeys, directors...))
+		printReplication(model.NewReplication(directors...))
+	}
+
+	return nil
+}
+
+// PrintResultsJSON prints the results in a JSON format
+func (t *Topology) PrintResultsJSON() error {
+	nodes := make([]map[string]interface{}, 0)
+
+	for _, varnish := range t.Proxies() {
+		nodes = append(nodes, varnish.Export())
+	}
+
+	for _, name := range t.config.Origins {
+		nodes = append(nodes, t.origins[name].Export())
+	}
+
+	nodes = append(nodes, model.NewOriginOffload(t.Proxies(), t.Origins()).Export())
+	nodes = append(nodes, t.latencyReport().Export())
+	nodes = append(nodes, model.NewSaturation(t.Proxies(), t.Origins()).Export())
+	for _, directors := range t.shardDirectors() {
+		nodes = append(nodes, model.NewShardPlacement(directors...).Export())
+		nodes = append(nodes, model.NewShardLoad(t.hotKeys, directors...).Export())
+		if replication := model.NewReplication(directors...); replication.Enabled() {
+			nodes = append(nodes, replication.Export())
+		}
+	}
+
+	raw, err := json.MarshalIndent(nodes, "", " ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(raw))
+
+	return nil
+}
@@ -0,0 +1,164 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package cases
+
+import (
+	"testing"
+	"varnish_sim/model"
+)
+
+func group(name string, director string, backends []string, backend string) GroupConfig {
+	return GroupConfig{
+		Name:        name,
+		LayerConfig: LayerConfig{Amount: 2, CacheSize: 100},
+		Director:    director,
+		Backends:    backends,
+		Backend:     backend,
+	}
+}
+
+func TestTopologyValidate(t *testing.T) {
+	valid := TopologyConfig{
+		Origins: []string{"origin"},
+		Groups: []GroupConfig{
+			group("edge", model.ShardDirectorName, []string{"shield"}, ""),
+			group("shield", model.ShardDirectorName, []string{"shield"}, "origin"),
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	invalid := map[string]TopologyConfig{
+		"cycle": {
+			Origins: []string{"origin"},
+			Groups:  []GroupConfig{group("a", "", nil, "b"), group("b", "", nil, "a")},
+		},
+		"unknown backend": {
+			Origins: []string{"origin"},
+			Groups:  []GroupConfig{group("a", "", nil, "nowhere")},
+		},
+		"self without backend": {
+			Origins: []string{"origin"},
+			Groups:  []GroupConfig{group("a", model.ShardDirectorName, []string{"a"}, "")},
+		},
+		"duplicate name": {
+			Origins: []string{"a"},
+			Groups:  []GroupConfig{group("a", "", nil, "a")},
+		},
+	}
+	for name, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Fatalf("error: %s topology should be invalid", name)
+		}
+	}
+}
+
+func TestTopologySetUp(t *testing.T) {
+	topology := NewTopology(TopologyConfig{
+		Origins: []string{"origin"},
+		Groups: []GroupConfig{
+			group("edge", "", nil, "shield"),
+			group("shield", model.RoundRobinDirectorName, []string{"origin"}, ""),
+		},
+	})
+	if err := topology.Validate(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	front, err := topology.SetUp()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(front) != 2 || front[0].Hostname() != "edge-0" {
+		t.Fatalf("error: unexpected front proxies %v", front)
+	}
+
+	front[1].Get(model.NewRequest("/obj", 10))
+	if topology.origins["origin"].Export()["backend"].(map[string]interface{})["requests"] != 1 {
+		t.Fatalf("error: request did not reach the origin")
+	}
+}
+
+func TestTopologyRoutes(t *testing.T) {
+	config := TopologyConfig{
+		Origins: []string{"www", "api"},
+		Groups:  []GroupConfig{group("edge", "", nil, model.RouterName)},
+	}
+	if err := config.Validate(); err == nil {
+		t.Fatalf("error: router without routes should be unknown")
+	}
+
+	topology := NewTopology(config)
+	topology.SetRoutes([]model.Route{{Prefix: "/api", Origin: "api"}})
+	if err := topology.Validate(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	front, err := topology.SetUp()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for _, url := range []string{"/", "/api/a", "/api/a", "/img"} {
+		front[0].Get(model.NewRequest(url, 10))
+	}
+	if www := topology.origins["www"].Offload(); www.Requests != 2 || www.OriginRequests != 2 {
+		t.Fatalf("error: unexpected offload of www %+v", www)
+	}
+	if api := topology.origins["api"].Offload(); api.Requests != 2 || api.OriginRequests != 1 || api.RequestOffload() != 0.5 {
+		t.Fatalf("error: unexpected offload of api %+v", api)
+	}
+}
+
+func TestTopologyNestedDirectors(t *testing.T) {
+	edge := group("edge", model.FallbackDirectorName, nil, "")
+	edge.Directors = []DirectorConfig{
+		{Name: "primary", Director: model.ShardDirectorName, Backends: []string{"shield-a"}, Params: model.DirectorParams{Replicas: 67}},
+		{Name: "secondary", Director: model.RandomDirectorName, Backends: []string{"shield-b", "origin"}, Weights: map[string]float64{"origin": 0}},
+	}
+	config := TopologyConfig{
+		Origins: []string{"origin"},
+		Groups:  []GroupConfig{edge, group("shield-a", "", nil, "origin"), group("shield-b", "", nil, "origin")},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	topology := NewTopology(config)
+	front, err := topology.SetUp()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	front[0].Get(model.NewRequest("/obj", 10))
+	if routes := front[0].Export()["edge-0"].(map[string]interface{})["routes_to"]; len(routes.([]string)) != 2 {
+		t.Fatalf("error: unexpected routes %v", routes)
+	}
+	for _, shield := range topology.groups["shield-a"] {
+		shield.SetHealthy(false)
+	}
+	req := model.NewRequest("/other", 10)
+	front[0].Get(req)
+	if req.Failed || topology.origins["origin"].Requests() != 2 {
+		t.Fatalf("error: request did not fall back to the secondary director")
+	}
+
+	invalid := map[string]DirectorConfig{
+		"weights of fallback": {Name: "a", Director: model.FallbackDirectorName, Backends: []string{"origin"}, Weights: map[string]float64{"origin": 2}},
+		"unnamed":             {Director: model.ShardDirectorName, Backends: []string{"origin"}},
+		"no backends":         {Name: "a", Director: model.ShardDirectorName},
+		"warmup":              {Name: "a", Director: model.ShardDirectorName, Backends: []string{"origin"}, Params: model.DirectorParams{Warmup: 2}},
+	}
+	for name, nested := range invalid {
+		edge.Directors = []DirectorConfig{nested}
+		config.Groups[0] = edge
+		if err := config.Validate(); err == nil {
+			t.Fatalf("error: topology with %s nested director should be invalid", name)
+		}
+	}
+}
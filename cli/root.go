@@ -9,7 +9,10 @@
 package cli
 
 import (
+	"fmt"
 	"github.com/spf13/cobra"
+	"strings"
+	"varnish_sim/model"
 	"varnish_sim/simulation/providers"
 )
 
@@ -33,10 +36,41 @@ func setUpRoot() {
 
 	// step-interval
 	root.PersistentFlags().IntP("step-interval", "", 100, "interval between steps in req count")
+	root.PersistentFlags().DurationP("step-time", "", 0, "interval between steps in trace time, overrides step-interval")
 	root.PersistentFlags().StringP("provider", "p", "", providerFlagUsage)
 	root.PersistentFlags().BoolP("json", "", false, "print json output")
-	// not implemented yet
-	root.PersistentFlags().StringP("load-balancer", "l", "", "load balancer used to distribute requests for front(edge) proxies")
+	root.PersistentFlags().StringP("log-format", "", "", "format of lines passed to the provider, either a custom varnishncsa -F format string"+
+		"\nor one of predefined formats: "+strings.Join(providers.LogFormats(), " ")+
+		"\nby default, lines are split by VSIM_FRMT_* environment variables")
+	root.PersistentFlags().StringArrayP("down", "", nil, "schedule a failure of nodes in node[,node...]@from[-to] format, may be repeated."+
+		"\nfrom and to are either counts of requests, e.g. proxy-1,proxy-2@1000000-1500000,"+
+		"\nor durations since the start of the trace, e.g. proxy-1@1h-90m. Without `to` nodes stay down")
+	root.PersistentFlags().StringArrayP("scale", "", nil, "schedule proxies joining or leaving all their directors in join|leave:node[,node...]@at format,"+
+		"\nmay be repeated. at is either a count of requests or a duration since the start of the trace."+
+		"\nProxies, whose first event is join, are not members until it and join with cold caches")
+	root.PersistentFlags().IntP("recovery-window", "", 10000, "window in requests, offload is measured in after a scale event")
+	root.PersistentFlags().Float64P("recovery-tolerance", "", 0.01, "offload is recovered after a scale event, when it is within tolerance of the one before")
+	root.PersistentFlags().StringP("origin-time", "", "", "service time of origins, requests for objects being fetched wait for the fetch instead of fetching again."+
+		"\neither a duration or a distribution: constant:<d> uniform:<min>,<max> exponential:<mean>"+
+		"\nlognormal:<median>,<sigma> pareto:<scale>,<shape>")
+	root.PersistentFlags().IntP("origin-workers", "", 0, "amount of workers of origins, 0 is unlimited")
+	root.PersistentFlags().IntP("origin-queue", "", 0, "length of queues of origins, requests arriving at the full queue are rejected")
+	root.PersistentFlags().DurationP("client-rtt", "", 0, "RTT of links of clients to front proxies")
+	root.PersistentFlags().Float64P("client-bandwidth", "", 0, "bandwidth in bytes per second of links of clients to front proxies, 0 is unlimited")
+	root.PersistentFlags().StringArrayP("route", "", nil, "route requests to a named origin in [host][/prefix]=origin format, may be repeated."+
+		"\nHost may be a wildcard like *.example.com, requests no route matches go to the default origin")
+	root.PersistentFlags().StringArrayP("uncacheable", "", nil, "regular expression matching URLs of uncacheable objects, may be repeated")
+	root.PersistentFlags().StringP("markers", "", model.HitForMiss, "markers replacing uncacheable objects: "+strings.Join(model.Markers(), " "))
+	root.PersistentFlags().DurationP("marker-ttl", "", model.DefaultMarkerTTL, "TTL of markers of uncacheable objects, 0 creates no markers")
+	root.PersistentFlags().IntP("object-overhead", "", 0, "bytes of metadata of each cached object charged to the cache, Varnish takes about 1024, 0 is none")
+	root.PersistentFlags().IntP("size-classes", "", 0, "size classes per doubling of size, allocations of cached objects are rounded up to, jemalloc has 4, 0 rounds them to 8 bytes")
+	root.PersistentFlags().BoolP("optimal", "", false, "simulate the offline optimal storage beside the cache of each proxy to report the gap to optimal,"+
+		" the trace is read twice, as its future is needed; next uses are of the whole trace,"+
+		" so the bound is approximate for proxies seeing a part of requests of their objects")
+	root.PersistentFlags().IntP("hot-keys", "", model.DefaultHotKeys, fmt.Sprintf("amount of the hottest keys reported for each shard of sharded layers, up to %d", model.MaxHotKeys))
+	root.PersistentFlags().StringP("load-balancer", "l", model.RoundRobinBalancer, "load balancer used to distribute requests for front(edge) proxies: "+strings.Join(model.LoadBalancers(), " ")+
+		"\nrandom takes an optional seed as random:<seed>, source-ip hashes the client column (URL if it is empty),"+
+		"\nweighted takes weights of front proxies as weighted:<proxy>=<weight>,... proxies not listed have weight 1")
 }
 
 // Run runs the CLI
@@ -9,10 +9,15 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
+	"strings"
+	"time"
 	"varnish_sim/cases"
+	"varnish_sim/model"
 	"varnish_sim/simulation"
+	"varnish_sim/simulation/providers"
 )
 
 func init() {
@@ -25,6 +30,474 @@ func fillCasesCmd() {
 	root.AddCommand(OneLayerCmd())
 	root.AddCommand(TwoLayerCmd())
 	root.AddCommand(OneLayerShardedCmd())
+	root.AddCommand(TopologyCmd())
+	root.AddCommand(SweepCmd())
+}
+
+// runCase validates and sets up the case, then runs the simulation
+// with settings taken from persistent flags of the root command
+func runCase(c cases.Case, args []string) error {
+	providerFlag := root.Flag("provider")
+	if providerFlag == nil {
+		return fmt.Errorf("provider flag is not set")
+	}
+
+	routes, err := caseRoutes()
+	if err != nil {
+		return err
+	}
+	c.SetRoutes(routes)
+
+	err = c.Validate()
+	if err != nil {
+		return err
+	}
+
+	frontProxies, err := c.SetUp()
+	if err != nil {
+		return err
+	}
+
+	if err := setUpLatency(c, frontProxies); err != nil {
+		return err
+	}
+	if err := setUpOriginCapacity(c); err != nil {
+		return err
+	}
+
+	hotKeys, err := root.Flags().GetInt("hot-keys")
+	if err != nil {
+		return err
+	}
+	if hotKeys < 0 || hotKeys > model.MaxHotKeys {
+		return fmt.Errorf("amount of hot keys must be between 0 and %d", model.MaxHotKeys)
+	}
+	if sharded, ok := c.(cases.ShardedCase); ok {
+		sharded.SetHotKeys(hotKeys)
+	}
+
+	markers, markerTTL, err := objectMarkers()
+	if err != nil {
+		return err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetMarkers(markers, markerTTL)
+	}
+
+	overhead, err := objectOverhead()
+	if err != nil {
+		return err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetOverhead(overhead)
+	}
+
+	jsonFlag := root.Flag("json")
+	isJson := jsonFlag.Value.String() == "true"
+
+	interval, err := root.Flags().GetInt("step-interval")
+	if err != nil {
+		return err
+	}
+
+	stepTime, err := root.Flags().GetDuration("step-time")
+	if err != nil {
+		return err
+	}
+
+	formatter, err := logFormatter()
+	if err != nil {
+		return err
+	}
+
+	rules, err := uncacheableRules()
+	if err != nil {
+		return err
+	}
+
+	if err := setUpOptimal(c, args, formatter, rules); err != nil {
+		return err
+	}
+
+	schedules, err := caseSchedules(c)
+	if err != nil {
+		return err
+	}
+
+	balancerSpec, err := root.Flags().GetString("load-balancer")
+	if err != nil {
+		return err
+	}
+	balancer, err := model.NewLoadBalancer(balancerSpec, frontProxies)
+	if err != nil {
+		return err
+	}
+
+	printResults := c.PrintResultsCB(isJson)
+
+	return simulation.Run(
+		balancer,
+		model.NewEngine(c.Proxies(), c.Origins()),
+		args,
+		formatter,
+		rules,
+		providerFlag.Value.String(),
+		func() error {
+			if err := printResults(); err != nil {
+				return err
+			}
+			if err := printPicks(balancer, isJson); err != nil {
+				return err
+			}
+			return printReports(schedules, isJson)
+		},
+		interval,
+		stepTime,
+		c.Step,
+		schedules,
+	)
+}
+
+// setUpOptimal scans the trace ahead of the simulation, if next uses of objects are needed
+// by proxies of the optimal policy or by the offline optimal storages measuring the gap to optimal
+func setUpOptimal(c cases.Case, args []string, formatter func(string) *providers.Request, rules *providers.UncacheableRules) error {
+	enabled, err := root.Flags().GetBool("optimal")
+	if err != nil {
+		return err
+	}
+
+	for _, proxy := range c.Proxies() {
+		proxy.SetOptimal(enabled)
+	}
+	if !enabled && !evictsOptimally(c.Proxies()) {
+		return nil
+	}
+
+	future, err := simulation.Scan(args, formatter, rules, root.Flag("provider").Value.String())
+	if err != nil {
+		return err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetFuture(future)
+	}
+	return nil
+}
+
+// objectMarkers returns the kind and TTL of markers of uncacheable objects set by the flags
+func objectMarkers() (string, time.Duration, error) {
+	kind, err := root.Flags().GetString("markers")
+	if err != nil {
+		return "", 0, err
+	}
+	if err := model.ValidateMarker(kind); err != nil {
+		return "", 0, err
+	}
+	ttl, err := root.Flags().GetDuration("marker-ttl")
+	if err != nil {
+		return "", 0, err
+	}
+	return kind, ttl, nil
+}
+
+// objectOverhead returns the memory overhead of cached objects set by the flags
+func objectOverhead() (model.Overhead, error) {
+	var overhead model.Overhead
+	var err error
+	if overhead.PerObject, err = root.Flags().GetInt("object-overhead"); err != nil {
+		return overhead, err
+	}
+	if overhead.SizeClasses, err = root.Flags().GetInt("size-classes"); err != nil {
+		return overhead, err
+	}
+	return overhead, overhead.Validate()
+}
+
+// evictsOptimally returns if any proxy has a storage of the optimal policy
+func evictsOptimally(proxies []*model.VarnishProxy) bool {
+	for _, proxy := range proxies {
+		for _, policy := range proxy.CacheEvictions() {
+			if policy == model.OptimalPolicy {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// caseSchedules returns schedules of failures and membership events
+// set by the flags for nodes of the case
+func caseSchedules(c cases.Case) ([]simulation.Schedule, error) {
+	schedules := make([]simulation.Schedule, 0)
+
+	specs, err := root.Flags().GetStringArray("down")
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) > 0 {
+		failures := make([]simulation.Failure, 0)
+		for _, spec := range specs {
+			failure, err := simulation.ParseFailure(spec)
+			if err != nil {
+				return nil, err
+			}
+			failures = append(failures, failure)
+		}
+
+		schedule, err := simulation.NewFailureSchedule(failures, c.Proxies(), c.Origins())
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	specs, err = root.Flags().GetStringArray("scale")
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) > 0 {
+		events := make([]simulation.MembershipEvent, 0)
+		for _, spec := range specs {
+			event, err := simulation.ParseMembershipEvent(spec)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+
+		window, err := root.Flags().GetInt("recovery-window")
+		if err != nil {
+			return nil, err
+		}
+		tolerance, err := root.Flags().GetFloat64("recovery-tolerance")
+		if err != nil {
+			return nil, err
+		}
+
+		schedule, err := simulation.NewMembershipSchedule(events, c.Proxies(), c.Origins(), window, tolerance)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// printPicks prints requests each front proxy got from the load balancer
+func printPicks(balancer model.LoadBalancer, isJson bool) error {
+	picks := model.NewPicks(balancer)
+	if !isJson {
+		model.PrintTable(picks)
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(picks.Export(), "", " ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+	return nil
+}
+
+// printReports prints impacts of changes made by the schedules
+func printReports(schedules []simulation.Schedule, isJson bool) error {
+	reports := make([]simulation.Report, 0)
+	for _, schedule := range schedules {
+		reports = append(reports, schedule.Reports()...)
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+
+	if !isJson {
+		for _, report := range reports {
+			model.PrintTable(report)
+		}
+		return nil
+	}
+
+	exports := make([]map[string]interface{}, 0)
+	for _, report := range reports {
+		exports = append(exports, report.Export())
+	}
+	raw, err := json.MarshalIndent(exports, "", " ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+
+	return nil
+}
+
+// setUpLatency sets the service time of origins and the link of clients to front proxies
+func setUpLatency(c cases.Case, frontProxies []*model.VarnishProxy) error {
+	spec, err := root.Flags().GetString("origin-time")
+	if err != nil {
+		return err
+	}
+	if spec != "" {
+		serviceTime, err := model.ParseServiceTime(spec)
+		if err != nil {
+			return err
+		}
+		for _, origin := range c.Origins() {
+			origin.ServiceTime = serviceTime
+		}
+	}
+
+	link := model.Link{}
+	if link.RTT, err = root.Flags().GetDuration("client-rtt"); err != nil {
+		return err
+	}
+	if link.Bandwidth, err = root.Flags().GetFloat64("client-bandwidth"); err != nil {
+		return err
+	}
+	if err := link.Validate(); err != nil {
+		return fmt.Errorf("client link: %w", err)
+	}
+	for _, proxy := range frontProxies {
+		proxy.SetClientLink(link)
+	}
+	return nil
+}
+
+// setUpOriginCapacity sets workers and queue length of origins
+func setUpOriginCapacity(c cases.Case) error {
+	capacity := model.Capacity{}
+	var err error
+	if capacity.Workers, err = root.Flags().GetInt("origin-workers"); err != nil {
+		return err
+	}
+	if capacity.Queue, err = root.Flags().GetInt("origin-queue"); err != nil {
+		return err
+	}
+	if err := capacity.Validate(); err != nil {
+		return fmt.Errorf("origins: %w", err)
+	}
+	for _, origin := range c.Origins() {
+		origin.SetCapacity(capacity)
+	}
+	return nil
+}
+
+// caseRoutes returns routes of requests to origins set by the flag
+func caseRoutes() ([]model.Route, error) {
+	specs, err := root.Flags().GetStringArray("route")
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]model.Route, 0)
+	for _, spec := range specs {
+		route, err := model.ParseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// uncacheableRules returns rules marking uncacheable objects set by the flag
+func uncacheableRules() (*providers.UncacheableRules, error) {
+	patterns, err := root.Flags().GetStringArray("uncacheable")
+	if err != nil {
+		return nil, err
+	}
+	return providers.NewUncacheableRules(patterns)
+}
+
+// logFormatter returns a formatter for the log format set by the flag,
+// nil formatter lets the provider use its default one
+func logFormatter() (func(string) *providers.Request, error) {
+	name, err := root.Flags().GetString("log-format")
+	if err != nil || name == "" {
+		return nil, err
+	}
+
+	format, err := providers.NewLogFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	return format.Format, nil
+}
+
+// evictionUsage returns usage of an eviction flag listing available policies
+func evictionUsage(usage string) string {
+	return usage + "\navailable policies: " + strings.Join(model.EvictionPolicies(), " ")
+}
+
+// admissionUsage returns usage of an admission flag listing available policies
+func admissionUsage(usage string) string {
+	return usage + " in name[:param=value,...] format, empty admits all objects:" +
+		"\ntinylfu[:window=0.01] second-hit[:bits=1048576,hashes=4] max-size:bytes=N adaptsize[:c=1048576,seed=1]"
+}
+
+// poolFlags adds flags for storage pools of proxies and rules selecting them
+// [in] prefix: a prefix of the flag names
+// [in] suffix: a suffix of the flag usages, describing the layer
+func poolFlags(cmd *cobra.Command, pools, rules *[]string, prefix, suffix string) {
+	cmd.Flags().StringArrayVar(pools, prefix+"pool", nil, "Storage pool of Varnish proxies"+suffix+" besides the main one ("+model.DefaultPool+
+		") in name:size[:eviction] format, may be repeated.\nPool named "+model.TransientPool+" stores objects living shorter than "+model.Shortlived.String())
+	cmd.Flags().StringArrayVar(rules, prefix+"pool-rule", nil, "Rule selecting the pool objects of Varnish proxies"+suffix+" are stored in, in pool:condition=value,... format,"+
+		" may be repeated.\nConditions are max-ttl, min-size, max-size and url, a regular expression, that is the last one. The first matching rule wins")
+}
+
+// parsePools sets storage pools and rules parsed from flags to the layer
+func parsePools(layer *cases.LayerConfig, pools, rules []string) error {
+	for _, spec := range pools {
+		pool, err := model.ParsePool(spec)
+		if err != nil {
+			return err
+		}
+		layer.Pools = append(layer.Pools, pool)
+	}
+	for _, spec := range rules {
+		rule, err := model.ParsePoolRule(spec)
+		if err != nil {
+			return err
+		}
+		layer.PoolRules = append(layer.PoolRules, rule)
+	}
+	return nil
+}
+
+// lifetimeFlags adds flags for default TTL, grace and keep of cached objects
+// [in] prefix: a prefix of the flag names
+// [in] suffix: a suffix of the flag usages, describing the layer
+func lifetimeFlags(cmd *cobra.Command, l *model.Lifetime, prefix, suffix string) {
+	cmd.Flags().DurationVar(&l.TTL, prefix+"ttl", 0, "Default TTL of objects cached by Varnish proxies"+suffix+", 0 keeps objects until nuked")
+	cmd.Flags().DurationVar(&l.Grace, prefix+"grace", 0, "Default grace of objects cached by Varnish proxies"+suffix)
+	cmd.Flags().DurationVar(&l.Keep, prefix+"keep", 0, "Default keep of objects cached by Varnish proxies"+suffix)
+}
+
+// linkFlags adds flags for RTT and bandwidth of links of proxies to their backends
+// [in] prefix: a prefix of the flag names
+// [in] suffix: a suffix of the flag usages, describing the layer
+func linkFlags(cmd *cobra.Command, l *model.Link, prefix, suffix string) {
+	cmd.Flags().DurationVar(&l.RTT, prefix+"rtt", 0, "RTT of links of Varnish proxies"+suffix+" to their backends")
+	cmd.Flags().Float64Var(&l.Bandwidth, prefix+"bandwidth", 0, "Bandwidth in bytes per second of links of Varnish proxies"+suffix+" to their backends, 0 is unlimited")
+}
+
+// capacityFlags adds flags for workers and queue length of proxies
+// [in] prefix: a prefix of the flag names
+// [in] suffix: a suffix of the flag usages, describing the layer
+func capacityFlags(cmd *cobra.Command, c *model.Capacity, prefix, suffix string) {
+	cmd.Flags().IntVar(&c.Workers, prefix+"workers", 0, "Amount of workers of Varnish proxies"+suffix+", 0 is unlimited")
+	cmd.Flags().IntVar(&c.Queue, prefix+"queue", 0, "Length of queues of Varnish proxies"+suffix+", requests arriving at the full queue are rejected")
+}
+
+// shardFlags adds flags for the hashing scheme and parameters of shard directors
+// [in] suffix: a suffix of the flag usages, describing the layer
+func shardFlags(cmd *cobra.Command, p *model.DirectorParams, suffix string) {
+	cmd.Flags().StringVar(&p.Hashing, "hashing", model.BoundedLoadHashing, "Hashing scheme of shard directors"+suffix+" in name[:param=value,...] format:"+
+		"\nbounded-load[:partitions=271,replicas=20,load=1.25] ketama[:points=160] rendezvous maglev[:size=65537] jump")
+	cmd.Flags().IntVar(&p.Replicas, "replicas", 0, "Points of each backend on rings of shard directors"+suffix+", 0 is the default of the hashing scheme")
+	cmd.Flags().Float64Var(&p.Warmup, "warmup", 0, "Probability of shard directors"+suffix+" picking the next backend to warm its cache up")
+	cmd.Flags().DurationVar(&p.Rampup, "rampup", 0, "Duration, during which shard directors"+suffix+" ramp up backends, that became healthy")
+	cmd.Flags().IntVar(&p.HotThreshold, "hot-threshold", 0, "Estimated amount of recent requests of a key, that makes shard directors"+suffix+
+		"\nspread it among hot-replicas backends, 0 replicates no key")
+	cmd.Flags().IntVar(&p.HotReplicas, "hot-replicas", 2, "Amount of backends shard directors"+suffix+" spread hot keys among")
 }
 
 // TwoLayerShardedCmd returns a command for the two-layer sharded case
@@ -33,6 +506,18 @@ func TwoLayerShardedCmd() *cobra.Command {
 	firstCacheSize := 0
 	secondAmount := 0
 	secondCacheSize := 0
+	firstEviction := ""
+	secondEviction := ""
+	firstAdmission := ""
+	secondAdmission := ""
+	var firstPools, firstPoolRules, secondPools, secondPoolRules []string
+	firstLifetime := model.Lifetime{}
+	secondLifetime := model.Lifetime{}
+	firstLink := model.Link{}
+	secondLink := model.Link{}
+	firstCapacity := model.Capacity{}
+	secondCapacity := model.Capacity{}
+	sharding := model.DirectorParams{}
 
 	cmd := &cobra.Command{
 		Use:     "2layer-sharded",
@@ -41,42 +526,27 @@ func TwoLayerShardedCmd() *cobra.Command {
 		Long:    "Simulation case with two-layer sharded Varnish proxies",
 		Args:    cobra.MinimumNArgs(MinArgCount),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			providerFlag := root.Flag("provider")
-			if providerFlag == nil {
-				return fmt.Errorf("provider flag is not set")
-			}
-
-			twoLayerSharded := cases.NewTwoLayerSharded(
-				*cases.NewTwoLayerShardedConfig(firstAmount, firstCacheSize, secondAmount, secondCacheSize),
-			)
-
-			err := twoLayerSharded.Validate()
-			if err != nil {
+			config := cases.NewTwoLayerShardedConfig(firstAmount, firstCacheSize, secondAmount, secondCacheSize)
+			config.FirstLayer.Eviction = firstEviction
+			config.SecondLayer.Eviction = secondEviction
+			config.FirstLayer.Admission = firstAdmission
+			config.SecondLayer.Admission = secondAdmission
+			if err := parsePools(&config.FirstLayer, firstPools, firstPoolRules); err != nil {
 				return err
 			}
-
-			frontProxies, err := twoLayerSharded.SetUp()
-			if err != nil {
+			if err := parsePools(&config.SecondLayer, secondPools, secondPoolRules); err != nil {
 				return err
 			}
+			config.FirstLayer.Lifetime = firstLifetime
+			config.SecondLayer.Lifetime = secondLifetime
+			config.FirstLayer.Link = firstLink
+			config.SecondLayer.Link = secondLink
+			config.FirstLayer.Capacity = firstCapacity
+			config.SecondLayer.Capacity = secondCapacity
 
-			jsonFlag := root.Flag("json")
-			isJson := jsonFlag.Value.String() == "true"
-
-			interval, err := root.Flags().GetInt("step-interval")
-			if err != nil {
-				return err
-			}
+			twoLayerSharded := cases.NewTwoLayerSharded(*config).SetSharding(sharding)
 
-			return simulation.Run(
-				frontProxies,
-				args,
-				nil,
-				providerFlag.Value.String(),
-				twoLayerSharded.PrintResultsCB(isJson),
-				interval,
-				twoLayerSharded.Step,
-			)
+			return runCase(twoLayerSharded, args)
 		},
 	}
 
@@ -84,6 +554,19 @@ func TwoLayerShardedCmd() *cobra.Command {
 	cmd.Flags().IntVarP(&firstCacheSize, "first-cache-size", "F", 0, "Cache size of Varnish proxies in the first layer")
 	cmd.Flags().IntVarP(&secondAmount, "second-amount", "s", 0, "Amount of Varnish proxies in the second layer")
 	cmd.Flags().IntVarP(&secondCacheSize, "second-cache-size", "S", 0, "Cache size of Varnish proxies in the second layer")
+	cmd.Flags().StringVarP(&firstEviction, "first-eviction", "", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies in the first layer"))
+	cmd.Flags().StringVarP(&secondEviction, "second-eviction", "", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies in the second layer"))
+	cmd.Flags().StringVarP(&firstAdmission, "first-admission", "", "", admissionUsage("Admission policy of Varnish proxies in the first layer"))
+	cmd.Flags().StringVarP(&secondAdmission, "second-admission", "", "", admissionUsage("Admission policy of Varnish proxies in the second layer"))
+	poolFlags(cmd, &firstPools, &firstPoolRules, "first-", " in the first layer")
+	poolFlags(cmd, &secondPools, &secondPoolRules, "second-", " in the second layer")
+	lifetimeFlags(cmd, &firstLifetime, "first-", " in the first layer")
+	lifetimeFlags(cmd, &secondLifetime, "second-", " in the second layer")
+	linkFlags(cmd, &firstLink, "first-", " in the first layer")
+	linkFlags(cmd, &secondLink, "second-", " in the second layer")
+	capacityFlags(cmd, &firstCapacity, "first-", " in the first layer")
+	capacityFlags(cmd, &secondCapacity, "second-", " in the second layer")
+	shardFlags(cmd, &sharding, " of the first layer")
 
 	return cmd
 }
@@ -91,6 +574,12 @@ func TwoLayerShardedCmd() *cobra.Command {
 func OneLayerCmd() *cobra.Command {
 	amount := 0
 	cacheSize := 0
+	eviction := ""
+	admission := ""
+	var pools, poolRules []string
+	lifetime := model.Lifetime{}
+	link := model.Link{}
+	capacity := model.Capacity{}
 
 	cmd := &cobra.Command{
 		Use:     "1layer",
@@ -99,50 +588,32 @@ func OneLayerCmd() *cobra.Command {
 		Long:    "Simulation case with one-layer Varnish proxies",
 		Args:    cobra.MinimumNArgs(MinArgCount),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			providerFlag := root.Flag("provider")
-			if providerFlag == nil {
-				return fmt.Errorf("provider flag is not set")
+			layer := cases.LayerConfig{
+				Amount:    amount,
+				CacheSize: cacheSize,
+				Eviction:  eviction,
+				Admission: admission,
+				Lifetime:  lifetime,
+				Link:      link,
+				Capacity:  capacity,
 			}
-
-			oneLayer := cases.NewOneLayer(
-				cases.LayerConfig{
-					Amount:    amount,
-					CacheSize: cacheSize,
-				},
-			)
-
-			err := oneLayer.Validate()
-			if err != nil {
-				return err
-			}
-
-			frontProxies, err := oneLayer.SetUp()
-			if err != nil {
+			if err := parsePools(&layer, pools, poolRules); err != nil {
 				return err
 			}
+			oneLayer := cases.NewOneLayer(layer)
 
-			jsonFlag := root.Flag("json")
-			isJson := jsonFlag.Value.String() == "true"
-
-			interval, err := root.Flags().GetInt("step-interval")
-			if err != nil {
-				return err
-			}
-
-			return simulation.Run(
-				frontProxies,
-				args,
-				nil,
-				providerFlag.Value.String(),
-				oneLayer.PrintResultsCB(isJson),
-				interval,
-				oneLayer.Step,
-			)
+			return runCase(oneLayer, args)
 		},
 	}
 
 	cmd.Flags().IntVarP(&amount, "amount", "a", 0, "Amount of Varnish proxies")
 	cmd.Flags().IntVarP(&cacheSize, "cache-size", "c", 0, "Cache size of Varnish proxies")
+	cmd.Flags().StringVarP(&eviction, "eviction", "e", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies"))
+	cmd.Flags().StringVarP(&admission, "admission", "", "", admissionUsage("Admission policy of Varnish proxies"))
+	poolFlags(cmd, &pools, &poolRules, "", "")
+	lifetimeFlags(cmd, &lifetime, "", "")
+	linkFlags(cmd, &link, "", "")
+	capacityFlags(cmd, &capacity, "", "")
 
 	return cmd
 }
@@ -150,6 +621,13 @@ func OneLayerCmd() *cobra.Command {
 func OneLayerShardedCmd() *cobra.Command {
 	amount := 0
 	cacheSize := 0
+	eviction := ""
+	admission := ""
+	var pools, poolRules []string
+	lifetime := model.Lifetime{}
+	link := model.Link{}
+	capacity := model.Capacity{}
+	sharding := model.DirectorParams{}
 
 	cmd := &cobra.Command{
 		Use:     "1layer-sharded",
@@ -158,50 +636,33 @@ func OneLayerShardedCmd() *cobra.Command {
 		Long:    "Simulation case with one-layer sharded Varnish proxies",
 		Args:    cobra.MinimumNArgs(MinArgCount),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			providerFlag := root.Flag("provider")
-			if providerFlag == nil {
-				return fmt.Errorf("provider flag is not set")
+			layer := cases.LayerConfig{
+				Amount:    amount,
+				CacheSize: cacheSize,
+				Eviction:  eviction,
+				Admission: admission,
+				Lifetime:  lifetime,
+				Link:      link,
+				Capacity:  capacity,
 			}
-
-			oneLayerSharded := cases.NewOneLayerSharded(
-				cases.LayerConfig{
-					Amount:    amount,
-					CacheSize: cacheSize,
-				},
-			)
-
-			err := oneLayerSharded.Validate()
-			if err != nil {
-				return err
-			}
-
-			frontProxies, err := oneLayerSharded.SetUp()
-			if err != nil {
+			if err := parsePools(&layer, pools, poolRules); err != nil {
 				return err
 			}
+			oneLayerSharded := cases.NewOneLayerSharded(layer).SetSharding(sharding)
 
-			jsonFlag := root.Flag("json")
-			isJson := jsonFlag.Value.String() == "true"
-
-			interval, err := root.Flags().GetInt("step-interval")
-			if err != nil {
-				return err
-			}
-
-			return simulation.Run(
-				frontProxies,
-				args,
-				nil,
-				providerFlag.Value.String(),
-				oneLayerSharded.PrintResultsCB(isJson),
-				interval,
-				oneLayerSharded.Step,
-			)
+			return runCase(oneLayerSharded, args)
 		},
 	}
 
 	cmd.Flags().IntVarP(&amount, "amount", "a", 0, "Amount of Varnish proxies")
 	cmd.Flags().IntVarP(&cacheSize, "cache-size", "c", 0, "Cache size of Varnish proxies")
+	cmd.Flags().StringVarP(&eviction, "eviction", "e", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies"))
+	cmd.Flags().StringVarP(&admission, "admission", "", "", admissionUsage("Admission policy of Varnish proxies"))
+	poolFlags(cmd, &pools, &poolRules, "", "")
+	lifetimeFlags(cmd, &lifetime, "", "")
+	linkFlags(cmd, &link, "", "")
+	capacityFlags(cmd, &capacity, "", "")
+	shardFlags(cmd, &sharding, "")
 
 	return cmd
 }
@@ -211,6 +672,17 @@ func TwoLayerCmd() *cobra.Command {
 	firstCacheSize := 0
 	secondAmount := 0
 	secondCacheSize := 0
+	firstEviction := ""
+	secondEviction := ""
+	firstAdmission := ""
+	secondAdmission := ""
+	var firstPools, firstPoolRules, secondPools, secondPoolRules []string
+	firstLifetime := model.Lifetime{}
+	secondLifetime := model.Lifetime{}
+	firstLink := model.Link{}
+	secondLink := model.Link{}
+	firstCapacity := model.Capacity{}
+	secondCapacity := model.Capacity{}
 
 	cmd := &cobra.Command{
 		Use:     "2layer",
@@ -219,42 +691,27 @@ func TwoLayerCmd() *cobra.Command {
 		Long:    "Simulation case with two-layer non-sharded Varnish proxies",
 		Args:    cobra.MinimumNArgs(MinArgCount),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			providerFlag := root.Flag("provider")
-			if providerFlag == nil {
-				return fmt.Errorf("provider flag is not set")
-			}
-
-			twoLayer := cases.NewTwoLayer(
-				*cases.NewTwoLayerShardedConfig(firstAmount, firstCacheSize, secondAmount, secondCacheSize),
-			)
-
-			err := twoLayer.Validate()
-			if err != nil {
+			config := cases.NewTwoLayerShardedConfig(firstAmount, firstCacheSize, secondAmount, secondCacheSize)
+			config.FirstLayer.Eviction = firstEviction
+			config.SecondLayer.Eviction = secondEviction
+			config.FirstLayer.Admission = firstAdmission
+			config.SecondLayer.Admission = secondAdmission
+			if err := parsePools(&config.FirstLayer, firstPools, firstPoolRules); err != nil {
 				return err
 			}
-
-			frontProxies, err := twoLayer.SetUp()
-			if err != nil {
+			if err := parsePools(&config.SecondLayer, secondPools, secondPoolRules); err != nil {
 				return err
 			}
+			config.FirstLayer.Lifetime = firstLifetime
+			config.SecondLayer.Lifetime = secondLifetime
+			config.FirstLayer.Link = firstLink
+			config.SecondLayer.Link = secondLink
+			config.FirstLayer.Capacity = firstCapacity
+			config.SecondLayer.Capacity = secondCapacity
 
-			jsonFlag := root.Flag("json")
-			isJson := jsonFlag.Value.String() == "true"
-
-			interval, err := root.Flags().GetInt("step-interval")
-			if err != nil {
-				return err
-			}
+			twoLayer := cases.NewTwoLayer(*config)
 
-			return simulation.Run(
-				frontProxies,
-				args,
-				nil,
-				providerFlag.Value.String(),
-				twoLayer.PrintResultsCB(isJson),
-				interval,
-				twoLayer.Step,
-			)
+			return runCase(twoLayer, args)
 		},
 	}
 
@@ -262,6 +719,48 @@ func TwoLayerCmd() *cobra.Command {
 	cmd.Flags().IntVarP(&firstCacheSize, "first-cache-size", "F", 0, "Cache size of Varnish proxies in the first layer")
 	cmd.Flags().IntVarP(&secondAmount, "second-amount", "s", 0, "Amount of Varnish proxies in the second layer")
 	cmd.Flags().IntVarP(&secondCacheSize, "second-cache-size", "S", 0, "Cache size of Varnish proxies in the second layer")
+	cmd.Flags().StringVarP(&firstEviction, "first-eviction", "", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies in the first layer"))
+	cmd.Flags().StringVarP(&secondEviction, "second-eviction", "", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies in the second layer"))
+	cmd.Flags().StringVarP(&firstAdmission, "first-admission", "", "", admissionUsage("Admission policy of Varnish proxies in the first layer"))
+	cmd.Flags().StringVarP(&secondAdmission, "second-admission", "", "", admissionUsage("Admission policy of Varnish proxies in the second layer"))
+	poolFlags(cmd, &firstPools, &firstPoolRules, "first-", " in the first layer")
+	poolFlags(cmd, &secondPools, &secondPoolRules, "second-", " in the second layer")
+	lifetimeFlags(cmd, &firstLifetime, "first-", " in the first layer")
+	lifetimeFlags(cmd, &secondLifetime, "second-", " in the second layer")
+	linkFlags(cmd, &firstLink, "first-", " in the first layer")
+	linkFlags(cmd, &secondLink, "second-", " in the second layer")
+	capacityFlags(cmd, &firstCapacity, "first-", " in the first layer")
+	capacityFlags(cmd, &secondCapacity, "second-", " in the second layer")
+
+	return cmd
+}
+
+// TopologyCmd returns a command for a topology described by a file
+func TopologyCmd() *cobra.Command {
+	topologyFile := ""
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Topology case described by a file",
+		Long: "Simulation case with N layers of Varnish proxies described by a YAML or JSON file.\n" +
+			"File declares origins, named groups of proxies with their cache configuration and director,\n" +
+			"and backends each group routes its misses to. Directors are the ones of vmod_directors\n" +
+			"(shard, round-robin, random, hash, fallback) with params and weights, and nest by `directors`.",
+		Args: cobra.MinimumNArgs(MinArgCount),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := cases.LoadTopologyConfig(topologyFile)
+			if err != nil {
+				return err
+			}
+
+			topology := cases.NewTopology(*config)
+
+			return runCase(topology, args)
+		},
+	}
+
+	cmd.Flags().StringVarP(&topologyFile, "topology", "t", "", "Path to the YAML or JSON file describing the topology")
+	_ = cmd.MarkFlagRequired("topology")
 
 	return cmd
 }
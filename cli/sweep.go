@@ -0,0 +1,405 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"varnish_sim/cases"
+	"varnish_sim/model"
+	"varnish_sim/simulation"
+)
+
+const (
+	// stackSweepMethod computes the curve in a single pass, LRU only
+	stackSweepMethod = "stack"
+	// replaySweepMethod replays the trace for every cache size
+	replaySweepMethod = "replay"
+	// autoSweepMethod uses the stack method whenever it is exact
+	autoSweepMethod = "auto"
+)
+
+// sweepResult is a miss-ratio curve printed in JSON
+type sweepResult struct {
+	Case   string                  `json:"case"`
+	Method string                  `json:"method"`
+	Points []simulation.SweepPoint `json:"points"`
+}
+
+// SweepCmd returns a command computing a miss-ratio curve of a case
+func SweepCmd() *cobra.Command {
+	caseName := ""
+	topologyFile := ""
+	groups := make([]string, 0)
+	layer := cases.LayerConfig{}
+	rawSizes := ""
+	rawRange := ""
+	parallel := 0
+	method := ""
+
+	cmd := &cobra.Command{
+		Use:   "sweep",
+		Short: "Miss-ratio curve of a case",
+		Long: "Replays the trace against the case for every cache size and prints miss ratio\n" +
+			"and byte miss ratio of origins per cache size, as CSV or JSON.\n" +
+			"Curve of one-layer LRU proxies without TTL is computed in a single pass by Mattson's stack algorithm.",
+		Args: cobra.MinimumNArgs(MinArgCount),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sizes, err := sweepSizes(rawSizes, rawRange)
+			if err != nil {
+				return err
+			}
+
+			var build func(cacheSize int) (cases.Case, error)
+			if topologyFile != "" {
+				config, err := cases.LoadTopologyConfig(topologyFile)
+				if err != nil {
+					return err
+				}
+				caseName = config.String()
+				build = topologySweepCase(*config, groups)
+			} else {
+				build, err = layerSweepCase(caseName, layer)
+				if err != nil {
+					return err
+				}
+			}
+
+			routes, err := caseRoutes()
+			if err != nil {
+				return err
+			}
+			build = routedSweepCase(build, routes)
+
+			overhead, err := objectOverhead()
+			if err != nil {
+				return err
+			}
+			build = overheadSweepCase(build, overhead)
+
+			markers, markerTTL, err := objectMarkers()
+			if err != nil {
+				return err
+			}
+			build = markersSweepCase(build, markers, markerTTL)
+
+			// validate configuration once, before the trace is loaded
+			for _, size := range sizes {
+				c, err := build(size)
+				if err != nil {
+					return err
+				}
+				if err := c.Validate(); err != nil {
+					return fmt.Errorf("cache size %d: %w", size, err)
+				}
+			}
+			balancerSpec, err := root.Flags().GetString("load-balancer")
+			if err != nil {
+				return err
+			}
+			optimal, err := checkSweepCase(build, sizes[0], balancerSpec)
+			if err != nil {
+				return err
+			}
+
+			formatter, err := logFormatter()
+			if err != nil {
+				return err
+			}
+			rules, err := uncacheableRules()
+			if err != nil {
+				return err
+			}
+			requests, err := simulation.Load(args, formatter, rules, root.Flag("provider").Value.String())
+			if err != nil {
+				return err
+			}
+			// proxies of the optimal policy look next uses of objects up in the loaded trace
+			if optimal {
+				build = futureSweepCase(build, simulation.FutureOf(requests))
+			}
+
+			stackable := caseName == "1layer" &&
+				(layer.Eviction == "" || layer.Eviction == model.LRUPolicy) &&
+				layer.Admission == "" &&
+				(balancerSpec == "" || balancerSpec == model.RoundRobinBalancer) &&
+				layer.Lifetime.TTL <= 0 &&
+				!overhead.Enabled() &&
+				simulation.CanStackSweep(requests, sizes)
+
+			var points []simulation.SweepPoint
+			switch {
+			case method == stackSweepMethod && !stackable:
+				return fmt.Errorf("stack method is exact only for 1layer case of round-robin balanced LRU proxies without TTL, object overhead and objects fitting into caches")
+			case method == stackSweepMethod || (method == autoSweepMethod && stackable):
+				method = stackSweepMethod
+				points = simulation.StackSweep(requests, sizes, layer.Amount)
+			case method == replaySweepMethod || method == autoSweepMethod:
+				method = replaySweepMethod
+				points, err = simulation.Sweep(requests, sizes, parallel, balancerSpec, func(size int) (simulation.SweepCase, error) {
+					return build(size)
+				})
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown sweep method %q", method)
+			}
+
+			isJson, _ := root.Flags().GetBool("json")
+			if isJson {
+				return printSweepJSON(sweepResult{Case: caseName, Method: method, Points: points})
+			}
+			printSweepCSV(points)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&caseName, "case", "", "1layer", "Case to sweep: 1layer or 1layer-sharded")
+	cmd.Flags().IntVarP(&layer.Amount, "amount", "a", 1, "Amount of Varnish proxies")
+	cmd.Flags().StringVarP(&layer.Eviction, "eviction", "e", model.LRUPolicy, evictionUsage("Eviction policy of Varnish proxies"))
+	cmd.Flags().StringVarP(&layer.Admission, "admission", "", "", admissionUsage("Admission policy of Varnish proxies"))
+	lifetimeFlags(cmd, &layer.Lifetime, "", "")
+	cmd.Flags().StringVarP(&topologyFile, "topology", "t", "", "Path to the topology file to sweep instead of the case")
+	cmd.Flags().StringSliceVarP(&groups, "group", "g", nil, "Groups of the topology whose cache size is swept, all groups by default")
+	cmd.Flags().StringVarP(&rawSizes, "sizes", "", "", "Comma separated list of cache sizes")
+	cmd.Flags().StringVarP(&rawRange, "range", "", "", "Range of cache sizes in min:max:step format")
+	cmd.Flags().IntVarP(&parallel, "parallel", "", runtime.NumCPU(), "Amount of replays running in parallel")
+	cmd.Flags().StringVarP(&method, "method", "m", autoSweepMethod,
+		"Method of computing the curve: "+strings.Join([]string{autoSweepMethod, stackSweepMethod, replaySweepMethod}, " "))
+
+	return cmd
+}
+
+// layerSweepCase returns a constructor of one-layer case with the cache size
+func layerSweepCase(name string, layer cases.LayerConfig) (func(int) (cases.Case, error), error) {
+	switch name {
+	case "1layer":
+		return func(size int) (cases.Case, error) {
+			config := layer
+			config.CacheSize = size
+			return cases.NewOneLayer(config), nil
+		}, nil
+	case "1layer-sharded":
+		return func(size int) (cases.Case, error) {
+			config := layer
+			config.CacheSize = size
+			return cases.NewOneLayerSharded(config), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown case %q, use --topology for other topologies", name)
+}
+
+// topologySweepCase returns a constructor of the topology, where groups
+// (all if none) have the cache size
+func topologySweepCase(config cases.TopologyConfig, groups []string) func(int) (cases.Case, error) {
+	return func(size int) (cases.Case, error) {
+		sized := config
+		sized.Groups = make([]cases.GroupConfig, len(config.Groups))
+		copy(sized.Groups, config.Groups)
+
+		found := 0
+		for i := range sized.Groups {
+			if len(groups) > 0 && !contains(groups, sized.Groups[i].Name) {
+				continue
+			}
+			sized.Groups[i].CacheSize = size
+			found++
+		}
+		if len(groups) > 0 && found != len(groups) {
+			return nil, fmt.Errorf("topology has no groups %v", groups)
+		}
+
+		return cases.NewTopology(sized), nil
+	}
+}
+
+// routedSweepCase returns a constructor of cases routing requests by the routes
+func routedSweepCase(build func(int) (cases.Case, error), routes []model.Route) func(int) (cases.Case, error) {
+	return func(size int) (cases.Case, error) {
+		c, err := build(size)
+		if err != nil {
+			return nil, err
+		}
+		c.SetRoutes(routes)
+		return c, nil
+	}
+}
+
+// overheadCase is a case, whose proxies charge the overhead of cached objects, once they are set up
+type overheadCase struct {
+	cases.Case
+	overhead model.Overhead
+}
+
+func (c overheadCase) SetUp() ([]*model.VarnishProxy, error) {
+	front, err := c.Case.SetUp()
+	if err != nil {
+		return nil, err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetOverhead(c.overhead)
+	}
+	return front, nil
+}
+
+// overheadSweepCase returns a constructor of cases charging the overhead of cached objects
+func overheadSweepCase(build func(int) (cases.Case, error), overhead model.Overhead) func(int) (cases.Case, error) {
+	return func(size int) (cases.Case, error) {
+		c, err := build(size)
+		if err != nil {
+			return nil, err
+		}
+		return overheadCase{Case: c, overhead: overhead}, nil
+	}
+}
+
+// markersCase is a case, whose proxies mark uncacheable objects, once they are set up
+type markersCase struct {
+	cases.Case
+	kind string
+	ttl  time.Duration
+}
+
+func (c markersCase) SetUp() ([]*model.VarnishProxy, error) {
+	front, err := c.Case.SetUp()
+	if err != nil {
+		return nil, err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetMarkers(c.kind, c.ttl)
+	}
+	return front, nil
+}
+
+// markersSweepCase returns a constructor of cases marking uncacheable objects
+func markersSweepCase(build func(int) (cases.Case, error), kind string, ttl time.Duration) func(int) (cases.Case, error) {
+	return func(size int) (cases.Case, error) {
+		c, err := build(size)
+		if err != nil {
+			return nil, err
+		}
+		return markersCase{Case: c, kind: kind, ttl: ttl}, nil
+	}
+}
+
+// futureCase is a case, whose proxies look next uses of objects up in the future of the trace
+type futureCase struct {
+	cases.Case
+	future *model.Future
+}
+
+func (c futureCase) SetUp() ([]*model.VarnishProxy, error) {
+	front, err := c.Case.SetUp()
+	if err != nil {
+		return nil, err
+	}
+	for _, proxy := range c.Proxies() {
+		proxy.SetFuture(c.future)
+	}
+	return front, nil
+}
+
+// futureSweepCase returns a constructor of cases knowing the future of the trace
+func futureSweepCase(build func(int) (cases.Case, error), future *model.Future) func(int) (cases.Case, error) {
+	return func(size int) (cases.Case, error) {
+		c, err := build(size)
+		if err != nil {
+			return nil, err
+		}
+		return futureCase{Case: c, future: future}, nil
+	}
+}
+
+// checkSweepCase sets the case of the size up to check the load balancer of its front proxies,
+// returns if any proxy has a storage of the optimal policy, policies do not depend on the cache size
+func checkSweepCase(build func(int) (cases.Case, error), size int, balancerSpec string) (bool, error) {
+	c, err := build(size)
+	if err != nil {
+		return false, err
+	}
+	front, err := c.SetUp()
+	if err != nil {
+		return false, fmt.Errorf("cache size %d: %w", size, err)
+	}
+	if _, err := model.NewLoadBalancer(balancerSpec, front); err != nil {
+		return false, err
+	}
+	return evictsOptimally(c.Proxies()), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepSizes parses cache sizes from either a list or a range
+func sweepSizes(list, rng string) ([]int, error) {
+	if (list == "") == (rng == "") {
+		return nil, fmt.Errorf("either --sizes or --range should be set")
+	}
+
+	sizes := make([]int, 0)
+	if list != "" {
+		for _, raw := range strings.Split(list, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache size %q", raw)
+			}
+			sizes = append(sizes, size)
+		}
+		return sizes, nil
+	}
+
+	bounds := strings.Split(rng, ":")
+	if len(bounds) != 3 {
+		return nil, fmt.Errorf("range %q is not in min:max:step format", rng)
+	}
+	values := make([]int, 3)
+	for i, raw := range bounds {
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", rng, err)
+		}
+		values[i] = value
+	}
+	if values[2] < 1 || values[0] > values[1] {
+		return nil, fmt.Errorf("range %q should have min <= max and positive step", rng)
+	}
+	for size := values[0]; size <= values[1]; size += values[2] {
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+func printSweepCSV(points []simulation.SweepPoint) {
+	fmt.Println("cache_size,requests,misses,miss_ratio,bytes,miss_bytes,byte_miss_ratio")
+	for _, p := range points {
+		fmt.Printf("%d,%d,%d,%f,%d,%d,%f\n", p.CacheSize, p.Requests, p.Misses, p.MissRatio, p.Bytes, p.MissBytes, p.ByteMissRatio)
+	}
+}
+
+func printSweepJSON(result sweepResult) error {
+	raw, err := json.MarshalIndent(result, "", " ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+
+	return nil
+}
@@ -0,0 +1,340 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash"
+)
+
+const (
+	// TinyLFUAdmission is W-TinyLFU by Einziger et al., new objects are admitted to a window,
+	// objects leaving the window stay only if they are more frequent than the object evicted next
+	TinyLFUAdmission = "tinylfu"
+	// SecondHitAdmission caches objects on their second request, requests are remembered by a bloom filter
+	SecondHitAdmission = "second-hit"
+	// MaxSizeAdmission caches objects not bigger than the maximum size
+	MaxSizeAdmission = "max-size"
+	// AdaptSizeAdmission caches objects with probability decreasing with their size, as AdaptSize does
+	AdaptSizeAdmission = "adaptsize"
+
+	// defaultTinyLFUWindow is a part of the storage the window of W-TinyLFU takes
+	defaultTinyLFUWindow = 0.01
+	// tinyLFUAgingPeriod is an amount of requests, after which frequencies of W-TinyLFU are halved
+	tinyLFUAgingPeriod = 10 * defaultSketchWidth
+	// defaultBloomBits and defaultBloomHashes are a size and an amount of hash functions of bloom filters
+	defaultBloomBits   = 1 << 20
+	defaultBloomHashes = 4
+	// defaultAdaptSizeC is a size, objects of which are admitted with probability 1/e
+	defaultAdaptSizeC = 1 << 20
+)
+
+// Admissions returns names of admission policies
+func Admissions() []string {
+	return []string{TinyLFUAdmission, SecondHitAdmission, MaxSizeAdmission, AdaptSizeAdmission}
+}
+
+// Admission decides, if an object fetched by a proxy is stored in its cache.
+// It keeps one-hit wonders from pushing valuable objects out.
+type Admission interface {
+	// record counts a lookup of the object
+	record(key string)
+	// admit returns if the object of the size, that is not cached, is stored in the storage
+	admit(key string, size int, storage Storage[string, int]) bool
+	// counts returns amounts of objects admitted to and rejected from the storage
+	counts() (admitted, rejected int)
+	// String returns the policy with its parameters
+	String() string
+}
+
+// NewAdmission returns an admission policy by the spec in name[:param=value,...] format:
+// tinylfu[:window=0.01], second-hit[:bits=1048576,hashes=4], max-size:bytes=N, adaptsize[:c=1048576,seed=1].
+// Empty spec or "none" admits all objects and returns nil.
+func NewAdmission(spec string) (Admission, error) {
+	name, rawParams, _ := strings.Cut(spec, ":")
+	params := make(map[string]float64)
+	if rawParams != "" {
+		for _, param := range strings.Split(rawParams, ",") {
+			key, raw, ok := strings.Cut(param, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s admission: parameter %q is not in <name>=<value> format", name, param)
+			}
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("%s admission: %s must be a positive number", name, key)
+			}
+			params[key] = value
+		}
+	}
+	expect := func(known ...string) error {
+		for key := range params {
+			if !contains(known, key) {
+				return fmt.Errorf("%s admission has no parameter %q", name, key)
+			}
+		}
+		return nil
+	}
+	param := func(key string, fallback float64) float64 {
+		if value, ok := params[key]; ok {
+			return value
+		}
+		return fallback
+	}
+
+	switch name {
+	case "", "none":
+		if err := expect(); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case TinyLFUAdmission:
+		if err := expect("window"); err != nil {
+			return nil, err
+		}
+		window := param("window", defaultTinyLFUWindow)
+		if window >= 1 {
+			return nil, fmt.Errorf("%s admission: window must be less than 1", name)
+		}
+		return &tinyLFU{
+			sketch: newCountMinSketch(defaultSketchWidth, defaultSketchDepth),
+			window: window,
+			queue:  newByteQueue[string, int](),
+		}, nil
+	case SecondHitAdmission:
+		if err := expect("bits", "hashes"); err != nil {
+			return nil, err
+		}
+		return &secondHit{bloom: newBloomFilter(int(param("bits", defaultBloomBits)), int(param("hashes", defaultBloomHashes)))}, nil
+	case MaxSizeAdmission:
+		if err := expect("bytes"); err != nil {
+			return nil, err
+		}
+		if _, ok := params["bytes"]; !ok {
+			return nil, fmt.Errorf("%s admission needs bytes parameter", name)
+		}
+		return &maxSize{bytes: int(params["bytes"])}, nil
+	case AdaptSizeAdmission:
+		if err := expect("c", "seed"); err != nil {
+			return nil, err
+		}
+		seed := int64(param("seed", 1))
+		return &adaptSize{c: param("c", defaultAdaptSizeC), seed: seed, rnd: rand.New(rand.NewSource(seed))}, nil
+	}
+	return nil, fmt.Errorf("unknown admission policy %q, use one of %v", name, Admissions())
+}
+
+// admissionCounters counts objects admitted and rejected by an admission policy
+type admissionCounters struct {
+	admitted int
+	rejected int
+}
+
+// count counts the decision and returns it
+func (c *admissionCounters) count(admitted bool) bool {
+	if admitted {
+		c.admitted++
+	} else {
+		c.rejected++
+	}
+	return admitted
+}
+
+func (c *admissionCounters) counts() (int, int) {
+	return c.admitted, c.rejected
+}
+
+// evicting is a storage, that tells which object it evicts next
+type evicting[K comparable] interface {
+	victim() (K, bool)
+}
+
+// tinyLFU is W-TinyLFU admission. Frequencies of objects are estimated by a count-min sketch.
+// New objects are admitted to the window, a part of the storage in front of the rest.
+// An object leaving the window stays in the storage, only if it is estimated to be more frequent
+// than the object the storage evicts next, otherwise it is removed. Objects bigger
+// than the window are compared with the object evicted next right away.
+type tinyLFU struct {
+	admissionCounters
+
+	sketch  *countMinSketch
+	samples int
+
+	// window is a part of the storage, queue holds objects of the window
+	window float64
+	queue  *byteQueue[string, int]
+}
+
+func (t *tinyLFU) record(key string) {
+	t.sketch.add(key)
+	t.samples++
+	if t.samples >= tinyLFUAgingPeriod {
+		t.sketch.age()
+		t.samples = 0
+	}
+}
+
+// wins returns if the object is more frequent than the object the storage evicts next
+func (t *tinyLFU) wins(key string, storage Storage[string, int]) bool {
+	s, ok := storage.(evicting[string])
+	if !ok {
+		return true
+	}
+	victim, ok := s.victim()
+	if !ok || victim == key {
+		return true
+	}
+	return t.sketch.estimate(key) > t.sketch.estimate(victim)
+}
+
+func (t *tinyLFU) admit(key string, size int, storage Storage[string, int]) bool {
+	limit := fraction(storage.Size(), t.window)
+	if size > limit {
+		if storage.Stored()+size <= storage.Size() {
+			return t.count(true)
+		}
+		return t.count(t.wins(key, storage))
+	}
+
+	t.queue.remove(key)
+	t.queue.pushFront(&queueEntry[string, int]{key: key, value: size})
+	for t.queue.bytes > limit {
+		e, _ := t.queue.popBack()
+		if _, ok := storage.Peek(e.key); !ok {
+			// evicted or replaced while in the window
+			continue
+		}
+		// while the new object fits, objects leave the window without being compared
+		admitted := storage.Stored()+size <= storage.Size() || t.wins(e.key, storage)
+		if !t.count(admitted) {
+			storage.Remove(e.key)
+		}
+	}
+	return true
+}
+
+func (t *tinyLFU) String() string {
+	return fmt.Sprintf("%s:window=%g", TinyLFUAdmission, t.window)
+}
+
+// bloomFilter is a set of keys with false positives, it is cleared,
+// when it holds a tenth of its bits of keys, as false positives grow
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	keys   int
+}
+
+func newBloomFilter(bits, hashes int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (bits+63)/64), hashes: hashes}
+}
+
+// positions returns bits of the key, by double hashing
+func (b *bloomFilter) positions(key string) []uint64 {
+	h := xxhash.Sum64String(key)
+	h1, h2 := h, h>>33|1
+	size := uint64(len(b.bits)) * 64
+	positions := make([]uint64, b.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % size
+	}
+	return positions
+}
+
+func (b *bloomFilter) contains(key string) bool {
+	for _, p := range b.positions(key) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(key string) {
+	if b.keys >= len(b.bits)*64/10 {
+		for i := range b.bits {
+			b.bits[i] = 0
+		}
+		b.keys = 0
+	}
+	for _, p := range b.positions(key) {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+	b.keys++
+}
+
+// secondHit admits objects requested before, requests are remembered by a bloom filter
+type secondHit struct {
+	admissionCounters
+
+	bloom *bloomFilter
+	// last is the key recorded last, seen is if it was requested before
+	last string
+	seen bool
+}
+
+func (s *secondHit) record(key string) {
+	s.last = key
+	s.seen = s.bloom.contains(key)
+	if !s.seen {
+		s.bloom.add(key)
+	}
+}
+
+func (s *secondHit) admit(key string, _ int, _ Storage[string, int]) bool {
+	if key == s.last {
+		return s.count(s.seen)
+	}
+	return s.count(s.bloom.contains(key))
+}
+
+func (s *secondHit) String() string {
+	return fmt.Sprintf("%s:bits=%d,hashes=%d", SecondHitAdmission, len(s.bloom.bits)*64, s.bloom.hashes)
+}
+
+// maxSize admits objects not bigger than the maximum size
+type maxSize struct {
+	admissionCounters
+
+	bytes int
+}
+
+func (m *maxSize) record(string) {}
+
+func (m *maxSize) admit(_ string, size int, _ Storage[string, int]) bool {
+	return m.count(size <= m.bytes)
+}
+
+func (m *maxSize) String() string {
+	return fmt.Sprintf("%s:bytes=%d", MaxSizeAdmission, m.bytes)
+}
+
+// adaptSize admits objects with probability e^(-size/c), small objects are almost always admitted.
+// Unlike AdaptSize, c is not tuned to the workload.
+type adaptSize struct {
+	admissionCounters
+
+	c    float64
+	seed int64
+	rnd  *rand.Rand
+}
+
+func (a *adaptSize) record(string) {}
+
+func (a *adaptSize) admit(_ string, size int, _ Storage[string, int]) bool {
+	return a.count(a.rnd.Float64() < math.Exp(-float64(size)/a.c))
+}
+
+func (a *adaptSize) String() string {
+	return fmt.Sprintf("%s:c=%g,seed=%d", AdaptSizeAdmission, a.c, a.seed)
+}
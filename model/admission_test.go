@@ -0,0 +1,120 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewAdmission(t *testing.T) {
+	for _, spec := range []string{"", "none"} {
+		if a, err := NewAdmission(spec); err != nil || a != nil {
+			t.Fatalf("error: %q should admit all objects, got %v, %v", spec, a, err)
+		}
+	}
+	for _, spec := range []string{"tinylfu", "tinylfu:window=0.1", "second-hit:bits=1024,hashes=3", "max-size:bytes=100", "adaptsize:c=1000,seed=2"} {
+		if _, err := NewAdmission(spec); err != nil {
+			t.Fatalf("error: %q should be valid: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"lru", "max-size", "tinylfu:window=1", "second-hit:size=1", "adaptsize:c=0", "adaptsize:c"} {
+		if _, err := NewAdmission(spec); err == nil {
+			t.Fatalf("error: %q should be invalid", spec)
+		}
+	}
+}
+
+// admitProxy returns a proxy of the size with the admission policy
+func admitProxy(t *testing.T, size int, spec string) *VarnishProxy {
+	admission, err := NewAdmission(spec)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy, err := NewVarnishProxy("proxy", size, LRUPolicy)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return proxy.SetBackend(&Backend{Hostname: "default"}).SetAdmission(admission)
+}
+
+// admissionOf returns admission counters exported by the proxy
+func admissionOf(proxy *VarnishProxy) map[string]interface{} {
+	return proxy.Export()["proxy"].(map[string]interface{})["admission"].(map[string]interface{})
+}
+
+func TestMaxSizeAdmission(t *testing.T) {
+	proxy := admitProxy(t, 1000, "max-size:bytes=50")
+	proxy.Get(NewRequest("/small", 50))
+	proxy.Get(NewRequest("/big", 51))
+
+	if _, ok := proxy.cache.Peek("/small"); !ok {
+		t.Fatalf("error: small object should be cached")
+	}
+	if _, ok := proxy.cache.Peek("/big"); ok {
+		t.Fatalf("error: object over the maximum size should not be cached")
+	}
+	if counters := admissionOf(proxy); counters["admitted"] != 1 || counters["rejected"] != 1 {
+		t.Fatalf("error: unexpected admission counters %v", counters)
+	}
+}
+
+func TestSecondHitAdmission(t *testing.T) {
+	proxy := admitProxy(t, 1000, "second-hit")
+	proxy.Get(NewRequest("/obj", 10))
+	if _, ok := proxy.cache.Peek("/obj"); ok {
+		t.Fatalf("error: object should not be cached on its first request")
+	}
+	proxy.Get(NewRequest("/obj", 10))
+	if _, ok := proxy.cache.Peek("/obj"); !ok {
+		t.Fatalf("error: object should be cached on its second request")
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	// popular objects fill the cache of 10 objects, window is one object
+	proxy := admitProxy(t, 100, "tinylfu:window=0.1")
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 9; i++ {
+			proxy.Get(NewRequest(fmt.Sprintf("/popular/%d", i), 10))
+		}
+	}
+
+	// one-hit wonders pass through the window, without pushing popular objects out
+	for i := 0; i < 100; i++ {
+		proxy.Get(NewRequest(fmt.Sprintf("/wonder/%d", i), 10))
+	}
+	for i := 0; i < 9; i++ {
+		if _, ok := proxy.cache.Peek(fmt.Sprintf("/popular/%d", i)); !ok {
+			t.Fatalf("error: popular object %d was pushed out by one-hit wonders", i)
+		}
+	}
+	if counters := admissionOf(proxy); counters["rejected"].(int) < 90 {
+		t.Fatalf("error: one-hit wonders should be rejected, got %v", counters)
+	}
+}
+
+func TestAdaptSizeAdmission(t *testing.T) {
+	admission, _ := NewAdmission("adaptsize:c=100")
+	storage, _ := NewStorage[string, int](LRUPolicy, 1000)
+
+	small, big := 0, 0
+	for i := 0; i < 1000; i++ {
+		if admission.admit("/small", 10, storage) {
+			small++
+		}
+		if admission.admit("/big", 500, storage) {
+			big++
+		}
+	}
+	// e^-0.1 of small objects and e^-5 of big ones are admitted
+	if small < 850 || big > 30 {
+		t.Fatalf("error: admitted %d small and %d big objects", small, big)
+	}
+}
@@ -0,0 +1,450 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/cespare/xxhash"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RoundRobinBalancer sends requests to front proxies in turn
+	RoundRobinBalancer = "round-robin"
+	// RandomBalancer sends requests to random front proxies
+	RandomBalancer = "random"
+	// SourceIPBalancer hashes addresses of clients, a client always reaches the same proxy
+	SourceIPBalancer = "source-ip"
+	// URLHashBalancer hashes hosts and URLs, an object is always requested from the same proxy
+	URLHashBalancer = "url-hash"
+	// LeastConnectionsBalancer sends requests to the proxy with the fewest requests in flight
+	LeastConnectionsBalancer = "least-connections"
+	// WeightedBalancer sends requests to front proxies in turn, proportionally to their weights
+	WeightedBalancer = "weighted"
+)
+
+// loadBalancers holds names of load balancers, it is just for CLI to show the available ones.
+var loadBalancers = []string{
+	RoundRobinBalancer,
+	RandomBalancer,
+	SourceIPBalancer,
+	URLHashBalancer,
+	LeastConnectionsBalancer,
+	WeightedBalancer,
+}
+
+// LoadBalancers returns the list of available load balancers
+func LoadBalancers() []string {
+	return loadBalancers
+}
+
+// LoadBalancer distributes client requests among front proxies, as an L4 or L7
+// balancer in front of Varnish does. It is a director, so proxies may join and leave it.
+type LoadBalancer interface {
+	Director
+
+	// Pick returns a healthy proxy for the request, nil if none is healthy
+	Pick(*Request) WebInterface
+
+	// Done tells the balancer, that the proxy has answered the request
+	Done(WebInterface, *Request)
+
+	// Picked returns an amount of requests the proxy answered
+	Picked(WebInterface) int
+
+	// String returns the name of the load balancer
+	String() string
+}
+
+// NewLoadBalancer returns a load balancer of the front proxies
+// specified in `name[:params]` format, empty name is round-robin:
+//
+//	round-robin | random[:<seed>] | source-ip | url-hash | least-connections
+//	weighted:<proxy>=<weight>,... (proxies not listed have weight 1)
+func NewLoadBalancer(spec string, front []*VarnishProxy) (LoadBalancer, error) {
+	name, params, _ := strings.Cut(spec, ":")
+
+	var lb LoadBalancer
+	switch name {
+	case "", RoundRobinBalancer:
+		lb = &roundRobinBalancer{RoundRobinDirector: NewRoundRobinDirector()}
+	case RandomBalancer:
+		seed := int64(1)
+		if params != "" {
+			var err error
+			if seed, err = strconv.ParseInt(params, 10, 64); err != nil {
+				return nil, fmt.Errorf("%s load balancer: seed: %w", name, err)
+			}
+		}
+		lb = &randomBalancer{rnd: rand.New(rand.NewSource(seed))}
+	case SourceIPBalancer:
+		lb = &hashBalancer{name: name, key: func(req *Request) string {
+			// requests without an address of the client are hashed by host and URL
+			if req.Client == "" {
+				return req.HashKey()
+			}
+			return req.Client
+		}}
+	case URLHashBalancer:
+		lb = &hashBalancer{name: name, key: func(req *Request) string {
+			return req.HashKey()
+		}}
+	case LeastConnectionsBalancer:
+		lb = &leastConnectionsBalancer{inflight: make(map[WebInterface]*timeHeap), open: make(map[WebInterface]int)}
+	case WeightedBalancer:
+		weights, err := parseWeights(params, front)
+		if err != nil {
+			return nil, fmt.Errorf("%s load balancer: %w", name, err)
+		}
+		lb = &weightedBalancer{weights: weights, current: make(map[WebInterface]int)}
+	default:
+		return nil, fmt.Errorf("unknown load balancer %q, use one of %v", name, LoadBalancers())
+	}
+
+	for _, proxy := range front {
+		lb.AddBackend(proxy)
+	}
+	return lb, nil
+}
+
+// parseWeights parses weights in `<proxy>=<weight>,...` format of the front proxies
+func parseWeights(params string, front []*VarnishProxy) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, proxy := range front {
+		weights[proxy.Hostname()] = 1
+	}
+	if params == "" {
+		return weights, nil
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		name, raw, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("weight %q is not in <proxy>=<weight> format", param)
+		}
+		if _, known := weights[name]; !known {
+			return nil, fmt.Errorf("weight of unknown front proxy %q", name)
+		}
+		weight, err := strconv.Atoi(raw)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of %s must be a non-negative integer", name)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// balancerPicks counts requests each proxy answered, it is embedded by load balancers
+type balancerPicks struct {
+	picks map[WebInterface]int
+}
+
+// Done counts the request answered by the proxy
+func (b *balancerPicks) Done(w WebInterface, _ *Request) {
+	if b.picks == nil {
+		b.picks = make(map[WebInterface]int)
+	}
+	b.picks[w]++
+}
+
+// Picked returns an amount of requests the proxy answered
+func (b *balancerPicks) Picked(w WebInterface) int {
+	return b.picks[w]
+}
+
+// Picks is an amount of client requests each front proxy got from the load balancer
+type Picks struct {
+	balancer string
+	backends []WebInterface
+	picks    []int
+	total    int
+}
+
+// NewPicks returns amounts of requests proxies of the load balancer got
+func NewPicks(lb LoadBalancer) Picks {
+	p := Picks{balancer: lb.String(), backends: lb.Backends()}
+	for _, backend := range p.backends {
+		picked := lb.Picked(backend)
+		p.picks = append(p.picks, picked)
+		p.total += picked
+	}
+	return p
+}
+
+func (p Picks) TableData() (name string, rows [][]string) {
+	name = "LoadBalancer"
+	rows = append(rows, []string{"Strategy", p.balancer})
+	for i, backend := range p.backends {
+		share := 0.0
+		if p.total > 0 {
+			share = float64(p.picks[i]) / float64(p.total)
+		}
+		rows = append(rows, []string{fmt.Sprintf("-> %s", backend), fmt.Sprintf("%d", p.picks[i])})
+		rows = append(rows, []string{fmt.Sprintf("-> %s share", backend), fmt.Sprintf("%f", share)})
+	}
+	return
+}
+
+func (p Picks) Export() map[string]interface{} {
+	picks := make(map[string]int)
+	for i, backend := range p.backends {
+		picks[backend.String()] = p.picks[i]
+	}
+	return map[string]interface{}{
+		"load_balancer": map[string]interface{}{
+			"strategy": p.balancer,
+			"picks":    picks,
+		},
+	}
+}
+
+// roundRobinBalancer sends requests to front proxies in turn
+type roundRobinBalancer struct {
+	*RoundRobinDirector
+	balancerPicks
+}
+
+func (b *roundRobinBalancer) Pick(req *Request) WebInterface {
+	return b.GetBackend(req.HashKey())
+}
+
+func (b *roundRobinBalancer) String() string {
+	return RoundRobinBalancer
+}
+
+// backendList is a list of backends of a load balancer, that does not keep
+// any state of its backends, it is embedded by load balancers
+type backendList struct {
+	backends []WebInterface
+}
+
+func (l *backendList) AddBackend(w WebInterface) {
+	l.backends = append(l.backends, w)
+}
+
+func (l *backendList) RemoveBackend(w WebInterface) {
+	l.backends = removeBackend(l.backends, w)
+}
+
+func (l *backendList) Backends() []WebInterface {
+	return l.backends
+}
+
+// healthy returns healthy backends
+func (l *backendList) healthy() []WebInterface {
+	healthy := make([]WebInterface, 0, len(l.backends))
+	for _, backend := range l.backends {
+		if IsHealthy(backend) {
+			healthy = append(healthy, backend)
+		}
+	}
+	return healthy
+}
+
+// randomBalancer sends requests to random front proxies, the seed makes it reproducible
+type randomBalancer struct {
+	backendList
+	balancerPicks
+	rnd *rand.Rand
+}
+
+func (b *randomBalancer) Pick(_ *Request) WebInterface {
+	healthy := b.healthy()
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[b.rnd.Intn(len(healthy))]
+}
+
+func (b *randomBalancer) GetBackend(_ string) WebInterface {
+	return b.Pick(nil)
+}
+
+func (b *randomBalancer) String() string {
+	return RandomBalancer
+}
+
+// hashBalancer hashes a key of requests to a front proxy, modulo the amount of proxies
+// as a simple L4 balancer does. The next healthy proxy is used, if the one hashed to is down.
+type hashBalancer struct {
+	backendList
+	balancerPicks
+	name string
+	key  func(*Request) string
+}
+
+func (b *hashBalancer) Pick(req *Request) WebInterface {
+	return b.GetBackend(b.key(req))
+}
+
+func (b *hashBalancer) GetBackend(key string) WebInterface {
+	if len(b.backends) == 0 {
+		return nil
+	}
+	start := int(xxhash.Sum64String(key) % uint64(len(b.backends)))
+	for i := range b.backends {
+		backend := b.backends[(start+i)%len(b.backends)]
+		if IsHealthy(backend) {
+			return backend
+		}
+	}
+	return nil
+}
+
+func (b *hashBalancer) String() string {
+	return b.name
+}
+
+// leastConnectionsBalancer sends requests to the proxy with the fewest requests
+// in flight on the simulated timeline, ties are broken in turn
+type leastConnectionsBalancer struct {
+	backendList
+	balancerPicks
+	// inflight holds times requests in flight of each proxy are answered at,
+	// open is an amount of requests picked, that have not been answered yet
+	inflight map[WebInterface]*timeHeap
+	open     map[WebInterface]int
+	// index is the proxy ties are broken from
+	index int
+	// last is the request picked for the last, GetBackend picks at its time
+	last *Request
+}
+
+func (b *leastConnectionsBalancer) RemoveBackend(w WebInterface) {
+	b.backendList.RemoveBackend(w)
+	delete(b.inflight, w)
+	delete(b.open, w)
+}
+
+// Pick picks the proxy for the request, the request is open until the proxy answers it
+func (b *leastConnectionsBalancer) Pick(req *Request) WebInterface {
+	picked := b.pick(req)
+	if picked != nil {
+		b.open[picked]++
+	}
+	return picked
+}
+
+// pick returns the healthy proxy with the fewest requests in flight at the time of the request
+func (b *leastConnectionsBalancer) pick(req *Request) WebInterface {
+	b.last = req
+	if len(b.backends) == 0 {
+		return nil
+	}
+
+	var picked WebInterface
+	fewest := 0
+	for i := range b.backends {
+		backend := b.backends[(b.index+i)%len(b.backends)]
+		if !IsHealthy(backend) {
+			continue
+		}
+		if n := b.connections(backend, req); picked == nil || n < fewest {
+			picked, fewest = backend, n
+		}
+	}
+	b.index = (b.index + 1) % len(b.backends)
+	return picked
+}
+
+// connections returns an amount of requests in flight of the proxy at the time of the request,
+// requests of the engine are open, until their processes end
+func (b *leastConnectionsBalancer) connections(w WebInterface, req *Request) int {
+	inflight, ok := b.inflight[w]
+	if !ok {
+		return b.open[w]
+	}
+	for inflight.Len() > 0 && !(*inflight)[0].After(req.Timestamp) {
+		heap.Pop(inflight)
+	}
+	return b.open[w] + inflight.Len()
+}
+
+func (b *leastConnectionsBalancer) GetBackend(url string) WebInterface {
+	req := NewRequest(url, 0)
+	if b.last != nil {
+		req.Timestamp = b.last.Timestamp
+	}
+	return b.pick(req)
+}
+
+// Done keeps the request in flight of the proxy until it is answered
+func (b *leastConnectionsBalancer) Done(w WebInterface, req *Request) {
+	b.balancerPicks.Done(w, req)
+	if b.open[w] > 0 {
+		b.open[w]--
+	}
+	if req.Duration <= 0 {
+		return
+	}
+	inflight, ok := b.inflight[w]
+	if !ok {
+		inflight = &timeHeap{}
+		b.inflight[w] = inflight
+	}
+	heap.Push(inflight, req.Timestamp.Add(req.Duration))
+}
+
+func (b *leastConnectionsBalancer) String() string {
+	return LeastConnectionsBalancer
+}
+
+// weightedBalancer is a smooth weighted round-robin, it spreads picks
+// of a proxy among picks of others, proxies with zero weight are drained
+type weightedBalancer struct {
+	backendList
+	balancerPicks
+	// weights by hostnames of proxies, current holds the running weight of each proxy
+	weights map[string]int
+	current map[WebInterface]int
+}
+
+func (b *weightedBalancer) Pick(_ *Request) WebInterface {
+	var picked WebInterface
+	total := 0
+	for _, backend := range b.healthy() {
+		weight := b.weight(backend)
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		b.current[backend] += weight
+		if picked == nil || b.current[backend] > b.current[picked] {
+			picked = backend
+		}
+	}
+	if picked != nil {
+		b.current[picked] -= total
+	}
+	return picked
+}
+
+// weight returns the weight of the proxy, the ones without a weight have weight 1
+func (b *weightedBalancer) weight(w WebInterface) int {
+	if weight, ok := b.weights[w.String()]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (b *weightedBalancer) RemoveBackend(w WebInterface) {
+	b.backendList.RemoveBackend(w)
+	delete(b.current, w)
+}
+
+func (b *weightedBalancer) GetBackend(_ string) WebInterface {
+	return b.Pick(nil)
+}
+
+func (b *weightedBalancer) String() string {
+	return WeightedBalancer
+}
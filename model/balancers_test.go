@@ -0,0 +1,144 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newFront(t *testing.T, n int) []*VarnishProxy {
+	front := make([]*VarnishProxy, 0)
+	for i := 0; i < n; i++ {
+		proxy, err := NewVarnishProxy(fmt.Sprintf("proxy-%d", i), 1000, "")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		front = append(front, proxy)
+	}
+	return front
+}
+
+func TestLoadBalancersSkipUnhealthy(t *testing.T) {
+	for _, name := range LoadBalancers() {
+		front := newFront(t, 3)
+		lb, err := NewLoadBalancer(name, front)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		front[1].SetHealthy(false)
+		for i := 0; i < 100; i++ {
+			req := newRequestAt(fmt.Sprintf("/obj/%d", i), int64(i))
+			req.Client = fmt.Sprintf("10.0.0.%d", i)
+			backend := lb.Pick(req)
+			if backend == front[1] || backend == nil {
+				t.Fatalf("error: %s load balancer picked %v", name, backend)
+			}
+			lb.Done(backend, req)
+		}
+		if lb.Picked(front[0])+lb.Picked(front[2]) != 100 {
+			t.Fatalf("error: %s load balancer counted %d picks", name, lb.Picked(front[0])+lb.Picked(front[2]))
+		}
+
+		front[0].SetHealthy(false)
+		front[2].SetHealthy(false)
+		if backend := lb.Pick(newRequestAt("/obj", 0)); backend != nil {
+			t.Fatalf("error: %s load balancer picked %v, while all proxies are down", name, backend)
+		}
+	}
+
+	if _, err := NewLoadBalancer("dns", nil); err == nil {
+		t.Fatalf("error: unknown load balancer should not be created")
+	}
+}
+
+func TestHashBalancers(t *testing.T) {
+	front := newFront(t, 4)
+	sourceIP, err := NewLoadBalancer(SourceIPBalancer, front)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	urlHash, err := NewLoadBalancer(URLHashBalancer, front)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// a client always reaches the same proxy, an object is always requested from the same proxy
+	clientProxies := make(map[WebInterface]bool)
+	urlProxies := make(map[WebInterface]bool)
+	for i := 0; i < 100; i++ {
+		req := newRequestAt(fmt.Sprintf("/obj/%d", i), 0)
+		req.Client = "10.0.0.1"
+		clientProxies[sourceIP.Pick(req)] = true
+
+		req = newRequestAt("/obj", 0)
+		req.Client = fmt.Sprintf("10.0.0.%d", i)
+		urlProxies[urlHash.Pick(req)] = true
+	}
+	if len(clientProxies) != 1 || len(urlProxies) != 1 {
+		t.Fatalf("error: client reached %d proxies, object was requested from %d", len(clientProxies), len(urlProxies))
+	}
+}
+
+func TestLeastConnectionsBalancer(t *testing.T) {
+	front := newFront(t, 2)
+	lb, err := NewLoadBalancer(LeastConnectionsBalancer, front)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// a slow request keeps a proxy busy, the other one gets requests until it ends
+	slow := newRequestAt("/slow", 0)
+	busy := lb.Pick(slow)
+	slow.Duration = 10 * time.Second
+	lb.Done(busy, slow)
+
+	for at := int64(1); at < 10; at++ {
+		req := newRequestAt("/fast", at)
+		backend := lb.Pick(req)
+		if backend == busy {
+			t.Fatalf("error: busy proxy picked at %ds", at)
+		}
+		req.Duration = 500 * time.Millisecond
+		lb.Done(backend, req)
+	}
+
+	picked := make(map[WebInterface]bool)
+	for i := 0; i < 2; i++ {
+		picked[lb.Pick(newRequestAt("/fast", 10))] = true
+	}
+	if !picked[busy] {
+		t.Fatalf("error: proxy is not picked after its request ended")
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	front := newFront(t, 3)
+	lb, err := NewLoadBalancer("weighted:proxy-0=3,proxy-2=0", front)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// picks are spread, proxy with zero weight is drained
+	order := ""
+	for i := 0; i < 8; i++ {
+		order += lb.Pick(newRequestAt("/obj", 0)).String()[len("proxy-"):]
+	}
+	if order != "00100010" {
+		t.Fatalf("error: unexpected order of picks %s", order)
+	}
+
+	for _, invalid := range []string{"weighted:proxy-9=1", "weighted:proxy-0=-1", "weighted:proxy-0"} {
+		if _, err := NewLoadBalancer(invalid, front); err == nil {
+			t.Fatalf("error: load balancer %q should not be created", invalid)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// Capacity is a concurrency limit of a node, a pool of worker threads
+// and a queue of requests waiting for a free worker
+type Capacity struct {
+	// Workers is a size of the thread pool, zero is unlimited
+	Workers int `json:"workers" yaml:"workers"`
+	// Queue is a length of the queue, requests arriving at the full queue are rejected
+	Queue int `json:"queue" yaml:"queue"`
+}
+
+// Validate returns an error if the amount of workers or the queue is negative
+func (c Capacity) Validate() error {
+	if c.Workers < 0 || c.Queue < 0 {
+		return fmt.Errorf("workers and queue must not be negative")
+	}
+	return nil
+}
+
+// String returns workers and queue length of the capacity
+func (c Capacity) String() string {
+	if c.Workers <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%d", c.Workers, c.Queue)
+}
+
+// timeHeap is a min-heap of times
+type timeHeap []time.Time
+
+func (h timeHeap) Len() int            { return len(h) }
+func (h timeHeap) Less(i, j int) bool  { return h[i].Before(h[j]) }
+func (h timeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timeHeap) Push(x interface{}) { *h = append(*h, x.(time.Time)) }
+func (h *timeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// pool is worker threads of a node on the simulated timeline. It is a calendar
+// of events, at which workers become free, requests take the worker free first
+// in order of their arrival. Requests served by the engine arrive in time order
+// and release workers, when they end, so they wait for workers serving requests
+// in progress. Nil pool has unlimited workers.
+type pool struct {
+	capacity Capacity
+
+	// free holds times workers become free, a worker serving a request is not in it
+	free timeHeap
+	// waiting holds times queued requests start being served,
+	// waiters are processes waiting for workers serving requests in progress
+	waiting timeHeap
+	waiters []*waiter
+
+	queued   int
+	rejected int
+	// delay holds queueing delays of requests served
+	delay *Latency
+	// busy is a time workers spent serving requests between the first arrival and the last release
+	busy        time.Duration
+	first, last time.Time
+
+	// window holds counters at the last step
+	window struct {
+		queued   int
+		rejected int
+	}
+}
+
+// newPool is a constructor for pool, returns nil if workers are unlimited
+func newPool(c Capacity) *pool {
+	if c.Workers <= 0 {
+		return nil
+	}
+	p := &pool{
+		capacity: c,
+		free:     make(timeHeap, c.Workers),
+		delay:    NewLatency(),
+	}
+	heap.Init(&p.free)
+	return p
+}
+
+// acquire takes a worker for the request of the process arriving at the time,
+// returns when the worker starts serving it and false if the queue is full
+func (p *pool) acquire(proc *process, arrival time.Time) (time.Time, bool) {
+	if p == nil {
+		return arrival, true
+	}
+	proc.advance(arrival)
+	if p.first.IsZero() {
+		p.first = arrival
+	}
+
+	// requests, that started being served by the time, left the queue
+	for p.waiting.Len() > 0 && !p.waiting[0].After(arrival) {
+		heap.Pop(&p.waiting)
+	}
+	full := p.waiting.Len()+len(p.waiters) >= p.capacity.Queue
+
+	if p.free.Len() == 0 {
+		// all workers serve requests in progress, only processes of the engine wait for them
+		if proc == nil || full {
+			p.rejected++
+			return time.Time{}, false
+		}
+		w := &waiter{process: proc}
+		p.waiters = append(p.waiters, w)
+		p.queued++
+		proc.suspend()
+		p.delay.Record(w.start.Sub(arrival))
+		return w.start, true
+	}
+
+	free := heap.Pop(&p.free).(time.Time)
+	start := arrival
+	if free.After(arrival) {
+		if full {
+			heap.Push(&p.free, free)
+			p.rejected++
+			return time.Time{}, false
+		}
+		start = free
+		heap.Push(&p.waiting, start)
+		p.queued++
+	}
+	p.delay.Record(start.Sub(arrival))
+	return start, true
+}
+
+// release frees the worker, that has served the request of the process for the duration
+// since the start. The process releases it, when the engine reaches the end of the request,
+// the worker serves the process waiting first, if there is one.
+func (p *pool) release(proc *process, start time.Time, d time.Duration) {
+	if p == nil {
+		return
+	}
+	end := start.Add(d)
+	proc.advance(end)
+	p.busy += d
+	if end.After(p.last) {
+		p.last = end
+	}
+
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		w.start = end
+		w.process.resume(end)
+		return
+	}
+	heap.Push(&p.free, end)
+}
+
+// waiter is a process waiting for a worker, start is when the worker starts serving it
+type waiter struct {
+	process *process
+	start   time.Time
+}
+
+// utilization returns a share of time workers were serving requests
+func (p *pool) utilization() float64 {
+	elapsed := p.last.Sub(p.first)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.busy) / (float64(elapsed) * float64(p.capacity.Workers))
+}
+
+// rows returns table rows of the pool of the named node
+func (p *pool) rows(name string) (rows [][]string) {
+	rows = append(rows, []string{name + " capacity", p.capacity.String()})
+	rows = append(rows, []string{name + " utilization", fmt.Sprintf("%f", p.utilization())})
+	rows = append(rows, []string{name + " queued", fmt.Sprintf("%d", p.queued)})
+	rows = append(rows, []string{name + " rejected", fmt.Sprintf("%d", p.rejected)})
+	for _, q := range Percentiles {
+		rows = append(rows, []string{name + " wait " + q.Name, p.delay.quantileString(q.Quantile)})
+	}
+	return
+}
+
+// StepHeader returns names of columns of steps of the pool
+func (p *pool) StepHeader() string {
+	return "queued window_queued rejected window_rejected"
+}
+
+// Step returns queued and rejected requests, both cumulative
+// and since the previous step, starts a new window.
+func (p *pool) Step() string {
+	if p == nil {
+		return "0 0 0 0"
+	}
+	step := fmt.Sprintf("%d %d %d %d", p.queued, p.queued-p.window.queued, p.rejected, p.rejected-p.window.rejected)
+
+	p.window.queued = p.queued
+	p.window.rejected = p.rejected
+
+	return step
+}
+
+func (p *pool) Export() map[string]interface{} {
+	return map[string]interface{}{
+		"workers":     p.capacity.Workers,
+		"queue":       p.capacity.Queue,
+		"utilization": p.utilization(),
+		"queued":      p.queued,
+		"rejected":    p.rejected,
+		"queue_delay": p.delay.Export(),
+	}
+}
+
+// Saturation is a usage of capacity of nodes with limited workers,
+// that shows which nodes queue and reject requests
+type Saturation struct {
+	names []string
+	pools []*pool
+}
+
+// NewSaturation is a constructor for Saturation of the proxies and origins
+func NewSaturation(proxies []*VarnishProxy, origins []*Backend) *Saturation {
+	s := &Saturation{}
+	for _, proxy := range proxies {
+		if proxy.pool != nil {
+			s.names = append(s.names, proxy.hostname)
+			s.pools = append(s.pools, proxy.pool)
+		}
+	}
+	for _, origin := range origins {
+		if origin.pool != nil {
+			s.names = append(s.names, origin.Hostname)
+			s.pools = append(s.pools, origin.pool)
+		}
+	}
+	return s
+}
+
+// Limited returns if any node has limited workers
+func (s *Saturation) Limited() bool {
+	return len(s.pools) > 0
+}
+
+func (s *Saturation) TableData() (name string, rows [][]string) {
+	name = "Saturation"
+	for i, p := range s.pools {
+		rows = append(rows, p.rows(s.names[i])...)
+	}
+	return
+}
+
+func (s *Saturation) Export() map[string]interface{} {
+	nodes := make(map[string]interface{})
+	for i, p := range s.pools {
+		nodes[s.names[i]] = p.Export()
+	}
+	return map[string]interface{}{"saturation": nodes}
+}
@@ -0,0 +1,190 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	p := newPool(Capacity{Workers: 2, Queue: 1})
+	start := time.Unix(0, 0)
+
+	// two requests are served at once, the third one waits, the fourth one is rejected
+	steps := []struct {
+		at      time.Duration
+		ok      bool
+		startAt time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, true, 2 * time.Second},
+		{0, false, 0},
+		// the queue is empty again, when the first requests are served
+		{2 * time.Second, true, 2 * time.Second},
+	}
+	for i, step := range steps {
+		got, ok := p.acquire(nil, start.Add(step.at))
+		if ok != step.ok {
+			t.Fatalf("error: request %d admitted %v instead of %v", i, ok, step.ok)
+		}
+		if !ok {
+			continue
+		}
+		if got != start.Add(step.startAt) {
+			t.Fatalf("error: request %d started at %s instead of %s", i, got.Sub(start), step.startAt)
+		}
+		p.release(nil, got, 2*time.Second)
+	}
+
+	if p.queued != 1 || p.rejected != 1 {
+		t.Fatalf("error: %d queued and %d rejected instead of 1 and 1", p.queued, p.rejected)
+	}
+	// 4 requests busy for 2s on 2 workers in 4s
+	if p.utilization() != 1 {
+		t.Fatalf("error: utilization %f instead of 1", p.utilization())
+	}
+	if step := p.Step(); step != "1 1 1 1" {
+		t.Fatalf("error: unexpected step %q", step)
+	}
+
+	if newPool(Capacity{}) != nil {
+		t.Fatalf("error: pool without workers should be unlimited")
+	}
+	var unlimited *pool
+	if at, ok := unlimited.acquire(nil, start); !ok || at != start {
+		t.Fatalf("error: unlimited pool should serve requests at once")
+	}
+}
+
+func TestProxyCapacity(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	origin.SetCapacity(Capacity{Workers: 1, Queue: 1})
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin).SetCapacity(Capacity{Workers: 2, Queue: 1})
+	proxy.warmuped = true
+
+	// burst of misses saturates the origin, proxy workers wait for fetches
+	durations := make([]time.Duration, 0)
+	failed := 0
+	for i := 0; i < 4; i++ {
+		req := NewRequest(fmt.Sprintf("/obj-%d", i), 10)
+		req.Timestamp = time.Unix(0, 0)
+		proxy.Get(req)
+		if req.Failed {
+			failed++
+			continue
+		}
+		durations = append(durations, req.Duration)
+	}
+
+	// first miss is fetched at once, second waits for the origin, third waits
+	// for a proxy worker and then for the origin, fourth finds the proxy queue full
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if failed != 1 || len(durations) != len(want) {
+		t.Fatalf("error: %d failed requests and durations %v", failed, durations)
+	}
+	for i := range want {
+		if durations[i] != want[i] {
+			t.Fatalf("error: durations %v instead of %v", durations, want)
+		}
+	}
+	if proxy.pool.queued != 1 || proxy.pool.rejected != 1 || origin.pool.queued != 2 || origin.pool.rejected != 0 {
+		t.Fatalf("error: proxy queued %d and rejected %d, origin queued %d and rejected %d",
+			proxy.pool.queued, proxy.pool.rejected, origin.pool.queued, origin.pool.rejected)
+	}
+
+	saturation := NewSaturation([]*VarnishProxy{proxy}, []*Backend{origin})
+	if !saturation.Limited() || len(saturation.pools) != 2 {
+		t.Fatalf("error: both nodes should be limited")
+	}
+}
+
+func TestEngineQueuedFronts(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	shield, err := NewVarnishProxy("shield", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	shield.SetBackend(origin).SetCapacity(Capacity{Workers: 1, Queue: 1})
+	fronts := make([]*VarnishProxy, 2)
+	for i := range fronts {
+		if fronts[i], err = NewVarnishProxy(fmt.Sprintf("front-%d", i), 1000, ""); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		fronts[i].SetBackend(shield).SetCapacity(Capacity{Workers: 1, Queue: 1})
+	}
+	engine := NewEngine(append(fronts, shield), []*Backend{origin})
+	if engine == nil {
+		t.Fatalf("error: nodes with limited workers need the engine")
+	}
+
+	// the second request waits for the first one at front-0 and reaches the shield at 1s,
+	// after the third one, that front-1 sends at once at 0.5s
+	requests := []struct {
+		front *VarnishProxy
+		at    time.Duration
+		want  time.Duration
+	}{
+		{fronts[0], 0, time.Second},
+		{fronts[0], 0, 3 * time.Second},
+		{fronts[1], 500 * time.Millisecond, 1500 * time.Millisecond},
+	}
+	served := make([]*Request, len(requests))
+	for i, r := range requests {
+		req := NewRequest(fmt.Sprintf("/obj-%d", i), 10)
+		req.Timestamp = time.Unix(0, 0).Add(r.at)
+		front := r.front
+		engine.RunUntil(req.Timestamp)
+		engine.Serve(req, func() { front.Get(req) })
+		served[i] = req
+	}
+	engine.Run()
+
+	for i, r := range requests {
+		if served[i].Failed || served[i].Duration != r.want {
+			t.Fatalf("error: request %d took %s (failed %v) instead of %s", i, served[i].Duration, served[i].Failed, r.want)
+		}
+	}
+	if shield.pool.queued != 2 || shield.pool.rejected != 0 {
+		t.Fatalf("error: shield queued %d and rejected %d", shield.pool.queued, shield.pool.rejected)
+	}
+}
+
+func TestEngineCoalescing(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	origin.SetCapacity(Capacity{Workers: 1})
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin)
+	proxy.warmuped = true
+	engine := NewEngine([]*VarnishProxy{proxy}, []*Backend{origin})
+
+	// the second request waits on the waiting list for the fetch of the first one in progress
+	served := make([]*Request, 2)
+	for i := range served {
+		req := NewRequest("/obj", 10)
+		req.Timestamp = time.Unix(0, 0).Add(time.Duration(i) * 500 * time.Millisecond)
+		engine.RunUntil(req.Timestamp)
+		engine.Serve(req, func() { proxy.Get(req) })
+		served[i] = req
+	}
+	engine.Run()
+
+	if origin.Requests() != 1 || proxy.coalesced != 1 || served[1].Duration != 500*time.Millisecond {
+		t.Fatalf("error: %d origin requests, %d coalesced, the second one took %s", origin.Requests(), proxy.coalesced, served[1].Duration)
+	}
+}
@@ -9,10 +9,122 @@
 package model
 
 import (
-	"github.com/buraksezer/consistent"
+	"fmt"
 	"github.com/cespare/xxhash"
+	"math/rand"
+	"time"
 )
 
+const (
+	// ShardDirectorName is a name of ShardDirector
+	ShardDirectorName = "shard"
+	// RoundRobinDirectorName is a name of RoundRobinDirector
+	RoundRobinDirectorName = "round-robin"
+	// RandomDirectorName is a name of RandomDirector
+	RandomDirectorName = "random"
+	// HashDirectorName is a name of HashDirector
+	HashDirectorName = "hash"
+	// FallbackDirectorName is a name of FallbackDirector
+	FallbackDirectorName = "fallback"
+)
+
+// directors is a slice of strings that holds the names of the directors
+// it is just for CLI and configuration to show the available ones.
+var directors = []string{
+	ShardDirectorName,
+	RoundRobinDirectorName,
+	RandomDirectorName,
+	HashDirectorName,
+	FallbackDirectorName,
+}
+
+// Directors returns the list of available directors
+func Directors() []string {
+	return directors
+}
+
+// DirectorParams are parameters of directors, as the ones of vmod_directors,
+// zero values are defaults of Varnish.
+type DirectorParams struct {
+	// Replicas is an amount of points of each backend on the ring of the shard director
+	Replicas int `json:"replicas,omitempty" yaml:"replicas"`
+	// Warmup is a probability of the shard director picking the next backend
+	// on the ring instead of the owner of the key, to warm its cache up
+	Warmup float64 `json:"warmup,omitempty" yaml:"warmup"`
+	// Rampup is a duration, during which the shard director sends to a backend,
+	// that became healthy, a growing share of its keys
+	Rampup time.Duration `json:"rampup,omitempty" yaml:"rampup"`
+	// Sticky makes the fallback director stay on the backend it picked,
+	// until it is unhealthy, even if a preceding one is healthy again
+	Sticky bool `json:"sticky,omitempty" yaml:"sticky"`
+	// Seed is a seed of the random director, 1 if not set
+	Seed int64 `json:"seed,omitempty" yaml:"seed"`
+	// Hashing is a hashing scheme of the shard director in `name[:param=value,...]` format,
+	// see NewHashing, bounded-load if not set
+	Hashing string `json:"hashing,omitempty" yaml:"hashing"`
+	// HotThreshold is an estimated amount of recent requests of a key, that makes
+	// the shard director spread it among HotReplicas backends (2 if not set), 0 replicates no key
+	HotThreshold int `json:"hotThreshold,omitempty" yaml:"hotThreshold"`
+	HotReplicas  int `json:"hotReplicas,omitempty" yaml:"hotReplicas"`
+}
+
+// Validate checks if the parameters are valid
+func (p DirectorParams) Validate() error {
+	if p.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	if p.Warmup < 0 || p.Warmup > 1 {
+		return fmt.Errorf("warmup must be a probability in [0, 1]")
+	}
+	if p.Rampup < 0 {
+		return fmt.Errorf("rampup must not be negative")
+	}
+	if p.HotThreshold < 0 || p.HotReplicas < 0 {
+		return fmt.Errorf("hot threshold and replicas must not be negative")
+	}
+	_, err := NewHashing(p.Hashing, p.Replicas)
+	return err
+}
+
+// hotReplicas returns an amount of backends hot keys are spread among
+func (p DirectorParams) hotReplicas() int {
+	if p.HotReplicas == 0 {
+		return 2
+	}
+	return p.HotReplicas
+}
+
+// NewDirectorByName returns a new director that is specified by the name
+func NewDirectorByName(name string) (Director, error) {
+	return NewDirector(name, DirectorParams{})
+}
+
+// NewDirector returns a new director that is specified by the name,
+// parameters not used by the director are ignored
+func NewDirector(name string, params DirectorParams) (Director, error) {
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("%s director: %w", name, err)
+	}
+
+	switch name {
+	case ShardDirectorName:
+		return NewShardDirectorWithParams(params)
+	case RoundRobinDirectorName:
+		return NewRoundRobinDirector(), nil
+	case RandomDirectorName:
+		seed := params.Seed
+		if seed == 0 {
+			seed = 1
+		}
+		return NewRandomDirector(seed), nil
+	case HashDirectorName:
+		return NewHashDirector(), nil
+	case FallbackDirectorName:
+		return NewFallbackDirector(params.Sticky), nil
+	}
+	return nil, fmt.Errorf("unknown director %q", name)
+}
+
 // Director is an interface for a director that based on an internal logic
 // picks a backend to handle a request.
 type Director interface {
@@ -20,31 +132,157 @@ type Director interface {
 	// in the internal logic of the director.
 	AddBackend(WebInterface)
 
+	// RemoveBackend removes the backend from the director
+	RemoveBackend(WebInterface)
+
 	// GetBackend returns a backend based on the internal logic of the director.
+	// Unhealthy backends are skipped, nil is returned if none is healthy.
 	GetBackend(string) WebInterface
 
 	// Backends returns the list of backends that the director is managing.
 	Backends() []WebInterface
 }
 
-// consistent package doesn't provide a default hashing function.
-// You should provide a proper one to distribute keys/members uniformly.
-type hasher struct{}
+// WeightedDirector is a director, whose backends have weights,
+// as random, hash and shard directors of vmod_directors have.
+type WeightedDirector interface {
+	Director
+
+	// AddWeightedBackend adds a backend with the weight to the director
+	AddWeightedBackend(WebInterface, float64)
+}
+
+// observer is a director, whose picks depend on the time of the request,
+// or that counts requests it picks backends for
+type observer interface {
+	// observe tells the director the request to pick a backend for
+	observe(*Request)
+}
+
+// requestPicker is a director, that picks backends for requests, not only for keys,
+// as it counts requests it picks backends for, or nests directors, that count them
+type requestPicker interface {
+	// pickFor returns a backend for the key, req is nil, if it is picked for the key only
+	pickFor(key string, req *Request) WebInterface
+}
+
+// PickBackend returns a backend the director picks for the request
+func PickBackend(d Director, req *Request) WebInterface {
+	if o, ok := d.(observer); ok {
+		o.observe(req)
+	}
+	return pickFor(d, req.HashKey(), req)
+}
+
+// pickFor returns a backend the director picks for the key of the request
+func pickFor(d Director, key string, req *Request) WebInterface {
+	if p, ok := d.(requestPicker); ok {
+		return p.pickFor(key, req)
+	}
+	return d.GetBackend(key)
+}
+
+// observeBackends tells nested directors among backends the request
+func observeBackends(backends []WebInterface, req *Request) {
+	for _, backend := range backends {
+		if o, ok := backend.(observer); ok {
+			o.observe(req)
+		}
+	}
+}
+
+// DirectorBackend is a director used as a backend of another director,
+// as `director.backend()` is in VCL, so directors nest (e.g. a fallback of shards).
+// It is healthy, if any of its backends is healthy.
+type DirectorBackend struct {
+	Name string
+	Director
+}
+
+// NewDirectorBackend is a constructor for DirectorBackend
+func NewDirectorBackend(name string, d Director) *DirectorBackend {
+	return &DirectorBackend{Name: name, Director: d}
+}
+
+// Get fetches the object from the backend the director picks
+func (d *DirectorBackend) Get(req *Request) int {
+	backend := PickBackend(d.Director, req)
+	if backend == nil {
+		req.Failed = true
+		req.Duration = 0
+		return 0
+	}
+	return backend.Get(req)
+}
+
+// String returns the name of the director
+func (d *DirectorBackend) String() string {
+	return d.Name
+}
+
+// Healthy returns if any backend of the director is healthy
+func (d *DirectorBackend) Healthy() bool {
+	for _, backend := range d.Backends() {
+		if IsHealthy(backend) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DirectorBackend) observe(req *Request) {
+	if o, ok := d.Director.(observer); ok {
+		o.observe(req)
+	}
+}
 
-// Sum64 returns the hash of data.
-func (h hasher) Sum64(data []byte) uint64 {
-	// you should use a proper hash function for uniformity.
-	return xxhash.Sum64(data)
+// resolve returns the backend picked by the nested director for the key of the request,
+// other backends are returned as they are
+func resolve(w WebInterface, key string, req *Request) WebInterface {
+	if nested, ok := w.(*DirectorBackend); ok {
+		return pickFor(nested.Director, key, req)
+	}
+	return w
 }
 
+// hotAgingPeriod is an amount of requests, after which popularity of keys
+// estimated by the shard director is halved
+const hotAgingPeriod = 10 * defaultSketchWidth
+
 // ShardDirector is a director that uses consistent hashing to distribute
 // requests to backends.
 type ShardDirector struct {
-	// registered backends
+	// registered backends with their weights
 	backends []WebInterface
+	weights  []float64
+
+	// hashing maps keys to backends, a scheme of consistent hashing
+	hashing Hashing
+
+	params DirectorParams
+	// rnd decides picks of the next backend to warm it or ramp the owner up
+	rnd *rand.Rand
+
+	// now is the time of the request, sick marks backends seen unhealthy,
+	// healthySince is the time backends were seen becoming healthy
+	now          time.Time
+	sick         map[WebInterface]bool
+	healthySince map[WebInterface]time.Time
 
-	// consistent hashing instance
-	hashing *consistent.Consistent
+	// sample is a sample of keys requested from the director with sizes of their objects
+	sample *KeySample
+
+	// load holds requests and bytes each backend was picked for with its heaviest keys,
+	// picked holds backends in order they were first picked
+	load   map[WebInterface]*backendLoad
+	picked []WebInterface
+
+	// popularity estimates recent requests of keys, observed counts requests added to it since it aged,
+	// replicated holds cache keys of variants of hot keys spread among replicas by their hash keys,
+	// keys are dropped, when their popularity ages below the threshold
+	popularity *countMinSketch
+	observed   int
+	replicated map[string]map[string]bool
 }
 
 // Backends returns the list of backends that the director is managing.
@@ -54,14 +292,35 @@ func (d *ShardDirector) Backends() []WebInterface {
 
 // NewShardDirector is a constructor for ShardDirector
 func NewShardDirector() *ShardDirector {
-	cfg := consistent.Config{
-		Hasher: hasher{},
+	director, _ := NewShardDirectorWithParams(DirectorParams{})
+	return director
+}
+
+// NewShardDirectorWithParams is a constructor for ShardDirector with hashing,
+// replicas, warmup and rampup parameters
+func NewShardDirectorWithParams(params DirectorParams) (*ShardDirector, error) {
+	hashing, err := NewHashing(params.Hashing, params.Replicas)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ShardDirector{
-		backends: make([]WebInterface, 0),
-		hashing:  consistent.New(nil, cfg),
-	}
+		backends:     make([]WebInterface, 0),
+		hashing:      hashing,
+		params:       params,
+		rnd:          rand.New(rand.NewSource(1)),
+		sick:         make(map[WebInterface]bool),
+		healthySince: make(map[WebInterface]time.Time),
+		sample:       NewKeySample(remapSample),
+		load:         make(map[WebInterface]*backendLoad),
+		popularity:   newCountMinSketch(defaultSketchWidth, defaultSketchDepth),
+		replicated:   make(map[string]map[string]bool),
+	}, nil
+}
+
+// Hashing returns the hashing scheme of the director
+func (d *ShardDirector) Hashing() Hashing {
+	return d.hashing
 }
 
 // AddBackend adds a backend to the director, which will be participating
@@ -69,18 +328,183 @@ func NewShardDirector() *ShardDirector {
 // Note: hashing is a consistent hashing instance. On appending a new backend
 // hashing is update a circle of backends.
 func (d *ShardDirector) AddBackend(w WebInterface) {
-	d.hashing.Add(w)
+	d.AddWeightedBackend(w, 1)
+}
+
+// AddWeightedBackend adds a backend, that owns a share of keys proportional
+// to its weight, as it has weight times more points on the ring
+func (d *ShardDirector) AddWeightedBackend(w WebInterface, weight float64) {
+	d.hashing.Add(w, weight)
 	d.backends = append(d.backends, w)
+	d.weights = append(d.weights, weight)
+
+	if !d.now.IsZero() && d.params.Rampup > 0 {
+		// backend joining during the run is ramped up, as one becoming healthy
+		d.sick[w] = true
+	}
+}
+
+// RemoveBackend removes the backend from the director,
+// its keys are distributed among the rest of backends.
+func (d *ShardDirector) RemoveBackend(w WebInterface) {
+	d.hashing.Remove(w)
+	for i, backend := range d.backends {
+		if backend == w {
+			d.backends = append(d.backends[:i], d.backends[i+1:]...)
+			d.weights = append(d.weights[:i], d.weights[i+1:]...)
+			break
+		}
+	}
+	delete(d.sick, w)
+	delete(d.healthySince, w)
+}
+
+// observe notes the key requested and the time of the request, backends
+// becoming healthy are noted, as the shard director ramps them up since then
+func (d *ShardDirector) observe(req *Request) {
+	key := req.HashKey()
+	d.sample.Add(key, req.Size)
+
+	if d.params.HotThreshold > 0 {
+		// popularity of the past fades, so keys are hot by their recent requests
+		if d.observed++; d.observed >= hotAgingPeriod {
+			d.age()
+		}
+		if d.popularity.add(key) >= uint32(d.params.HotThreshold) {
+			if d.replicated[key] == nil {
+				d.replicated[key] = make(map[string]bool)
+			}
+			d.replicated[key][req.CacheKey()] = true
+		}
+	}
+
+	d.now = req.Timestamp
+	observeBackends(d.backends, req)
+	if d.params.Rampup <= 0 {
+		return
+	}
+	for _, backend := range d.backends {
+		healthy := IsHealthy(backend)
+		if healthy && d.sick[backend] {
+			d.healthySince[backend] = d.now
+		}
+		d.sick[backend] = !healthy
+	}
 }
 
 // GetBackend returns a backend based on the internal logic of the director.
 func (d *ShardDirector) GetBackend(req string) WebInterface {
-	// `LocateKey` returns a Member Interface that holds up a Hostname
-	// of backend.
-	// Note: member de facto is a WebInterface instance
-	member := d.hashing.LocateKey([]byte(req))
+	return d.pickFor(req, nil)
+}
+
+// pickFor returns a backend for the key, backend picked for a request is counted in its load
+func (d *ShardDirector) pickFor(key string, req *Request) WebInterface {
+	backend := d.getBackend(key)
+	if backend != nil && req != nil {
+		d.count(backend, req)
+	}
+	return resolve(backend, key, req)
+}
+
+// count adds the request to the load of the backend
+func (d *ShardDirector) count(backend WebInterface, req *Request) {
+	load, ok := d.load[backend]
+	if !ok {
+		load = &backendLoad{hot: newSpaceSaving(MaxHotKeys)}
+		d.load[backend] = load
+		d.picked = append(d.picked, backend)
+	}
+	load.requests++
+	load.bytes += req.Size
+	load.hot.add(req.HashKey(), req.Size)
+}
+
+// getBackend returns a backend of the director for the key, nested directors are not resolved
+func (d *ShardDirector) getBackend(req string) WebInterface {
+	if len(d.backends) == 0 {
+		return nil
+	}
+
+	if d.hot(req) {
+		// hot key is served by any of its healthy replicas
+		replicas := d.healthyOnRing(req, d.params.hotReplicas())
+		if len(replicas) == 0 {
+			return nil
+		}
+		return replicas[d.rnd.Intn(len(replicas))]
+	}
+
+	owner := d.hashing.Locate(req, 1)[0]
+	if IsHealthy(owner) && d.params.Warmup == 0 && d.params.Rampup == 0 {
+		return owner
+	}
+
+	// as Varnish shard director, the next healthy backend on the ring is used
+	healthy := d.healthyOnRing(req, 2)
+	if len(healthy) == 0 {
+		return nil
+	}
+	if len(healthy) > 1 && d.alternative(healthy[0], healthy[1]) {
+		return healthy[1]
+	}
+	return healthy[0]
+}
+
+// age halves popularity of keys and drops replicated keys, that are not hot anymore
+func (d *ShardDirector) age() {
+	d.popularity.age()
+	d.observed = 0
+	for key := range d.replicated {
+		if !d.hot(key) {
+			delete(d.replicated, key)
+		}
+	}
+}
+
+// hot returns if the key is requested often enough to be spread among replicas
+func (d *ShardDirector) hot(key string) bool {
+	return d.params.HotThreshold > 0 && d.popularity.estimate(key) >= uint32(d.params.HotThreshold)
+}
+
+// healthyOnRing returns up to n healthy backends in order of preference for the key
+func (d *ShardDirector) healthyOnRing(key string, n int) []WebInterface {
+	healthy := make([]WebInterface, 0, n)
+	for _, backend := range d.hashing.Locate(key, len(d.backends)) {
+		if IsHealthy(backend) {
+			healthy = append(healthy, backend)
+			if len(healthy) == n {
+				break
+			}
+		}
+	}
+	return healthy
+}
+
+// alternative returns if the request goes to the alternative backend instead of the primary,
+// primary in rampup gets a share of its keys growing with the time since it became healthy,
+// otherwise alternative, that is not in rampup, gets warmup share of the keys
+func (d *ShardDirector) alternative(primary, alternative WebInterface) bool {
+	if ramp := d.rampup(primary); ramp < 1 {
+		return d.rnd.Float64() >= ramp
+	}
+	if d.params.Warmup > 0 && d.rampup(alternative) == 1 {
+		return d.rnd.Float64() < d.params.Warmup
+	}
+	return false
+}
 
-	return member.(WebInterface)
+// rampup returns a share of keys the backend gets, 1 if it is not in rampup
+func (d *ShardDirector) rampup(w WebInterface) float64 {
+	since, ok := d.healthySince[w]
+	if !ok || d.params.Rampup <= 0 {
+		return 1
+	}
+	elapsed := d.now.Sub(since)
+	if elapsed >= d.params.Rampup {
+		delete(d.healthySince, w)
+		return 1
+	}
+	return float64(elapsed) / float64(d.params.Rampup)
 }
 
 // RoundRobinDirector is a director that uses round-robin to distribute
@@ -107,10 +531,211 @@ func (d *RoundRobinDirector) AddBackend(w WebInterface) {
 	d.backends = append(d.backends, w)
 }
 
+// RemoveBackend removes the backend from the director
+func (d *RoundRobinDirector) RemoveBackend(w WebInterface) {
+	d.backends = removeBackend(d.backends, w)
+	if d.index >= len(d.backends) {
+		d.index = 0
+	}
+}
+
+// GetBackend returns a backend based on the internal logic of the director.
+func (d *RoundRobinDirector) GetBackend(req string) WebInterface {
+	return d.pickFor(req, nil)
+}
+
+func (d *RoundRobinDirector) pickFor(key string, req *Request) WebInterface {
+	for range d.backends {
+		backend := d.backends[d.index]
+		d.index = (d.index + 1) % len(d.backends)
+
+		if IsHealthy(backend) {
+			return resolve(backend, key, req)
+		}
+	}
+
+	return nil
+}
+
+func (d *RoundRobinDirector) observe(req *Request) {
+	observeBackends(d.backends, req)
+}
+
+// weightedBackends are backends with weights, it is embedded by directors,
+// that pick backends by a value in [0, 1) among the healthy ones
+type weightedBackends struct {
+	backends []WebInterface
+	weights  []float64
+}
+
+// Backends returns the list of backends that the director is managing.
+func (b *weightedBackends) Backends() []WebInterface {
+	return b.backends
+}
+
+// AddBackend adds a backend with weight 1
+func (b *weightedBackends) AddBackend(w WebInterface) {
+	b.AddWeightedBackend(w, 1)
+}
+
+// AddWeightedBackend adds a backend, that gets a share of requests proportional to its weight
+func (b *weightedBackends) AddWeightedBackend(w WebInterface, weight float64) {
+	b.backends = append(b.backends, w)
+	b.weights = append(b.weights, weight)
+}
+
+// RemoveBackend removes the backend from the director
+func (b *weightedBackends) RemoveBackend(w WebInterface) {
+	for i, backend := range b.backends {
+		if backend == w {
+			b.backends = append(b.backends[:i], b.backends[i+1:]...)
+			b.weights = append(b.weights[:i], b.weights[i+1:]...)
+			return
+		}
+	}
+}
+
+// pick returns the healthy backend, whose share of total weight of the healthy
+// backends covers the value r in [0, 1), as vmod_directors does
+func (b *weightedBackends) pick(r float64) WebInterface {
+	total := 0.0
+	for i, backend := range b.backends {
+		if IsHealthy(backend) {
+			total += b.weights[i]
+		}
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	target := r * total
+	var last WebInterface
+	for i, backend := range b.backends {
+		if !IsHealthy(backend) || b.weights[i] <= 0 {
+			continue
+		}
+		last = backend
+		if target < b.weights[i] {
+			return backend
+		}
+		target -= b.weights[i]
+	}
+	// rounding of floats may leave the target past the last backend
+	return last
+}
+
+func (b *weightedBackends) observe(req *Request) {
+	observeBackends(b.backends, req)
+}
+
+// RandomDirector is a director that picks healthy backends randomly,
+// proportionally to their weights
+type RandomDirector struct {
+	weightedBackends
+
+	rnd *rand.Rand
+}
+
+// NewRandomDirector is a constructor for RandomDirector
+func NewRandomDirector(seed int64) *RandomDirector {
+	return &RandomDirector{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// GetBackend returns a backend based on the internal logic of the director.
+func (d *RandomDirector) GetBackend(req string) WebInterface {
+	return d.pickFor(req, nil)
+}
+
+func (d *RandomDirector) pickFor(key string, req *Request) WebInterface {
+	return resolve(d.pick(d.rnd.Float64()), key, req)
+}
+
+// HashDirector is a director that picks healthy backends by a hash of the key,
+// proportionally to their weights. Unlike shard director, keys of all backends
+// are remapped, when health of a backend changes.
+type HashDirector struct {
+	weightedBackends
+}
+
+// NewHashDirector is a constructor for HashDirector
+func NewHashDirector() *HashDirector {
+	return &HashDirector{}
+}
+
+// GetBackend returns a backend based on the internal logic of the director.
+func (d *HashDirector) GetBackend(req string) WebInterface {
+	return d.pickFor(req, nil)
+}
+
+func (d *HashDirector) pickFor(key string, req *Request) WebInterface {
+	r := float64(xxhash.Sum64String(key)>>11) / (1 << 53)
+	return resolve(d.pick(r), key, req)
+}
+
+// FallbackDirector is a director that picks the first healthy backend in order
+// they were added. Sticky one stays on the backend it picked, until it is unhealthy.
+type FallbackDirector struct {
+	backends []WebInterface
+
+	sticky bool
+	// current is the backend picked last time
+	current WebInterface
+}
+
+// NewFallbackDirector is a constructor for FallbackDirector
+func NewFallbackDirector(sticky bool) *FallbackDirector {
+	return &FallbackDirector{
+		backends: make([]WebInterface, 0),
+		sticky:   sticky,
+	}
+}
+
+// Backends returns the list of backends that the director is managing.
+func (d *FallbackDirector) Backends() []WebInterface {
+	return d.backends
+}
+
+// AddBackend adds a backend after the ones added before
+func (d *FallbackDirector) AddBackend(w WebInterface) {
+	d.backends = append(d.backends, w)
+}
+
+// RemoveBackend removes the backend from the director
+func (d *FallbackDirector) RemoveBackend(w WebInterface) {
+	d.backends = removeBackend(d.backends, w)
+	if d.current == w {
+		d.current = nil
+	}
+}
+
 // GetBackend returns a backend based on the internal logic of the director.
-func (d *RoundRobinDirector) GetBackend(_ string) WebInterface {
-	backend := d.backends[d.index]
-	d.index = (d.index + 1) % len(d.backends)
+func (d *FallbackDirector) GetBackend(req string) WebInterface {
+	return d.pickFor(req, nil)
+}
+
+func (d *FallbackDirector) pickFor(key string, req *Request) WebInterface {
+	if d.sticky && d.current != nil && IsHealthy(d.current) {
+		return resolve(d.current, key, req)
+	}
+	for _, backend := range d.backends {
+		if IsHealthy(backend) {
+			d.current = backend
+			return resolve(backend, key, req)
+		}
+	}
+	return nil
+}
 
-	return backend
+func (d *FallbackDirector) observe(req *Request) {
+	observeBackends(d.backends, req)
+}
+
+// removeBackend returns backends without the one removed
+func removeBackend(backends []WebInterface, w WebInterface) []WebInterface {
+	for i, backend := range backends {
+		if backend == w {
+			return append(backends[:i], backends[i+1:]...)
+		}
+	}
+	return backends
 }
@@ -0,0 +1,292 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDirectorsSkipUnhealthy(t *testing.T) {
+	for _, name := range Directors() {
+		director, err := NewDirectorByName(name)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		backends := make([]*Backend, 0)
+		for i := 0; i < 3; i++ {
+			backend := &Backend{Hostname: fmt.Sprintf("backend-%d", i)}
+			backends = append(backends, backend)
+			director.AddBackend(backend)
+		}
+
+		backends[1].SetHealthy(false)
+		for i := 0; i < 100; i++ {
+			if backend := director.GetBackend(fmt.Sprintf("/obj/%d", i)); backend == backends[1] || backend == nil {
+				t.Fatalf("error: %s director picked %v", name, backend)
+			}
+		}
+
+		backends[0].SetHealthy(false)
+		backends[2].SetHealthy(false)
+		if backend := director.GetBackend("/obj"); backend != nil {
+			t.Fatalf("error: %s director picked %v, while all backends are down", name, backend)
+		}
+	}
+}
+
+func TestShardDirectorFailover(t *testing.T) {
+	director := NewShardDirector()
+	backends := make([]*Backend, 0)
+	for i := 0; i < 4; i++ {
+		backend := &Backend{Hostname: fmt.Sprintf("backend-%d", i)}
+		backends = append(backends, backend)
+		director.AddBackend(backend)
+	}
+
+	owners := make(map[string]WebInterface)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("/obj/%d", i)
+		owners[key] = director.GetBackend(key)
+	}
+
+	backends[0].SetHealthy(false)
+	for key, owner := range owners {
+		// only keys of the unhealthy backend are moved
+		if owner != WebInterface(backends[0]) && director.GetBackend(key) != owner {
+			t.Fatalf("error: key %s moved from healthy %s", key, owner)
+		}
+	}
+}
+
+func TestFetchFailure(t *testing.T) {
+	origin := &Backend{Hostname: "origin"}
+	shield, err := NewVarnishProxy("shield", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	shield.SetBackend(origin)
+	edge, err := NewVarnishProxy("edge", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	edge.SetBackend(shield)
+
+	origin.SetHealthy(false)
+	req := NewRequest("/a", 100)
+	if size := edge.Get(req); size != 0 || !req.Failed {
+		t.Fatalf("error: failed fetch delivered %d bytes", size)
+	}
+	if _, ok := edge.cache.Get("/a"); ok {
+		t.Fatalf("error: failed response is cached")
+	}
+
+	origin.SetHealthy(true)
+	req = NewRequest("/a", 100)
+	if size := edge.Get(req); size != 100 || req.Failed {
+		t.Fatalf("error: fetch delivered %d bytes", size)
+	}
+
+	offload := NewOriginOffload([]*VarnishProxy{edge, shield}, []*Backend{origin})
+	if offload.Requests != 2 || offload.Errors != 1 || shield.fetchFailures != 1 || edge.fetchFailures != 1 {
+		t.Fatalf("error: unexpected offload %+v", offload)
+	}
+}
+
+func TestRemoveBackend(t *testing.T) {
+	for _, name := range Directors() {
+		director, err := NewDirectorByName(name)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		backends := make([]*Backend, 0)
+		for i := 0; i < 3; i++ {
+			backend := &Backend{Hostname: fmt.Sprintf("backend-%d", i)}
+			backends = append(backends, backend)
+			director.AddBackend(backend)
+		}
+
+		director.RemoveBackend(backends[2])
+		if len(director.Backends()) != 2 {
+			t.Fatalf("error: %s director has %d backends after removal", name, len(director.Backends()))
+		}
+		for i := 0; i < 100; i++ {
+			if backend := director.GetBackend(fmt.Sprintf("/obj/%d", i)); backend == backends[2] || backend == nil {
+				t.Fatalf("error: %s director picked %v", name, backend)
+			}
+		}
+
+		director.RemoveBackend(backends[0])
+		director.RemoveBackend(backends[1])
+		if backend := director.GetBackend("/obj"); backend != nil {
+			t.Fatalf("error: %s director without backends picked %v", name, backend)
+		}
+	}
+}
+
+func TestWeightedDirectors(t *testing.T) {
+	for _, name := range []string{RandomDirectorName, HashDirectorName, ShardDirectorName} {
+		director, err := NewDirectorByName(name)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		light := &Backend{Hostname: "light"}
+		heavy := &Backend{Hostname: "heavy"}
+		director.(WeightedDirector).AddWeightedBackend(light, 1)
+		director.(WeightedDirector).AddWeightedBackend(heavy, 3)
+
+		picks := make(map[WebInterface]int)
+		for i := 0; i < 4000; i++ {
+			picks[director.GetBackend(fmt.Sprintf("/obj/%d", i))]++
+		}
+		if share := float64(picks[heavy]) / 4000; share < 0.6 || share > 0.9 {
+			t.Fatalf("error: %s director sent %f of requests to the backend of weight 3", name, share)
+		}
+	}
+}
+
+func TestHashDirectorIsStable(t *testing.T) {
+	director := NewHashDirector()
+	for i := 0; i < 3; i++ {
+		director.AddBackend(&Backend{Hostname: fmt.Sprintf("backend-%d", i)})
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/obj/%d", i)
+		if director.GetBackend(key) != director.GetBackend(key) {
+			t.Fatalf("error: key %s moved between picks", key)
+		}
+	}
+}
+
+func TestFallbackDirector(t *testing.T) {
+	for _, sticky := range []bool{false, true} {
+		director := NewFallbackDirector(sticky)
+		primary := &Backend{Hostname: "primary"}
+		secondary := &Backend{Hostname: "secondary"}
+		director.AddBackend(primary)
+		director.AddBackend(secondary)
+
+		if backend := director.GetBackend("/a"); backend != primary {
+			t.Fatalf("error: fallback director picked %v", backend)
+		}
+		primary.SetHealthy(false)
+		if backend := director.GetBackend("/a"); backend != secondary {
+			t.Fatalf("error: fallback director picked %v", backend)
+		}
+
+		primary.SetHealthy(true)
+		expected := WebInterface(primary)
+		if sticky {
+			expected = secondary
+		}
+		if backend := director.GetBackend("/a"); backend != expected {
+			t.Fatalf("error: fallback director (sticky %v) picked %v", sticky, backend)
+		}
+	}
+}
+
+func TestNestedDirectors(t *testing.T) {
+	shards := make([]*ShardDirector, 0)
+	backends := make([]*Backend, 0)
+	fallback := NewFallbackDirector(false)
+	for i := 0; i < 2; i++ {
+		shard := NewShardDirector()
+		for j := 0; j < 2; j++ {
+			backend := &Backend{Hostname: fmt.Sprintf("dc-%d-backend-%d", i, j)}
+			backends = append(backends, backend)
+			shard.AddBackend(backend)
+		}
+		shards = append(shards, shard)
+		fallback.AddBackend(NewDirectorBackend(fmt.Sprintf("dc-%d", i), shard))
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/obj/%d", i)
+		if backend := fallback.GetBackend(key); backend != shards[0].GetBackend(key) {
+			t.Fatalf("error: key %s is not picked by the shard of the first data center", key)
+		}
+	}
+
+	backends[0].SetHealthy(false)
+	backends[1].SetHealthy(false)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/obj/%d", i)
+		if backend := fallback.GetBackend(key); backend != shards[1].GetBackend(key) {
+			t.Fatalf("error: key %s is not picked by the shard of the second data center", key)
+		}
+	}
+
+	backends[2].SetHealthy(false)
+	backends[3].SetHealthy(false)
+	if backend := fallback.GetBackend("/obj"); backend != nil {
+		t.Fatalf("error: fallback director picked %v, while all backends are down", backend)
+	}
+}
+
+func TestShardDirectorWarmupAndRampup(t *testing.T) {
+	director, err := NewShardDirectorWithParams(DirectorParams{Warmup: 0.5})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		director.AddBackend(&Backend{Hostname: fmt.Sprintf("backend-%d", i)})
+	}
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		if director.GetBackend("/obj") != director.hashing.Locate("/obj", 1)[0] {
+			moved++
+		}
+	}
+	if moved < 400 || moved > 600 {
+		t.Fatalf("error: warmup sent %d of 1000 requests to the next backend", moved)
+	}
+
+	director, err = NewShardDirectorWithParams(DirectorParams{Rampup: time.Minute})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	backends := make([]*Backend, 0)
+	for i := 0; i < 2; i++ {
+		backend := &Backend{Hostname: fmt.Sprintf("backend-%d", i)}
+		backends = append(backends, backend)
+		director.AddBackend(backend)
+	}
+	key := ""
+	for i := 0; key == ""; i++ {
+		if candidate := fmt.Sprintf("/obj/%d", i); director.GetBackend(candidate) == backends[0] {
+			key = candidate
+		}
+	}
+
+	start := time.Unix(0, 0)
+	backends[0].SetHealthy(false)
+	req := NewRequest(key, 10)
+	req.Timestamp = start
+	if backend := PickBackend(director, req); backend != backends[1] {
+		t.Fatalf("error: shard director picked %v", backend)
+	}
+
+	backends[0].SetHealthy(true)
+	owner := 0
+	for i := 0; i < 1000; i++ {
+		// backend became healthy at the start, its share grows to a half in 30 seconds
+		req.Timestamp = start.Add(time.Duration(i) * 30 * time.Second / 1000)
+		if PickBackend(director, req) == backends[0] {
+			owner++
+		}
+	}
+	if owner < 150 || owner > 350 {
+		t.Fatalf("error: backend in rampup got %d of 1000 requests", owner)
+	}
+	req.Timestamp = start.Add(time.Minute)
+	if backend := PickBackend(director, req); backend != backends[0] {
+		t.Fatalf("error: backend after rampup did not get its key, %v did", backend)
+	}
+}
@@ -0,0 +1,146 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Engine is a discrete-event engine on the simulated timeline. Each client request is
+// a process of the engine, that passes through nodes by their Get calls. A process waits
+// for the engine, when it reaches a node with limited workers later than the engine is,
+// when it waits for a worker, until it ends serving the request, and when it waits
+// for a fetch of another process. Events are processed in time order, so every node
+// sees arrivals in time order, even when requests queued on their way arrive late.
+// Only one process runs at a time, so nodes need no locks.
+type Engine struct {
+	now    time.Time
+	events eventHeap
+	// seq orders events of the same time by their scheduling
+	seq int
+	// yield is signalled by the running process, when it waits or ends
+	yield chan struct{}
+}
+
+// NewEngine returns an engine for the nodes, nil if workers of all of them are unlimited,
+// as requests are not delayed on their way then and nodes see them in order of the trace.
+// Nil engine serves each request at once.
+func NewEngine(proxies []*VarnishProxy, origins []*Backend) *Engine {
+	if !NewSaturation(proxies, origins).Limited() {
+		return nil
+	}
+	return &Engine{yield: make(chan struct{})}
+}
+
+// Serve starts a process serving the request at its timestamp by the function,
+// nil engine calls the function at once
+func (e *Engine) Serve(req *Request, serve func()) {
+	if e == nil {
+		serve()
+		return
+	}
+	p := &process{engine: e, wake: make(chan struct{})}
+	req.process = p
+	go func() {
+		<-p.wake
+		serve()
+		e.yield <- struct{}{}
+	}()
+	e.schedule(p, req.Timestamp)
+}
+
+// RunUntil processes events up to the time
+func (e *Engine) RunUntil(until time.Time) {
+	if e == nil {
+		return
+	}
+	for e.events.Len() > 0 && !e.events[0].at.After(until) {
+		e.step()
+	}
+}
+
+// Run processes all events, until every process has ended
+func (e *Engine) Run() {
+	if e == nil {
+		return
+	}
+	for e.events.Len() > 0 {
+		e.step()
+	}
+}
+
+// step resumes the process of the next event and waits, until it waits again or ends
+func (e *Engine) step() {
+	ev := heap.Pop(&e.events).(event)
+	e.now = ev.at
+	ev.process.wake <- struct{}{}
+	<-e.yield
+}
+
+// schedule resumes the process at the time, the past is the time of the engine
+func (e *Engine) schedule(p *process, at time.Time) {
+	if at.Before(e.now) {
+		at = e.now
+	}
+	heap.Push(&e.events, event{at: at, seq: e.seq, process: p})
+	e.seq++
+}
+
+// process is a request being served by the engine, nil process is served at once
+type process struct {
+	engine *Engine
+	wake   chan struct{}
+}
+
+// advance waits, until the engine reaches the time
+func (p *process) advance(at time.Time) {
+	if p == nil || !at.After(p.engine.now) {
+		return
+	}
+	p.engine.schedule(p, at)
+	p.suspend()
+}
+
+// suspend waits, until the process is resumed by an event
+func (p *process) suspend() {
+	p.engine.yield <- struct{}{}
+	<-p.wake
+}
+
+// resume resumes the suspended process at the time
+func (p *process) resume(at time.Time) {
+	p.engine.schedule(p, at)
+}
+
+// event resumes the process at the time
+type event struct {
+	at      time.Time
+	seq     int
+	process *process
+}
+
+// eventHeap is a min-heap of events by their time and order of scheduling
+type eventHeap []event
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(event)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,77 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import "fmt"
+
+const (
+	// LRUPolicy evicts the least recently used object
+	LRUPolicy = "lru"
+	// LFUPolicy evicts the least frequently used object
+	LFUPolicy = "lfu"
+	// TwoQueuePolicy is the full 2Q algorithm by Johnson and Shasha
+	TwoQueuePolicy = "2q"
+	// ARCPolicy is the Adaptive Replacement Cache by Megiddo and Modha
+	ARCPolicy = "arc"
+	// S3FIFOPolicy is the S3-FIFO algorithm by Yang et al.
+	S3FIFOPolicy = "s3fifo"
+	// OptimalPolicy is the offline optimal policy by Belady, it evicts the object
+	// reused furthest in the future, which is known after the trace is scanned
+	OptimalPolicy = "opt"
+)
+
+// evictionPolicies is a slice of strings that holds the names of the eviction policies
+// it is just for CLI to show the available ones.
+var evictionPolicies = []string{LRUPolicy, LFUPolicy, TwoQueuePolicy, ARCPolicy, S3FIFOPolicy, OptimalPolicy}
+
+// EvictionPolicies returns the list of available eviction policies
+func EvictionPolicies() []string {
+	return evictionPolicies
+}
+
+// IsEvictionPolicy returns if the policy is known,
+// empty policy is considered as a default one (LRU)
+func IsEvictionPolicy(policy string) bool {
+	if policy == "" {
+		return true
+	}
+	for _, p := range evictionPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStorage returns a new storage of the size that evicts objects
+// according to the policy specified by the name.
+// Empty policy name falls back to LRU.
+func NewStorage[K comparable, V Numeric](policy string, size V) (Storage[K, V], error) {
+	switch policy {
+	case "", LRUPolicy:
+		return NewCacheStorage[K, V](size)
+	case LFUPolicy:
+		return NewLFUStorage[K, V](size), nil
+	case TwoQueuePolicy:
+		return NewTwoQueueStorage[K, V](size), nil
+	case ARCPolicy:
+		return NewARCStorage[K, V](size), nil
+	case S3FIFOPolicy:
+		return NewS3FIFOStorage[K, V](size), nil
+	case OptimalPolicy:
+		return NewOptimalStorage[K, V](size, nil), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q", policy)
+}
+
+// fraction returns a part of the size, used by policies
+// that split storage into several queues
+func fraction[V Numeric](size V, part float64) V {
+	return V(float64(size) * part)
+}
@@ -0,0 +1,141 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"time"
+)
+
+// objectState is a state of a cached object in its lifetime
+type objectState int
+
+const (
+	// objectFresh object is within its TTL
+	objectFresh objectState = iota
+	// objectGrace object is out of TTL, but may be served stale
+	objectGrace
+	// objectKeep object may be only used to revalidate it with the backend
+	objectKeep
+	// objectExpired object is useless and should be removed
+	objectExpired
+)
+
+// cachedObject holds lifetime of an object stored in the cache
+type cachedObject struct {
+	key      string
+	stored   time.Time
+	lifetime Lifetime
+
+	// position in the heap
+	index int
+}
+
+// deadline returns time when the object is expired completely
+func (o *cachedObject) deadline() time.Time {
+	return o.stored.Add(o.lifetime.TTL + o.lifetime.Grace + o.lifetime.Keep)
+}
+
+// state returns the state of the object in time
+func (o *cachedObject) state(now time.Time) objectState {
+	age := now.Sub(o.stored)
+	switch {
+	case age < o.lifetime.TTL:
+		return objectFresh
+	case age < o.lifetime.TTL+o.lifetime.Grace:
+		return objectGrace
+	case age < o.lifetime.TTL+o.lifetime.Grace+o.lifetime.Keep:
+		return objectKeep
+	}
+	return objectExpired
+}
+
+// expiryHeap is a min-heap of objects, the one expiring first on top
+type expiryHeap []*cachedObject
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline().Before(h[j].deadline()) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	o := x.(*cachedObject)
+	o.index = len(*h)
+	*h = append(*h, o)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	o := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return o
+}
+
+// expiry tracks objects with limited lifetime, analogue to Varnish expiry thread.
+// Objects kept forever are not tracked.
+// Note: objects nuked by the storage are tracked until their deadline.
+type expiry struct {
+	objects map[string]*cachedObject
+	heap    expiryHeap
+}
+
+func newExpiry() *expiry {
+	return &expiry{objects: make(map[string]*cachedObject)}
+}
+
+// state returns the state of the object, untracked objects are always fresh
+func (e *expiry) state(key string, now time.Time) objectState {
+	o, ok := e.objects[key]
+	if !ok {
+		return objectFresh
+	}
+	return o.state(now)
+}
+
+// set starts tracking the object stored at the time
+func (e *expiry) set(key string, now time.Time, lifetime Lifetime) {
+	if o, ok := e.objects[key]; ok {
+		o.stored = now
+		o.lifetime = lifetime
+		heap.Fix(&e.heap, o.index)
+		return
+	}
+
+	o := &cachedObject{key: key, stored: now, lifetime: lifetime}
+	heap.Push(&e.heap, o)
+	e.objects[key] = o
+}
+
+// remove stops tracking the object
+func (e *expiry) remove(key string) {
+	o, ok := e.objects[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&e.heap, o.index)
+	delete(e.objects, key)
+}
+
+// expired stops tracking objects expired at the time and returns their keys
+func (e *expiry) expired(now time.Time) []string {
+	var keys []string
+	for len(e.heap) > 0 && !e.heap[0].deadline().After(now) {
+		o := heap.Pop(&e.heap).(*cachedObject)
+		delete(e.objects, o.key)
+		keys = append(keys, o.key)
+	}
+	return keys
+}
@@ -0,0 +1,545 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"github.com/buraksezer/consistent"
+	"github.com/cespare/xxhash"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// BoundedLoadHashing is consistent hashing with bounded loads by Mirrokni et al.,
+	// keys are hashed to partitions, each backend owns at most `load` times the average of them
+	BoundedLoadHashing = "bounded-load"
+	// KetamaHashing is a ring with points of each backend, as libketama and Varnish shard director have
+	KetamaHashing = "ketama"
+	// RendezvousHashing is the highest random weight hashing by Thaler and Ravishankar
+	RendezvousHashing = "rendezvous"
+	// MaglevHashing is the lookup table of Google's Maglev load balancer
+	MaglevHashing = "maglev"
+	// JumpHashing is the jump consistent hash by Lamping and Veach
+	JumpHashing = "jump"
+)
+
+const (
+	// defaultKetamaPoints is an amount of points of a backend on the ring of libketama
+	defaultKetamaPoints = 160
+	// defaultMaglevSize is a size of the lookup table of Maglev, it should be a prime
+	defaultMaglevSize = 65537
+)
+
+// hashingSchemes holds names of hashing schemes, it is just for CLI and configuration to show the available ones.
+var hashingSchemes = []string{BoundedLoadHashing, KetamaHashing, RendezvousHashing, MaglevHashing, JumpHashing}
+
+// HashingSchemes returns the list of available hashing schemes
+func HashingSchemes() []string {
+	return hashingSchemes
+}
+
+// Hashing maps keys to backends of the shard director
+type Hashing interface {
+	// Add adds a backend, that owns a share of keys proportional to its weight
+	Add(w WebInterface, weight float64)
+
+	// Remove removes the backend, its keys are mapped to the rest of backends
+	Remove(w WebInterface)
+
+	// Locate returns up to n distinct backends in order of preference for the key,
+	// the owner of the key first
+	Locate(key string, n int) []WebInterface
+
+	// String returns the hashing scheme with its parameters
+	String() string
+}
+
+// NewHashing returns a hashing scheme specified in `name[:param=value,...]` format,
+// empty name is bounded-load. Replicas are points of each backend on the ring
+// of bounded-load and ketama schemes, unless points are set, 0 uses defaults:
+//
+//	bounded-load[:partitions=271,replicas=20,load=1.25] | ketama[:points=160]
+//	rendezvous | maglev[:size=65537] | jump
+func NewHashing(spec string, replicas int) (Hashing, error) {
+	name, rawParams, _ := strings.Cut(spec, ":")
+	params := make(map[string]float64)
+	if rawParams != "" {
+		for _, param := range strings.Split(rawParams, ",") {
+			key, raw, ok := strings.Cut(param, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s hashing: parameter %q is not in <name>=<value> format", name, param)
+			}
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("%s hashing: %s must be a non-negative number", name, key)
+			}
+			params[key] = value
+		}
+	}
+	expect := func(known ...string) error {
+		for key := range params {
+			if !contains(known, key) {
+				return fmt.Errorf("%s hashing has no parameter %q", name, key)
+			}
+		}
+		return nil
+	}
+	param := func(key string, fallback float64) float64 {
+		if value, ok := params[key]; ok && value > 0 {
+			return value
+		}
+		return fallback
+	}
+
+	switch name {
+	case "", BoundedLoadHashing:
+		if err := expect("partitions", "replicas", "load"); err != nil {
+			return nil, err
+		}
+		if load, ok := params["load"]; ok && load <= 1 {
+			return nil, fmt.Errorf("%s hashing: load must be greater than 1", BoundedLoadHashing)
+		}
+		return newBoundedLoadHashing(consistent.Config{
+			Hasher:            hasher{},
+			PartitionCount:    int(param("partitions", float64(consistent.DefaultPartitionCount))),
+			ReplicationFactor: int(param("replicas", float64(replicasOr(replicas, consistent.DefaultReplicationFactor)))),
+			Load:              param("load", consistent.DefaultLoad),
+		}), nil
+	case KetamaHashing:
+		if err := expect("points"); err != nil {
+			return nil, err
+		}
+		return &ketamaHashing{points: int(param("points", float64(replicasOr(replicas, defaultKetamaPoints))))}, nil
+	case RendezvousHashing:
+		if err := expect(); err != nil {
+			return nil, err
+		}
+		return &rendezvousHashing{}, nil
+	case MaglevHashing:
+		if err := expect("size"); err != nil {
+			return nil, err
+		}
+		size := int(param("size", defaultMaglevSize))
+		if !isPrime(size) {
+			return nil, fmt.Errorf("%s hashing: size of the lookup table must be a prime", name)
+		}
+		return &maglevHashing{size: size}, nil
+	case JumpHashing:
+		if err := expect(); err != nil {
+			return nil, err
+		}
+		return &jumpHashing{}, nil
+	}
+	return nil, fmt.Errorf("unknown hashing %q, use one of %v", name, HashingSchemes())
+}
+
+// replicasOr returns replicas, or the default if they are not set
+func replicasOr(replicas, fallback int) int {
+	if replicas > 0 {
+		return replicas
+	}
+	return fallback
+}
+
+// contains returns if the value is in the list
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrime returns if n is a prime
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// units returns an amount of units of a backend of the weight, at least one
+func units(weight float64) int {
+	if n := int(math.Round(weight)); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// hashOf returns a hash of parts joined
+func hashOf(parts ...string) uint64 {
+	return xxhash.Sum64String(strings.Join(parts, "/"))
+}
+
+// distinct appends backends not seen yet to the list, until it has n of them
+func distinct(list []WebInterface, seen map[WebInterface]bool, n int, backends ...WebInterface) []WebInterface {
+	for _, backend := range backends {
+		if len(list) == n {
+			break
+		}
+		if !seen[backend] {
+			seen[backend] = true
+			list = append(list, backend)
+		}
+	}
+	return list
+}
+
+// consistent package doesn't provide a default hashing function.
+// You should provide a proper one to distribute keys/members uniformly.
+type hasher struct{}
+
+// Sum64 returns the hash of data.
+func (h hasher) Sum64(data []byte) uint64 {
+	// you should use a proper hash function for uniformity.
+	return xxhash.Sum64(data)
+}
+
+// hashMember is a point of a backend on the ring of bounded-load hashing
+type hashMember struct {
+	backend WebInterface
+	name    string
+}
+
+func (m hashMember) String() string {
+	return m.name
+}
+
+// boundedLoadHashing is consistent hashing with bounded loads of buraksezer/consistent
+type boundedLoadHashing struct {
+	config  consistent.Config
+	hashing *consistent.Consistent
+	// members are names of points of each backend on the ring,
+	// a backend has as many members as its weight
+	members map[WebInterface][]string
+}
+
+func newBoundedLoadHashing(config consistent.Config) *boundedLoadHashing {
+	return &boundedLoadHashing{
+		config:  config,
+		hashing: consistent.New(nil, config),
+		members: make(map[WebInterface][]string),
+	}
+}
+
+func (h *boundedLoadHashing) Add(w WebInterface, weight float64) {
+	for i := 0; i < units(weight); i++ {
+		name := w.String()
+		if i > 0 {
+			name = fmt.Sprintf("%s#%d", name, i)
+		}
+		h.hashing.Add(hashMember{backend: w, name: name})
+		h.members[w] = append(h.members[w], name)
+	}
+}
+
+func (h *boundedLoadHashing) Remove(w WebInterface) {
+	for _, name := range h.members[w] {
+		h.hashing.Remove(name)
+	}
+	delete(h.members, w)
+}
+
+func (h *boundedLoadHashing) Locate(key string, n int) []WebInterface {
+	if len(h.members) == 0 || n < 1 {
+		return nil
+	}
+	if n == 1 {
+		return []WebInterface{h.hashing.LocateKey([]byte(key)).(hashMember).backend}
+	}
+
+	members, err := h.hashing.GetClosestN([]byte(key), len(h.hashing.GetMembers()))
+	if err != nil {
+		return nil
+	}
+	located := make([]WebInterface, 0, n)
+	seen := make(map[WebInterface]bool)
+	for _, member := range members {
+		located = distinct(located, seen, n, member.(hashMember).backend)
+	}
+	return located
+}
+
+func (h *boundedLoadHashing) String() string {
+	return fmt.Sprintf("%s:partitions=%d,replicas=%d,load=%g",
+		BoundedLoadHashing, h.config.PartitionCount, h.config.ReplicationFactor, h.config.Load)
+}
+
+// ketamaHashing is a ring of points of backends, key is owned by the first point after its hash
+type ketamaHashing struct {
+	points int
+
+	// ring holds sorted points, owners holds backends of the points
+	ring   []uint64
+	owners []WebInterface
+	// backends in order they were added with their weights
+	backends []WebInterface
+	weights  []float64
+}
+
+func (h *ketamaHashing) Add(w WebInterface, weight float64) {
+	h.backends = append(h.backends, w)
+	h.weights = append(h.weights, weight)
+	h.build()
+}
+
+func (h *ketamaHashing) Remove(w WebInterface) {
+	for i, backend := range h.backends {
+		if backend == w {
+			h.backends = append(h.backends[:i], h.backends[i+1:]...)
+			h.weights = append(h.weights[:i], h.weights[i+1:]...)
+			break
+		}
+	}
+	h.build()
+}
+
+// build places points of the backends on the ring
+func (h *ketamaHashing) build() {
+	type point struct {
+		hash  uint64
+		owner WebInterface
+	}
+	points := make([]point, 0)
+	for i, backend := range h.backends {
+		for j := 0; j < h.points*units(h.weights[i]); j++ {
+			points = append(points, point{hashOf(backend.String(), strconv.Itoa(j)), backend})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	h.ring = make([]uint64, len(points))
+	h.owners = make([]WebInterface, len(points))
+	for i, p := range points {
+		h.ring[i] = p.hash
+		h.owners[i] = p.owner
+	}
+}
+
+func (h *ketamaHashing) Locate(key string, n int) []WebInterface {
+	if len(h.ring) == 0 || n < 1 {
+		return nil
+	}
+	hash := hashOf(key)
+	start := sort.Search(len(h.ring), func(i int) bool {
+		return h.ring[i] >= hash
+	})
+
+	located := make([]WebInterface, 0, n)
+	seen := make(map[WebInterface]bool)
+	for i := 0; i < len(h.ring) && len(located) < n; i++ {
+		located = distinct(located, seen, n, h.owners[(start+i)%len(h.ring)])
+	}
+	return located
+}
+
+func (h *ketamaHashing) String() string {
+	return fmt.Sprintf("%s:points=%d", KetamaHashing, h.points)
+}
+
+// rendezvousHashing ranks backends by weighted scores of hashes of the key and backend,
+// key is owned by the backend with the highest score
+type rendezvousHashing struct {
+	backends []WebInterface
+	weights  []float64
+}
+
+func (h *rendezvousHashing) Add(w WebInterface, weight float64) {
+	h.backends = append(h.backends, w)
+	h.weights = append(h.weights, weight)
+}
+
+func (h *rendezvousHashing) Remove(w WebInterface) {
+	for i, backend := range h.backends {
+		if backend == w {
+			h.backends = append(h.backends[:i], h.backends[i+1:]...)
+			h.weights = append(h.weights[:i], h.weights[i+1:]...)
+			return
+		}
+	}
+}
+
+// score returns the weighted score of the backend for the key, -weight / ln(u)
+// of the hash mapped to u in (0, 1), as weighted rendezvous hashing by Schindelhauer
+func (h *rendezvousHashing) score(key string, i int) float64 {
+	u := (float64(hashOf(key, h.backends[i].String())>>11) + 0.5) / (1 << 53)
+	return -h.weights[i] / math.Log(u)
+}
+
+func (h *rendezvousHashing) Locate(key string, n int) []WebInterface {
+	if len(h.backends) == 0 || n < 1 {
+		return nil
+	}
+	scores := make([]float64, len(h.backends))
+	order := make([]int, len(h.backends))
+	for i := range h.backends {
+		scores[i] = h.score(key, i)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	if n < len(order) {
+		order = order[:n]
+	}
+	located := make([]WebInterface, 0, n)
+	for _, i := range order {
+		located = append(located, h.backends[i])
+	}
+	return located
+}
+
+func (h *rendezvousHashing) String() string {
+	return RendezvousHashing
+}
+
+// maglevHashing is a lookup table, that backends fill in turns by their
+// permutations of slots, key is owned by the backend of the slot of its hash
+type maglevHashing struct {
+	size int
+
+	// table holds a backend of each slot
+	table    []WebInterface
+	backends []WebInterface
+	weights  []float64
+}
+
+func (h *maglevHashing) Add(w WebInterface, weight float64) {
+	h.backends = append(h.backends, w)
+	h.weights = append(h.weights, weight)
+	h.build()
+}
+
+func (h *maglevHashing) Remove(w WebInterface) {
+	for i, backend := range h.backends {
+		if backend == w {
+			h.backends = append(h.backends[:i], h.backends[i+1:]...)
+			h.weights = append(h.weights[:i], h.weights[i+1:]...)
+			break
+		}
+	}
+	h.build()
+}
+
+// build fills the lookup table, each backend takes as many slots
+// in a turn as its weight, as weighted Maglev does
+func (h *maglevHashing) build() {
+	h.table = nil
+	if len(h.backends) == 0 {
+		return
+	}
+
+	offsets := make([]uint64, len(h.backends))
+	skips := make([]uint64, len(h.backends))
+	next := make([]uint64, len(h.backends))
+	for i, backend := range h.backends {
+		offsets[i] = hashOf(backend.String(), "offset") % uint64(h.size)
+		skips[i] = hashOf(backend.String(), "skip")%uint64(h.size-1) + 1
+	}
+
+	h.table = make([]WebInterface, h.size)
+	filled := 0
+	for filled < h.size {
+		for i, backend := range h.backends {
+			for turn := 0; turn < units(h.weights[i]) && filled < h.size; turn++ {
+				slot := (offsets[i] + next[i]*skips[i]) % uint64(h.size)
+				for h.table[slot] != nil {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % uint64(h.size)
+				}
+				h.table[slot] = backend
+				next[i]++
+				filled++
+			}
+		}
+	}
+}
+
+func (h *maglevHashing) Locate(key string, n int) []WebInterface {
+	if len(h.table) == 0 || n < 1 {
+		return nil
+	}
+	slot := int(hashOf(key) % uint64(h.size))
+
+	// backends following the owner in the table are alternatives
+	located := make([]WebInterface, 0, n)
+	seen := make(map[WebInterface]bool)
+	for i := 0; i < h.size && len(located) < n && len(located) < len(h.backends); i++ {
+		located = distinct(located, seen, n, h.table[(slot+i)%h.size])
+	}
+	return located
+}
+
+func (h *maglevHashing) String() string {
+	return fmt.Sprintf("%s:size=%d", MaglevHashing, h.size)
+}
+
+// jumpHashing is the jump consistent hash of the key to buckets of backends,
+// each backend has as many buckets as its weight. Buckets are numbered in order
+// of backends, so removal of a backend but the last one remaps keys of the others.
+type jumpHashing struct {
+	buckets []WebInterface
+}
+
+func (h *jumpHashing) Add(w WebInterface, weight float64) {
+	for i := 0; i < units(weight); i++ {
+		h.buckets = append(h.buckets, w)
+	}
+}
+
+func (h *jumpHashing) Remove(w WebInterface) {
+	buckets := make([]WebInterface, 0, len(h.buckets))
+	for _, backend := range h.buckets {
+		if backend != w {
+			buckets = append(buckets, backend)
+		}
+	}
+	h.buckets = buckets
+}
+
+// jump returns a bucket of the key among n buckets
+func jump(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (h *jumpHashing) Locate(key string, n int) []WebInterface {
+	if len(h.buckets) == 0 || n < 1 {
+		return nil
+	}
+
+	// alternatives are buckets of the key hashed again, the rest of backends follow in order
+	located := make([]WebInterface, 0, n)
+	seen := make(map[WebInterface]bool)
+	for attempt := 0; attempt < 2*len(h.buckets) && len(located) < n; attempt++ {
+		located = distinct(located, seen, n, h.buckets[jump(hashOf(key, strconv.Itoa(attempt)), len(h.buckets))])
+	}
+	return distinct(located, seen, n, h.buckets...)
+}
+
+func (h *jumpHashing) String() string {
+	return JumpHashing
+}
@@ -0,0 +1,143 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashingSchemes(t *testing.T) {
+	for _, name := range HashingSchemes() {
+		hashing, err := NewHashing(name, 0)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		backends := make([]*Backend, 0)
+		for i := 0; i < 4; i++ {
+			backend := &Backend{Hostname: fmt.Sprintf("backend-%d", i)}
+			backends = append(backends, backend)
+			hashing.Add(backend, 1)
+		}
+
+		owners := make(map[string]WebInterface)
+		keys := make(map[WebInterface]int)
+		for i := 0; i < 4000; i++ {
+			key := fmt.Sprintf("/obj/%d", i)
+			located := hashing.Locate(key, 4)
+			if len(located) != 4 || located[0] != hashing.Locate(key, 1)[0] {
+				t.Fatalf("error: %s hashing located %v for %s", name, located, key)
+			}
+			owners[key] = located[0]
+			keys[located[0]]++
+		}
+		for _, backend := range backends {
+			if keys[backend] < 600 || keys[backend] > 1400 {
+				t.Fatalf("error: %s hashing mapped %d of 4000 keys to %s", name, keys[backend], backend)
+			}
+		}
+
+		// removal of the last backend added moves only its keys, bounded loads
+		// may move some keys of the others, as their partitions are rebalanced
+		hashing.Remove(backends[3])
+		moved := 0
+		for key, owner := range owners {
+			located := hashing.Locate(key, 1)[0]
+			if located == WebInterface(backends[3]) {
+				t.Fatalf("error: %s hashing located removed backend", name)
+			}
+			if owner != WebInterface(backends[3]) && located != owner {
+				moved++
+			}
+		}
+		if name != BoundedLoadHashing && name != MaglevHashing && moved > 0 {
+			t.Fatalf("error: %s hashing moved %d keys of other backends", name, moved)
+		}
+		if moved > 400 {
+			t.Fatalf("error: %s hashing moved %d keys of other backends", name, moved)
+		}
+	}
+}
+
+func TestNewHashing(t *testing.T) {
+	hashing, err := NewHashing("ketama:points=40", 67)
+	if err != nil || hashing.String() != "ketama:points=40" {
+		t.Fatalf("error: unexpected hashing %v, %v", hashing, err)
+	}
+	if hashing, _ = NewHashing(KetamaHashing, 67); hashing.String() != "ketama:points=67" {
+		t.Fatalf("error: replicas are not points of ketama, %s", hashing)
+	}
+
+	for _, spec := range []string{"unknown", "maglev:size=100", "jump:points=10", "bounded-load:load=0.5", "ketama:points"} {
+		if _, err := NewHashing(spec, 0); err == nil {
+			t.Fatalf("error: hashing %q should be invalid", spec)
+		}
+	}
+}
+
+func TestShardPlacement(t *testing.T) {
+	director, err := NewShardDirectorWithParams(DirectorParams{Hashing: RendezvousHashing})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	director.AddWeightedBackend(&Backend{Hostname: "light"}, 1)
+	director.AddWeightedBackend(&Backend{Hostname: "heavy"}, 3)
+
+	for i := 0; i < 4000; i++ {
+		req := NewRequest(fmt.Sprintf("/obj/%d", i), 10)
+		PickBackend(director, req)
+		PickBackend(director, req)
+	}
+
+	placement := NewShardPlacement(director)
+	if placement.totalKeys != 4000 || placement.totalBytes != 40000 {
+		t.Fatalf("error: unexpected placement %+v", placement)
+	}
+	if share := share(placement.keys[1], placement.totalKeys); share < 0.7 || share > 0.8 {
+		t.Fatalf("error: backend of weight 3 got %f of keys", share)
+	}
+	if placement.KeysCV() > 0.1 || placement.Remapped() != 0 {
+		t.Fatalf("error: unexpected keys CV %f, remapped %f", placement.KeysCV(), placement.Remapped())
+	}
+}
+
+func TestShardPlacementSample(t *testing.T) {
+	directors := []*ShardDirector{NewShardDirector(), NewShardDirector()}
+	for _, director := range directors {
+		director.AddBackend(&Backend{Hostname: "backend"})
+	}
+	for i := 0; i < 3*remapSample; i++ {
+		PickBackend(directors[i%2], NewRequest(fmt.Sprintf("/obj/%d", i%(2*remapSample)), 10))
+	}
+	for _, director := range directors {
+		if director.sample.Len() != remapSample {
+			t.Fatalf("error: director samples %d keys instead of %d", director.sample.Len(), remapSample)
+		}
+	}
+
+	// merged sample is the sample of all keys requested from the directors
+	all := NewKeySample(remapSample)
+	for i := 0; i < 2*remapSample; i++ {
+		all.Add(fmt.Sprintf("/obj/%d", i), 10)
+	}
+	merged := NewKeySample(remapSample)
+	for _, director := range directors {
+		for _, key := range director.sample.Keys() {
+			merged.Add(key, 10)
+		}
+	}
+	if fmt.Sprint(merged.Keys()) != fmt.Sprint(all.Keys()) {
+		t.Fatalf("error: merged sample differs from the sample of all keys")
+	}
+
+	placement := NewShardPlacement(directors...)
+	if placement.totalKeys != remapSample || placement.totalBytes != 10*remapSample {
+		t.Fatalf("error: placement of %d keys, %d bytes", placement.totalKeys, placement.totalBytes)
+	}
+}
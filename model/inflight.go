@@ -0,0 +1,120 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import "time"
+
+// fetched is a result of a backend fetch
+type fetched struct {
+	size int
+	ok   bool
+
+	// duration of the fetch on the simulated timeline
+	duration time.Duration
+	// origin marks a fetch from an origin
+	origin bool
+	// uncacheable marks a response, that was not cached,
+	// it can not be delivered to requests waiting for the fetch
+	uncacheable bool
+}
+
+// inflightFetch is a fetch of an object in progress, analogue to a busy object of Varnish
+type inflightFetch struct {
+	done time.Time
+	// size is a size of the object delivered to requests arriving during the fetch
+	size int
+
+	// background marks a fetch refreshing an object in grace,
+	// requests during it are served the stale object, instead of waiting
+	background bool
+	origin     bool
+
+	// pending marks a fetch, that has not ended yet, its end is not known. Only processes
+	// of the engine see pending fetches of others, they wait on the waiting list until it ends.
+	pending bool
+	waiters []*process
+	// failed marks a fetch, that failed or got an uncacheable response,
+	// requests waiting for it look the object up again
+	failed bool
+}
+
+// inflight tracks fetches in progress by the key of the object,
+// requests to a busy object wait on its waiting list to be coalesced into the fetch.
+type inflight struct {
+	fetches map[string]*inflightFetch
+	// pruning prunes completed fetches
+	pruning lazyPrune
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		fetches: make(map[string]*inflightFetch),
+	}
+}
+
+// busy returns the fetch of the object in progress at the time
+func (i *inflight) busy(key string, now time.Time) (*inflightFetch, bool) {
+	f, ok := i.fetches[key]
+	if !ok {
+		return nil, false
+	}
+	if !f.pending && !now.Before(f.done) {
+		delete(i.fetches, key)
+		return nil, false
+	}
+	return f, true
+}
+
+// begin starts tracking a fetch of the object, size is delivered to requests during
+// a background fetch, as the one of the stale object
+func (i *inflight) begin(key string, size int, background bool) *inflightFetch {
+	f := &inflightFetch{size: size, background: background, pending: true}
+	i.fetches[key] = f
+	return f
+}
+
+// end ends the fetch started at the time and resumes requests waiting for it,
+// fetches without duration or of uncacheable responses are not tracked further
+func (i *inflight) end(key string, busy *inflightFetch, now time.Time, f fetched, size int) {
+	busy.pending = false
+	busy.done = now.Add(f.duration)
+	busy.size = size
+	busy.origin = f.origin
+	busy.failed = !f.ok || f.uncacheable
+	for _, waiter := range busy.waiters {
+		waiter.resume(busy.done)
+	}
+	busy.waiters = nil
+
+	if busy.failed || f.duration <= 0 {
+		if i.fetches[key] == busy {
+			delete(i.fetches, key)
+		}
+		return
+	}
+	if i.pruning.due(len(i.fetches)) {
+		i.prune(now)
+	}
+}
+
+// wait puts the process on the waiting list of the pending fetch, until the fetch ends
+func (i *inflight) wait(busy *inflightFetch, p *process) {
+	busy.waiters = append(busy.waiters, p)
+	p.suspend()
+}
+
+// prune forgets fetches completed at the time
+func (i *inflight) prune(now time.Time) {
+	for key, f := range i.fetches {
+		if !f.pending && !now.Before(f.done) {
+			delete(i.fetches, key)
+		}
+	}
+	i.pruning.pruned(len(i.fetches))
+}
@@ -0,0 +1,368 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Link is a network link between two nodes of the topology,
+// a response over the link takes a round trip and the transfer of its body
+type Link struct {
+	RTT time.Duration `json:"rtt" yaml:"rtt"`
+	// Bandwidth is in bytes per second, zero is unlimited
+	Bandwidth float64 `json:"bandwidth" yaml:"bandwidth"`
+}
+
+// Transfer returns the time a response of the size takes over the link
+func (l Link) Transfer(bytes int) time.Duration {
+	d := l.RTT
+	if l.Bandwidth > 0 && bytes > 0 {
+		d += time.Duration(float64(bytes) / l.Bandwidth * float64(time.Second))
+	}
+	return d
+}
+
+// Validate returns an error if RTT or bandwidth is negative
+func (l Link) Validate() error {
+	if l.RTT < 0 || l.Bandwidth < 0 {
+		return fmt.Errorf("rtt and bandwidth must not be negative")
+	}
+	return nil
+}
+
+// String returns RTT and bandwidth of the link
+func (l Link) String() string {
+	if l.Bandwidth <= 0 {
+		return fmt.Sprintf("%s/unlimited", l.RTT)
+	}
+	return fmt.Sprintf("%s/%.0fB/s", l.RTT, l.Bandwidth)
+}
+
+// ServiceTime is a distribution of durations a backend takes to serve a request
+type ServiceTime interface {
+	Sample(*rand.Rand) time.Duration
+	String() string
+}
+
+// ConstantTime is a service time, that is the same for all requests
+type ConstantTime time.Duration
+
+func (c ConstantTime) Sample(_ *rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+func (c ConstantTime) String() string {
+	return time.Duration(c).String()
+}
+
+// UniformTime is a service time uniform in [Min, Max]
+type UniformTime struct {
+	Min, Max time.Duration
+}
+
+func (u UniformTime) Sample(rnd *rand.Rand) time.Duration {
+	return u.Min + time.Duration(rnd.Int63n(int64(u.Max-u.Min)+1))
+}
+
+func (u UniformTime) String() string {
+	return fmt.Sprintf("uniform:%s,%s", u.Min, u.Max)
+}
+
+// ExponentialTime is a service time exponentially distributed around the Mean
+type ExponentialTime struct {
+	Mean time.Duration
+}
+
+func (e ExponentialTime) Sample(rnd *rand.Rand) time.Duration {
+	return time.Duration(rnd.ExpFloat64() * float64(e.Mean))
+}
+
+func (e ExponentialTime) String() string {
+	return fmt.Sprintf("exponential:%s", e.Mean)
+}
+
+// LognormalTime is a service time, whose logarithm is normally distributed,
+// Median is the median of the service time, Sigma is the deviation of its logarithm
+type LognormalTime struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+func (l LognormalTime) Sample(rnd *rand.Rand) time.Duration {
+	return time.Duration(float64(l.Median) * math.Exp(l.Sigma*rnd.NormFloat64()))
+}
+
+func (l LognormalTime) String() string {
+	return fmt.Sprintf("lognormal:%s,%g", l.Median, l.Sigma)
+}
+
+// ParetoTime is a heavy-tailed service time starting at Scale
+type ParetoTime struct {
+	Scale time.Duration
+	Shape float64
+}
+
+func (p ParetoTime) Sample(rnd *rand.Rand) time.Duration {
+	// inverse transform sampling, 1-U is used to avoid division by zero
+	d := float64(p.Scale) / math.Pow(1-rnd.Float64(), 1/p.Shape)
+	if d > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+func (p ParetoTime) String() string {
+	return fmt.Sprintf("pareto:%s,%g", p.Scale, p.Shape)
+}
+
+// ParseServiceTime parses a service time in `name:param,param` format,
+// a plain duration is a constant service time.
+// Durations are the parameters, except sigma of lognormal and shape of pareto:
+// constant:<d>, uniform:<min>,<max>, exponential:<mean>, lognormal:<median>,<sigma>, pareto:<scale>,<shape>
+func ParseServiceTime(s string) (ServiceTime, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return nil, fmt.Errorf("service time must not be negative")
+		}
+		return ConstantTime(d), nil
+	}
+
+	name, rawParams, _ := strings.Cut(s, ":")
+	params := strings.Split(rawParams, ",")
+	expect := func(n int) error {
+		if rawParams == "" || len(params) != n {
+			return fmt.Errorf("%s service time expects %d parameters", name, n)
+		}
+		return nil
+	}
+	duration := func(i int) (time.Duration, error) {
+		d, err := time.ParseDuration(params[i])
+		if err == nil && d < 0 {
+			err = fmt.Errorf("%s service time expects non-negative durations", name)
+		}
+		return d, err
+	}
+
+	switch name {
+	case "constant", "exponential":
+		if err := expect(1); err != nil {
+			return nil, err
+		}
+		d, err := duration(0)
+		if err != nil {
+			return nil, err
+		}
+		if name == "constant" {
+			return ConstantTime(d), nil
+		}
+		return ExponentialTime{d}, nil
+	case "uniform":
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		min, err := duration(0)
+		if err != nil {
+			return nil, err
+		}
+		max, err := duration(1)
+		if err != nil {
+			return nil, err
+		}
+		if max < min {
+			return nil, fmt.Errorf("uniform service time expects min <= max")
+		}
+		return UniformTime{min, max}, nil
+	case "lognormal", "pareto":
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		d, err := duration(0)
+		if err != nil {
+			return nil, err
+		}
+		shape, err := strconv.ParseFloat(params[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		if name == "lognormal" {
+			return LognormalTime{d, shape}, nil
+		}
+		if d <= 0 || shape <= 0 {
+			return nil, fmt.Errorf("pareto service time expects positive scale and shape")
+		}
+		return ParetoTime{d, shape}, nil
+	}
+	return nil, fmt.Errorf("unknown service time %q", s)
+}
+
+// latencyBase is a ratio of bounds of neighbouring buckets,
+// quantiles are reported within 1% of the exact ones
+const latencyBase = 1.01
+
+// Percentiles are quantiles of response times reported, with their names
+var Percentiles = []struct {
+	Name     string
+	Quantile float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+	{"p999", 0.999},
+}
+
+// Latency is a histogram of response times with logarithmic buckets,
+// so it takes the same memory for any amount of requests
+type Latency struct {
+	// buckets holds counts by the bucket index, i-th bucket ends at latencyBase^i nanoseconds
+	buckets map[int]int
+	// zero is a count of responses without any delay, e.g. hits without the latency model
+	zero  int
+	count int
+}
+
+func NewLatency() *Latency {
+	return &Latency{buckets: make(map[int]int)}
+}
+
+// Record adds a response time to the histogram
+func (l *Latency) Record(d time.Duration) {
+	l.count++
+	if d <= 0 {
+		l.zero++
+		return
+	}
+	l.buckets[int(math.Ceil(math.Log(float64(d))/math.Log(latencyBase)))]++
+}
+
+// Merge adds response times of the other histogram
+func (l *Latency) Merge(other *Latency) *Latency {
+	l.count += other.count
+	l.zero += other.zero
+	for i, count := range other.buckets {
+		l.buckets[i] += count
+	}
+	return l
+}
+
+// Count returns an amount of response times recorded
+func (l *Latency) Count() int {
+	return l.count
+}
+
+// Measured returns if any response took time, i.e. the latency is modelled
+func (l *Latency) Measured() bool {
+	return l.count > l.zero
+}
+
+// Quantile returns the response time, the q-th share of responses took at most
+func (l *Latency) Quantile(q float64) time.Duration {
+	rank := int(math.Ceil(q * float64(l.count)))
+	if rank <= l.zero {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(l.buckets))
+	for i := range l.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	seen := l.zero
+	for _, i := range indexes {
+		seen += l.buckets[i]
+		if seen >= rank {
+			return time.Duration(math.Pow(latencyBase, float64(i)))
+		}
+	}
+	return 0
+}
+
+// quantileString returns the quantile to be printed, `-` if nothing is recorded
+func (l *Latency) quantileString(q float64) string {
+	if l.count == 0 {
+		return "-"
+	}
+	return l.Quantile(q).String()
+}
+
+// Export returns the percentiles of response times
+func (l *Latency) Export() map[string]interface{} {
+	export := map[string]interface{}{"count": l.count}
+	for _, p := range Percentiles {
+		export[p.Name] = l.Quantile(p.Quantile).String()
+	}
+	return export
+}
+
+// LatencyReport is a distribution of response times of each layer of proxies,
+// measured without the link from their clients, and in total as seen by clients
+type LatencyReport struct {
+	names  []string
+	layers []*Latency
+	total  *Latency
+}
+
+// NewLatencyReport is a constructor for LatencyReport,
+// front proxies measure response times seen by clients
+func NewLatencyReport(front []*VarnishProxy) *LatencyReport {
+	total := NewLatency()
+	for _, proxy := range front {
+		total.Merge(proxy.clientLatency)
+	}
+	return &LatencyReport{total: total}
+}
+
+// AddLayer adds response times of the layer of proxies to the report
+func (r *LatencyReport) AddLayer(name string, proxies []*VarnishProxy) *LatencyReport {
+	latency := NewLatency()
+	for _, proxy := range proxies {
+		latency.Merge(proxy.latency)
+	}
+	r.names = append(r.names, name)
+	r.layers = append(r.layers, latency)
+	return r
+}
+
+// Measured returns if any response took time, i.e. the latency is modelled
+func (r *LatencyReport) Measured() bool {
+	return r.total.Measured()
+}
+
+func (r *LatencyReport) TableData() (name string, rows [][]string) {
+	name = "Latency"
+	for i, layer := range r.layers {
+		for _, p := range Percentiles {
+			rows = append(rows, []string{fmt.Sprintf("%s %s", r.names[i], p.Name), layer.quantileString(p.Quantile)})
+		}
+	}
+	for _, p := range Percentiles {
+		rows = append(rows, []string{fmt.Sprintf("total %s", p.Name), r.total.quantileString(p.Quantile)})
+	}
+	return
+}
+
+func (r *LatencyReport) Export() map[string]interface{} {
+	layers := make(map[string]interface{})
+	for i, layer := range r.layers {
+		layers[r.names[i]] = layer.Export()
+	}
+	return map[string]interface{}{
+		"latency": map[string]interface{}{
+			"layers": layers,
+			"total":  r.total.Export(),
+		},
+	}
+}
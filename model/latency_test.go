@@ -0,0 +1,103 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseServiceTime(t *testing.T) {
+	valid := map[string]ServiceTime{
+		"200ms":               ConstantTime(200 * time.Millisecond),
+		"constant:1s":         ConstantTime(time.Second),
+		"uniform:10ms,50ms":   UniformTime{10 * time.Millisecond, 50 * time.Millisecond},
+		"exponential:100ms":   ExponentialTime{100 * time.Millisecond},
+		"lognormal:100ms,0.5": LognormalTime{100 * time.Millisecond, 0.5},
+		"pareto:50ms,1.5":     ParetoTime{50 * time.Millisecond, 1.5},
+	}
+	for spec, want := range valid {
+		serviceTime, err := ParseServiceTime(spec)
+		if err != nil {
+			t.Fatalf("error: %s: %v", spec, err)
+		}
+		if serviceTime != want {
+			t.Fatalf("error: %s parsed as %v", spec, serviceTime)
+		}
+	}
+
+	for _, invalid := range []string{"-1s", "uniform:50ms,10ms", "uniform:10ms", "pareto:0s,1", "normal:1s", "constant:x"} {
+		if _, err := ParseServiceTime(invalid); err == nil {
+			t.Fatalf("error: service time %q should not be parsed", invalid)
+		}
+	}
+}
+
+func TestServiceTimeSample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	uniform := UniformTime{10 * time.Millisecond, 20 * time.Millisecond}
+	for i := 0; i < 1000; i++ {
+		if d := uniform.Sample(rnd); d < uniform.Min || d > uniform.Max {
+			t.Fatalf("error: %s sampled out of %s", d, uniform)
+		}
+	}
+
+	pareto := ParetoTime{50 * time.Millisecond, 1.5}
+	for i := 0; i < 1000; i++ {
+		if d := pareto.Sample(rnd); d < pareto.Scale {
+			t.Fatalf("error: %s sampled below scale of %s", d, pareto)
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	link := Link{RTT: 10 * time.Millisecond, Bandwidth: 1000}
+	if d := link.Transfer(500); d != 510*time.Millisecond {
+		t.Fatalf("error: transfer took %s instead of 510ms", d)
+	}
+	if d := link.Transfer(0); d != link.RTT {
+		t.Fatalf("error: transfer without a body took %s instead of RTT", d)
+	}
+	if d := (Link{RTT: time.Millisecond}).Transfer(1 << 30); d != time.Millisecond {
+		t.Fatalf("error: transfer over unlimited link took %s", d)
+	}
+	if (Link{RTT: -time.Second}).Validate() == nil {
+		t.Fatalf("error: negative RTT should not be valid")
+	}
+}
+
+func TestLatencyQuantile(t *testing.T) {
+	latency := NewLatency()
+	for i := 1; i <= 1000; i++ {
+		latency.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	for _, p := range Percentiles {
+		want := p.Quantile * float64(time.Second)
+		got := float64(latency.Quantile(p.Quantile))
+		if math.Abs(got-want)/want > 0.01 {
+			t.Fatalf("error: %s is %s instead of %s", p.Name, time.Duration(got), time.Duration(want))
+		}
+	}
+
+	// responses without delay are the fastest ones
+	other := NewLatency()
+	for i := 0; i < 1000; i++ {
+		other.Record(0)
+	}
+	latency.Merge(other)
+	if latency.Count() != 2000 || latency.Quantile(0.5) != 0 || latency.Quantile(0.9) == 0 {
+		t.Fatalf("error: unexpected merged latency %v", latency.Export())
+	}
+	if other.Measured() {
+		t.Fatalf("error: responses without delay should not be measured")
+	}
+}
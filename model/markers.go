@@ -0,0 +1,116 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// HitForMiss markers send requests to the backend, a cacheable response replaces the marker
+	HitForMiss = "hit-for-miss"
+	// HitForPass markers pass requests to the backend, responses are not cached until the marker expires
+	HitForPass = "hit-for-pass"
+
+	// DefaultMarkerTTL is a TTL of hit-for-miss markers set by the builtin VCL
+	DefaultMarkerTTL = 120 * time.Second
+)
+
+// Markers returns kinds of markers of uncacheable objects
+func Markers() []string {
+	return []string{HitForMiss, HitForPass}
+}
+
+// ValidateMarker returns an error if the kind of markers is unknown
+func ValidateMarker(kind string) error {
+	for _, known := range Markers() {
+		if kind == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown marker %q, use one of %v", kind, Markers())
+}
+
+// markers tracks hit-for-miss or hit-for-pass objects, that replace uncacheable objects in the cache.
+// Requests to a marked object go to the backend without waiting for each other.
+type markers struct {
+	// expires holds the end of life of each marker
+	expires map[string]time.Time
+
+	// kind of the markers, ttl is their lifetime, zero ttl creates no markers
+	kind string
+	ttl  time.Duration
+
+	// pruning prunes expired markers
+	pruning lazyPrune
+}
+
+func newMarkers(kind string, ttl time.Duration) *markers {
+	return &markers{
+		expires: make(map[string]time.Time),
+		kind:    kind,
+		ttl:     ttl,
+	}
+}
+
+// pass returns if the marked objects are passed, instead of being fetched as misses
+func (m *markers) pass() bool {
+	return m.kind == HitForPass
+}
+
+// marked returns if the object has a marker at the time
+func (m *markers) marked(key string, now time.Time) bool {
+	expires, ok := m.expires[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(expires) {
+		delete(m.expires, key)
+		return false
+	}
+	return true
+}
+
+// mark creates a marker of the uncacheable object at the time
+func (m *markers) mark(key string, now time.Time) {
+	if m.ttl <= 0 {
+		return
+	}
+
+	m.expires[key] = now.Add(m.ttl)
+	if m.pruning.due(len(m.expires)) {
+		m.prune(now)
+	}
+}
+
+// remove removes the marker, as a cacheable response replaces it
+func (m *markers) remove(key string) {
+	delete(m.expires, key)
+}
+
+// prune forgets markers expired at the time
+func (m *markers) prune(now time.Time) {
+	for key, expires := range m.expires {
+		if !now.Before(expires) {
+			delete(m.expires, key)
+		}
+	}
+	m.pruning.pruned(len(m.expires))
+}
+
+// builtin returns if the markers are the hit-for-miss ones of the builtin VCL
+func (m *markers) builtin() bool {
+	return m.kind == HitForMiss && m.ttl == DefaultMarkerTTL
+}
+
+// String returns kind and TTL of the markers
+func (m *markers) String() string {
+	return fmt.Sprintf("%s/%s", m.kind, m.ttl)
+}
@@ -10,47 +10,194 @@ package model
 
 import "fmt"
 
-// CacheMetric is a struct for cache hit/miss metrics
+// CacheMetric is a struct for cache hit/miss metrics,
+// counted both in requests and in bytes delivered
 type CacheMetric struct {
 	hit  int
 	miss int
+
+	// stale is a count of hits on objects in grace
+	stale int
+	// revalidation is a count of misses on objects in keep,
+	// that were fetched conditionally
+	revalidation int
+	// coalesced is a count of requests, that waited for a fetch in progress,
+	// they are neither hits nor misses
+	coalesced int
+	// hitForMiss and hitForPass are counts of requests passed to the backend
+	// by markers of uncacheable objects, they are neither hits nor misses
+	hitForMiss int
+	hitForPass int
+	// variantMiss is a count of misses of variants, while another variant
+	// of the object was cached, they would be hits, if responses did not vary
+	variantMiss int
+
+	// bytes delivered on hits, stale hits, misses and the rest
+	hitBytes       int
+	staleBytes     int
+	missBytes      int
+	coalescedBytes int
+	passBytes      int
+
+	// window holds counters at the last step, to compute metrics of a time window
+	window struct {
+		hit       int
+		stale     int
+		coalesced int
+		pass      int
+		total     int
+
+		hitBytes   int
+		staleBytes int
+		totalBytes int
+	}
 }
 
 // Hit increments the hit counter
-func (m *CacheMetric) Hit() {
+func (m *CacheMetric) Hit(bytes int) {
 	m.hit++
+	m.hitBytes += bytes
 }
 
 // Miss increments the miss counter
-func (m *CacheMetric) Miss() {
+func (m *CacheMetric) Miss(bytes int) {
 	m.miss++
+	m.missBytes += bytes
+}
+
+// StaleHit increments the counter of hits served from grace
+func (m *CacheMetric) StaleHit(bytes int) {
+	m.stale++
+	m.staleBytes += bytes
+}
+
+// Revalidation increments the miss counter and the counter of conditional fetches
+func (m *CacheMetric) Revalidation(bytes int) {
+	m.Miss(bytes)
+	m.revalidation++
+}
+
+// Coalesced increments the counter of requests coalesced into a fetch in progress
+func (m *CacheMetric) Coalesced(bytes int) {
+	m.coalesced++
+	m.coalescedBytes += bytes
+}
+
+// HitForMiss increments the counter of requests passed by hit-for-miss markers
+func (m *CacheMetric) HitForMiss(bytes int) {
+	m.hitForMiss++
+	m.passBytes += bytes
+}
+
+// HitForPass increments the counter of requests passed by hit-for-pass markers
+func (m *CacheMetric) HitForPass(bytes int) {
+	m.hitForPass++
+	m.passBytes += bytes
 }
 
+// VariantMiss increments the counter of misses caused by variants, the miss itself is counted by Miss
+func (m *CacheMetric) VariantMiss() {
+	m.variantMiss++
+}
+
+// VariantCost returns a share of requests, that missed only because
+// of variants, as the hit ratio lost to them
+func (m *CacheMetric) VariantCost() float64 {
+	return ratio(m.variantMiss, m.Total())
+}
+
+// Pass returns the count of requests passed to the backend by markers
+func (m *CacheMetric) Pass() int {
+	return m.hitForMiss + m.hitForPass
+}
+
+// CHR returns cache hit ratio, hits served from grace are counted as hits
 func (m *CacheMetric) CHR() float64 {
-	total := m.hit + m.miss
-	if total == 0 {
-		return 0
-	}
-	return float64(m.hit) / float64(total)
+	return ratio(m.hit+m.stale, m.Total())
+}
+
+// BHR returns byte hit ratio, a share of bytes delivered from the cache
+func (m *CacheMetric) BHR() float64 {
+	return ratio(m.hitBytes+m.staleBytes, m.TotalBytes())
 }
 
 func (m *CacheMetric) StepHeader() string {
-	return "hit miss"
+	return "chr window_chr bhr window_bhr window_coalesced window_pass"
 }
 
+// Step returns cumulative cache and byte hit ratios and the ones
+// of the window since the previous step, with requests coalesced
+// and passed in the window. Starts a new window.
 func (m *CacheMetric) Step() string {
-	return fmt.Sprintf("%f", m.CHR())
+	step := fmt.Sprintf("%f %f %f %f %d %d", m.CHR(), m.WindowCHR(), m.BHR(), m.WindowBHR(),
+		m.coalesced-m.window.coalesced, m.Pass()-m.window.pass)
+
+	m.window.hit = m.hit
+	m.window.stale = m.stale
+	m.window.coalesced = m.coalesced
+	m.window.pass = m.Pass()
+	m.window.total = m.Total()
+	m.window.hitBytes = m.hitBytes
+	m.window.staleBytes = m.staleBytes
+	m.window.totalBytes = m.TotalBytes()
+
+	return step
+}
+
+// WindowCHR returns cache hit ratio since the previous step
+func (m *CacheMetric) WindowCHR() float64 {
+	return ratio(m.hit+m.stale-m.window.hit-m.window.stale, m.Total()-m.window.total)
 }
 
+// WindowBHR returns byte hit ratio since the previous step
+func (m *CacheMetric) WindowBHR() float64 {
+	return ratio(m.hitBytes+m.staleBytes-m.window.hitBytes-m.window.staleBytes, m.TotalBytes()-m.window.totalBytes)
+}
+
+// Total returns the count of all requests, passed ones included,
+// so uncacheable traffic lowers the hit ratio
 func (m *CacheMetric) Total() int {
-	return m.hit + m.miss
+	return m.hit + m.stale + m.miss + m.coalesced + m.Pass()
+}
+
+// TotalBytes returns bytes delivered on all requests
+func (m *CacheMetric) TotalBytes() int {
+	return m.hitBytes + m.staleBytes + m.missBytes + m.coalescedBytes + m.passBytes
 }
 
 // ExportType returns a map of cache hit/miss for exporting
 //
 //	as these fields are private
 func (m *CacheMetric) ExportType() map[string]float64 {
-	return map[string]float64{"hit": float64(m.hit), "miss": float64(m.miss), "total": float64(m.Total()), "hit_ratio": m.CHR()}
+	return map[string]float64{
+		"hit":                    float64(m.hit),
+		"stale_hit":              float64(m.stale),
+		"miss":                   float64(m.miss),
+		"revalidation":           float64(m.revalidation),
+		"coalesced":              float64(m.coalesced),
+		"hit_for_miss":           float64(m.hitForMiss),
+		"hit_for_pass":           float64(m.hitForPass),
+		"pass":                   float64(m.Pass()),
+		"variant_miss":           float64(m.variantMiss),
+		"variant_hit_ratio_cost": m.VariantCost(),
+		"total":                  float64(m.Total()),
+		"hit_ratio":              m.CHR(),
+		"hit_bytes":              float64(m.hitBytes),
+		"stale_hit_bytes":        float64(m.staleBytes),
+		"miss_bytes":             float64(m.missBytes),
+		"coalesced_bytes":        float64(m.coalescedBytes),
+		"pass_bytes":             float64(m.passBytes),
+		"total_bytes":            float64(m.TotalBytes()),
+		"byte_hit_ratio":         m.BHR(),
+	}
+}
+
+// ratio returns part/total, or 0 if total is 0
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
 }
 
 // RoutingMetric is a map showing traffic info that was routed to each backend
@@ -66,3 +213,90 @@ func (m RoutingMetric[T]) ExportType() map[string]T {
 
 	return result
 }
+
+// OriginOffload is a share of client traffic served by proxies
+// without reaching origins, in requests and in bytes
+type OriginOffload struct {
+	Requests int
+	Bytes    int
+	// Errors are client requests answered with an error
+	Errors         int
+	OriginRequests int
+	OriginBytes    int
+
+	// Coalesced are requests coalesced into fetches in progress on all proxies,
+	// OriginFetchesSaved are the ones coalesced into fetches from origins
+	Coalesced          int
+	OriginFetchesSaved int
+}
+
+// NewOriginOffload sums client traffic of the proxies and traffic served by the origins
+func NewOriginOffload(proxies []*VarnishProxy, origins []*Backend) OriginOffload {
+	offload := OriginOffload{}
+	for _, proxy := range proxies {
+		offload.Requests += proxy.clientRequests
+		offload.Bytes += proxy.clientBytes
+		offload.Errors += proxy.clientErrors
+		offload.Coalesced += proxy.coalesced
+		offload.OriginFetchesSaved += proxy.originFetchesSaved
+	}
+	for _, origin := range origins {
+		offload.OriginRequests += origin.Requests()
+		offload.OriginBytes += origin.Bytes()
+	}
+	return offload
+}
+
+// Sub returns offload of traffic since the previous offload
+func (o OriginOffload) Sub(previous OriginOffload) OriginOffload {
+	return OriginOffload{
+		Requests:       o.Requests - previous.Requests,
+		Bytes:          o.Bytes - previous.Bytes,
+		Errors:         o.Errors - previous.Errors,
+		OriginRequests: o.OriginRequests - previous.OriginRequests,
+		OriginBytes:    o.OriginBytes - previous.OriginBytes,
+
+		Coalesced:          o.Coalesced - previous.Coalesced,
+		OriginFetchesSaved: o.OriginFetchesSaved - previous.OriginFetchesSaved,
+	}
+}
+
+// RequestOffload returns a share of client requests, that did not reach origins
+func (o OriginOffload) RequestOffload() float64 {
+	return ratio(o.Requests-o.OriginRequests, o.Requests)
+}
+
+// ByteOffload returns a share of client bytes, that were not fetched from origins
+func (o OriginOffload) ByteOffload() float64 {
+	return ratio(o.Bytes-o.OriginBytes, o.Bytes)
+}
+
+func (o OriginOffload) TableData() (name string, rows [][]string) {
+	name = "Origin Offload"
+	rows = append(rows, []string{"Client requests", fmt.Sprintf("%d", o.Requests)})
+	rows = append(rows, []string{"Client errors", fmt.Sprintf("%d", o.Errors)})
+	rows = append(rows, []string{"Origin requests", fmt.Sprintf("%d", o.OriginRequests)})
+	rows = append(rows, []string{"Request offload", fmt.Sprintf("%f", o.RequestOffload())})
+	rows = append(rows, []string{"Client bytes", fmt.Sprintf("%d", o.Bytes)})
+	rows = append(rows, []string{"Origin bytes", fmt.Sprintf("%d", o.OriginBytes)})
+	rows = append(rows, []string{"Byte offload", fmt.Sprintf("%f", o.ByteOffload())})
+	rows = append(rows, []string{"Coalesced", fmt.Sprintf("%d", o.Coalesced)})
+	rows = append(rows, []string{"Origin saved", fmt.Sprintf("%d", o.OriginFetchesSaved)})
+	return
+}
+
+func (o OriginOffload) Export() map[string]interface{} {
+	return map[string]interface{}{
+		"offload": map[string]interface{}{
+			"requests":        o.Requests,
+			"errors":          o.Errors,
+			"origin_requests": o.OriginRequests,
+			"request_offload": o.RequestOffload(),
+			"bytes":           o.Bytes,
+			"origin_bytes":    o.OriginBytes,
+			"byte_offload":    o.ByteOffload(),
+			"coalesced":       o.Coalesced,
+			"origin_saved":    o.OriginFetchesSaved,
+		},
+	}
+}
@@ -8,30 +8,149 @@
 
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
 
 // WebInterface is a representation of a web server, web accelerator, or any other web service
 type WebInterface interface {
 	// Get returns the size of the object in bytes
-	Get(string, int) int
+	Get(*Request) int
 
 	// String returns the name of the web interface
 	String() string
 }
 
+// Healther is a web interface, that tells its health,
+// as directors used as backends do by health of their backends
+type Healther interface {
+	Healthy() bool
+}
+
+// HealthChecked is a web interface probed by directors,
+// unhealthy ones are skipped the way Varnish skips sick backends.
+type HealthChecked interface {
+	Healther
+	SetHealthy(bool)
+}
+
+// IsHealthy returns if the web interface is healthy,
+// the ones without health checks are always healthy
+func IsHealthy(w WebInterface) bool {
+	// directors used as backends are healthy, if any of their backends is
+	if h, ok := w.(Healther); ok {
+		return h.Healthy()
+	}
+	return true
+}
+
 // Backend is representation of leaf-node in simulation topology
 // If you need more complex business logic of Backend request processing,
 // create a new representation and implement a WebInterface.
 type Backend struct {
 	Hostname string
+	// ServiceTime is a distribution of durations of fetches from the backend,
+	// nil serves requests instantly
+	ServiceTime ServiceTime
+	// rnd samples the service time, it is seeded by the hostname to be reproducible
+	rnd *rand.Rand
+	// pool is workers serving requests, nil if they are unlimited
+	pool *pool
+
 	requests int
+
+	// revalidations is a count of conditional requests
+	revalidations int
+	// bytes is an amount of bytes served, conditional requests are answered without a body
+	bytes int
+
+	// down marks the backend failing its health checks
+	down bool
+
+	// client traffic of the site the backend serves, counted by front proxies
+	// when requests are routed to origins by a router
+	clientRequests int
+	clientBytes    int
+	clientErrors   int
+
+	// window holds counters at the last step
+	window struct {
+		requests int
+		bytes    int
+	}
 }
 
 // Get interface WebInterface for Backend
 // we do not need to use url, as no logic is implemented
-func (b *Backend) Get(_ string, size int) int {
+func (b *Backend) Get(req *Request) int {
+	// request waits in the queue for a worker, or is rejected if the queue is full
+	start, ok := b.pool.acquire(req.process, req.Timestamp)
+	if !ok {
+		req.Failed = true
+		req.Duration = 0
+		return 0
+	}
+	service := b.serviceTime()
+	b.pool.release(req.process, start, service)
+	req.Duration = start.Sub(req.Timestamp) + service
 	b.requests++
-	return size
+	if req.Conditional {
+		b.revalidations++
+	} else {
+		b.bytes += req.Size
+	}
+	return req.Size
+}
+
+// SetCapacity sets the amount of workers of the backend and the length of its queue
+func (b *Backend) SetCapacity(c Capacity) {
+	b.pool = newPool(c)
+}
+
+// serviceTime samples a duration of the fetch from the service time distribution
+func (b *Backend) serviceTime() time.Duration {
+	if b.ServiceTime == nil {
+		return 0
+	}
+	if b.rnd == nil {
+		h := fnv.New64a()
+		h.Write([]byte(b.Hostname))
+		b.rnd = rand.New(rand.NewSource(int64(h.Sum64())))
+	}
+	return b.ServiceTime.Sample(b.rnd)
+}
+
+// Requests returns the count of requests served
+func (b *Backend) Requests() int {
+	return b.requests
+}
+
+// Bytes returns the amount of bytes served
+func (b *Backend) Bytes() int {
+	return b.bytes
+}
+
+// client counts a client request of the site the backend serves
+func (b *Backend) client(bytes int, failed bool) {
+	b.clientRequests++
+	b.clientBytes += bytes
+	if failed {
+		b.clientErrors++
+	}
+}
+
+// Offload returns the offload of the site the backend serves
+func (b *Backend) Offload() OriginOffload {
+	return OriginOffload{
+		Requests:       b.clientRequests,
+		Bytes:          b.clientBytes,
+		Errors:         b.clientErrors,
+		OriginRequests: b.requests,
+		OriginBytes:    b.bytes,
+	}
 }
 
 // String returns the name of the web interface
@@ -39,18 +158,51 @@ func (b *Backend) String() string {
 	return b.Hostname
 }
 
+// Healthy returns if the backend passes its health checks
+func (b *Backend) Healthy() bool {
+	return !b.down
+}
+
+// SetHealthy marks the backend as healthy or down
+func (b *Backend) SetHealthy(healthy bool) {
+	b.down = !healthy
+}
+
+func (b *Backend) StepHeader() string {
+	return "requests window_requests bytes window_bytes " + b.pool.StepHeader()
+}
+
+// Step returns served requests and bytes, both cumulative
+// and since the previous step, starts a new window.
+func (b *Backend) Step() string {
+	step := fmt.Sprintf("%d %d %d %d %s", b.requests, b.requests-b.window.requests, b.bytes, b.bytes-b.window.bytes, b.pool.Step())
+
+	b.window.requests = b.requests
+	b.window.bytes = b.bytes
+
+	return step
+}
+
 func (b *Backend) Export() map[string]interface{} {
-	return map[string]interface{}{
-		"backend": map[string]interface{}{
-			"hostname": b.Hostname,
-			"requests": b.requests,
-		},
+	self := map[string]interface{}{
+		"hostname":      b.Hostname,
+		"requests":      b.requests,
+		"revalidations": b.revalidations,
+		"bytes":         b.bytes,
+	}
+	if b.clientRequests > 0 {
+		// site traffic is known only for routed requests
+		self["site"] = b.Offload().Export()["offload"]
 	}
+	if b.pool != nil {
+		self["capacity"] = b.pool.Export()
+	}
+	return map[string]interface{}{"backend": self}
 }
 
 // VarnishProxy is a representation of a Varnish proxy
 type VarnishProxy struct {
-	cache    *CacheStorage[string, int] // cache [request URI, object bytes]
+	cache    Storage[string, int] // cache [request URI, object bytes], may be split into Pools
 	director Director
 	hostname string
 
@@ -59,25 +211,140 @@ type VarnishProxy struct {
 	// metrics
 	cacheMetric   CacheMetric
 	routingMetric RoutingMetric[int]
+	// routingBytes is an amount of bytes fetched from each backend
+	routingBytes RoutingMetric[int]
+
+	// client traffic, counted also before warmup to compute origin offload
+	clientRequests int
+	clientBytes    int
+	// clientErrors is a count of client requests answered with an error
+	clientErrors int
+	// fetchFailures is a count of fetches without a healthy backend
+	fetchFailures int
+	// coalesced is a count of requests coalesced into fetches in progress after warmup,
+	// originFetchesSaved counts the ones coalesced into fetches from origins
+	coalesced          int
+	originFetchesSaved int
+
+	// down marks the proxy failing its health checks
+	down bool
 
 	// warmuped is a flag to indicate that the VarnishProxy has been warmed up
 	warmuped bool
+
+	// lifetime is a default lifetime of cached objects,
+	// zero TTL keeps objects forever, unless the request sets TTL.
+	lifetime Lifetime
+	// expiry tracks objects with limited lifetime
+	expiry *expiry
+	// inflight tracks fetches in progress on the simulated timeline
+	inflight *inflight
+	// markers are hit-for-miss or hit-for-pass objects of uncacheable responses
+	markers *markers
+	// variants tracks variants of objects, whose responses vary
+	variants *variants
+	// varied is set, once the proxy has seen a request varying by headers
+	varied bool
+	// router resolves sites of client requests, nil if requests are not routed
+	router *Router
+
+	// uplink is the link to backends of the proxy, clientLink is the one to clients,
+	// that is used only for client requests
+	uplink     Link
+	clientLink Link
+	// latency holds response times of the proxy, clientLatency holds the ones seen by clients
+	latency       *Latency
+	clientLatency *Latency
+	// pool is workers serving requests, nil if they are unlimited
+	pool *pool
+	// overhead is a memory overhead of cached objects
+	overhead Overhead
+	// admission decides, which fetched objects are cached, nil if all of them are
+	admission Admission
+	// optimal is the offline optimal storage beside the cache,
+	// nil if the gap to optimal is not measured
+	optimal *optimal
+	// future is the future of the trace, storages of the optimal policy look next uses
+	// of objects up in it, nil if the trace was not scanned
+	future *Future
 }
 
 func (v *VarnishProxy) TableData() (name string, rows [][]string) {
 	name = "VarnishProxy"
 	rows = append(rows, []string{"Hostname", v.hostname})
 	rows = append(rows, []string{"Cache Size", fmt.Sprintf("%d", v.cache.Size())})
-	rows = append(rows, []string{"Cache Used", fmt.Sprintf("%d", v.cache.stored)})
+	rows = append(rows, []string{"Cache Used", fmt.Sprintf("%d", v.cache.Stored())})
+	if v.overhead.Enabled() {
+		nominal := nominalOf(v.cache)
+		rows = append(rows, []string{"Cache Used nominal", fmt.Sprintf("%d", nominal)})
+		rows = append(rows, []string{"Overhead", v.overhead.String()})
+		rows = append(rows, []string{"Overhead bytes", fmt.Sprintf("%d (%f)", v.cache.Stored()-nominal, share(v.cache.Stored()-nominal, v.cache.Stored()))})
+	}
+	rows = append(rows, []string{"Eviction", v.cache.String()})
+	for _, pool := range v.PoolUsage() {
+		rows = append(rows, []string{"Pool " + pool.Name, fmt.Sprintf("%d/%d (%f) %s", pool.Used, pool.Size, share(pool.Used, pool.Size), pool.Eviction)})
+		if v.overhead.Enabled() {
+			rows = append(rows, []string{"Pool " + pool.Name + " nominal", fmt.Sprintf("%d", pool.Nominal)})
+		}
+	}
+	if v.admission != nil {
+		admitted, rejected := v.admission.counts()
+		rows = append(rows, []string{"Admission", v.admission.String()})
+		rows = append(rows, []string{"Admitted", fmt.Sprintf("%d", admitted)})
+		rows = append(rows, []string{"Admission rejected", fmt.Sprintf("%d", rejected)})
+	}
+	rows = append(rows, []string{"TTL/Grace/Keep", v.lifetime.String()})
+	if !v.markers.builtin() || v.cacheMetric.Pass() > 0 {
+		rows = append(rows, []string{"Markers", v.markers.String()})
+	}
+	rows = append(rows, []string{"Uplink", v.uplink.String()})
+	rows = append(rows, []string{"Workers/Queue", v.capacity().String()})
 	rows = append(rows, []string{"Routes To", fmt.Sprintf("%s", generateRoutesTo(v))})
 
 	cacheMetric := v.cacheMetric.ExportType()
 	rows = append(rows, []string{"Cache hit", fmt.Sprintf("%f", cacheMetric["hit"])})
+	rows = append(rows, []string{"Stale hit", fmt.Sprintf("%f", cacheMetric["stale_hit"])})
 	rows = append(rows, []string{"Cache miss", fmt.Sprintf("%f", cacheMetric["miss"])})
-	rows = append(rows, []string{"CHR", fmt.Sprintf("%f", cacheMetric["hit"]/(cacheMetric["hit"]+cacheMetric["miss"]))})
+	rows = append(rows, []string{"Revalidation", fmt.Sprintf("%f", cacheMetric["revalidation"])})
+	rows = append(rows, []string{"Hit-for-miss", fmt.Sprintf("%f", cacheMetric["hit_for_miss"])})
+	rows = append(rows, []string{"Hit-for-pass", fmt.Sprintf("%f", cacheMetric["hit_for_pass"])})
+	rows = append(rows, []string{"CHR", fmt.Sprintf("%f", cacheMetric["hit_ratio"])})
+	rows = append(rows, []string{"Hit bytes", fmt.Sprintf("%.0f", cacheMetric["hit_bytes"]+cacheMetric["stale_hit_bytes"])})
+	rows = append(rows, []string{"Miss bytes", fmt.Sprintf("%.0f", cacheMetric["miss_bytes"])})
+	rows = append(rows, []string{"Pass bytes", fmt.Sprintf("%.0f", cacheMetric["pass_bytes"])})
+	rows = append(rows, []string{"BHR", fmt.Sprintf("%f", cacheMetric["byte_hit_ratio"])})
+	if v.optimal != nil {
+		optimal := v.optimal.export(&v.cacheMetric)
+		rows = append(rows, []string{"Optimal CHR", fmt.Sprintf("%f", optimal["hit_ratio"])})
+		rows = append(rows, []string{"CHR gap to optimal", fmt.Sprintf("%f", optimal["hit_ratio_gap"])})
+		rows = append(rows, []string{"Optimal BHR", fmt.Sprintf("%f", optimal["byte_hit_ratio"])})
+		rows = append(rows, []string{"BHR gap to optimal", fmt.Sprintf("%f", optimal["byte_hit_ratio_gap"])})
+		rows = append(rows, []string{"Optimal future accuracy", fmt.Sprintf("%f", optimal["future_accuracy"])})
+	}
+	if v.varied {
+		variants, variantBytes := v.variants.usage(v.cache)
+		rows = append(rows, []string{"Variants", fmt.Sprintf("%d", variants)})
+		rows = append(rows, []string{"Variant bytes", fmt.Sprintf("%d", variantBytes)})
+		rows = append(rows, []string{"Variant misses", fmt.Sprintf("%f", cacheMetric["variant_miss"])})
+		rows = append(rows, []string{"Variant CHR cost", fmt.Sprintf("%f", cacheMetric["variant_hit_ratio_cost"])})
+	}
+	rows = append(rows, []string{"Fetch failed", fmt.Sprintf("%d", v.fetchFailures)})
+	rows = append(rows, []string{"Coalesced", fmt.Sprintf("%d", v.coalesced)})
+	rows = append(rows, []string{"Origin saved", fmt.Sprintf("%d", v.originFetchesSaved)})
+	if v.latency.Measured() {
+		for _, p := range Percentiles {
+			rows = append(rows, []string{"Latency " + p.Name, v.latency.Quantile(p.Quantile).String()})
+		}
+	}
+	if v.pool != nil {
+		rows = append(rows, []string{"Utilization", fmt.Sprintf("%f", v.pool.utilization())})
+		rows = append(rows, []string{"Queued", fmt.Sprintf("%d", v.pool.queued)})
+		rows = append(rows, []string{"Queue rejected", fmt.Sprintf("%d", v.pool.rejected)})
+	}
 
-	for k, v := range v.routingMetric {
-		rows = append(rows, []string{fmt.Sprintf("-> %s", k.String()), fmt.Sprintf("%d", v)})
+	for k, requests := range v.routingMetric {
+		rows = append(rows, []string{fmt.Sprintf("-> %s", k.String()), fmt.Sprintf("%d", requests)})
+		rows = append(rows, []string{fmt.Sprintf("-> %s bytes", k.String()), fmt.Sprintf("%d", v.routingBytes[k])})
 	}
 
 	return
@@ -88,19 +355,55 @@ func (v *VarnishProxy) Hostname() string {
 }
 
 func (v *VarnishProxy) StepHeader() string {
-	return v.cacheMetric.StepHeader()
+	return v.cacheMetric.StepHeader() + " " + v.pool.StepHeader()
 }
 
 func (v *VarnishProxy) Step() string {
-	return v.cacheMetric.Step()
+	return v.cacheMetric.Step() + " " + v.pool.Step()
 }
 
 func (v *VarnishProxy) Export() map[string]interface{} {
 	self := make(map[string]interface{})
 	self["cache"] = v.cacheMetric.ExportType()
 	self["routing"] = v.routingMetric.ExportType()
+	self["routing_bytes"] = v.routingBytes.ExportType()
+	self["fetch_failed"] = v.fetchFailures
+	self["origin_fetches_saved"] = v.originFetchesSaved
 	self["cache_size"] = v.cache.Size()
-	self["cache_used"] = v.cache.stored
+	self["cache_used"] = v.cache.Stored()
+	self["cache_used_nominal"] = nominalOf(v.cache)
+	self["overhead"] = map[string]interface{}{"per_object": v.overhead.PerObject, "size_classes": v.overhead.SizeClasses}
+	variants, variantBytes := v.variants.usage(v.cache)
+	self["variants"] = variants
+	self["variant_bytes"] = variantBytes
+	self["eviction"] = v.cache.String()
+	if usage := v.PoolUsage(); usage != nil {
+		pools := make(map[string]interface{})
+		for _, pool := range usage {
+			pools[pool.Name] = map[string]interface{}{
+				"size":     pool.Size,
+				"used":     pool.Used,
+				"nominal":  pool.Nominal,
+				"share":    share(pool.Used, pool.Size),
+				"eviction": pool.Eviction,
+			}
+		}
+		self["pools"] = pools
+	}
+	if v.admission != nil {
+		admitted, rejected := v.admission.counts()
+		self["admission"] = map[string]interface{}{"policy": v.admission.String(), "admitted": admitted, "rejected": rejected}
+	}
+	if v.optimal != nil {
+		self["optimal"] = v.optimal.export(&v.cacheMetric)
+	}
+	self["lifetime"] = v.lifetime.Export()
+	self["markers"] = map[string]string{"kind": v.markers.kind, "ttl": v.markers.ttl.String()}
+	self["uplink"] = map[string]interface{}{"rtt": v.uplink.RTT.String(), "bandwidth": v.uplink.Bandwidth}
+	self["latency"] = v.latency.Export()
+	if v.pool != nil {
+		self["capacity"] = v.pool.Export()
+	}
 	self["routes_to"] = generateRoutesTo(v)
 
 	export := make(map[string]interface{})
@@ -125,8 +428,9 @@ func generateRoutesTo(v *VarnishProxy) []string {
 // NewVarnishProxy
 // argument Hostname
 // size - storage's size in bytes
-func NewVarnishProxy(hostname string, size int) (*VarnishProxy, error) {
-	storage, err := NewCacheStorage[string, int](size)
+// policy - eviction policy of the storage, empty string for default (LRU)
+func NewVarnishProxy(hostname string, size int, policy string) (*VarnishProxy, error) {
+	storage, err := NewStorage[string, int](policy, size)
 	if err != nil {
 		return nil, err
 	}
@@ -134,6 +438,10 @@ func NewVarnishProxy(hostname string, size int) (*VarnishProxy, error) {
 	proxy := VarnishProxy{
 		hostname: hostname,
 		cache:    storage,
+		expiry:   newExpiry(),
+		inflight: newInflight(),
+		markers:  newMarkers(HitForMiss, DefaultMarkerTTL),
+		variants: newVariants(),
 	}
 
 	proxy.initializeMetrics()
@@ -143,6 +451,41 @@ func NewVarnishProxy(hostname string, size int) (*VarnishProxy, error) {
 
 func (v *VarnishProxy) initializeMetrics() {
 	v.routingMetric = make(map[WebInterface]int)
+	v.routingBytes = make(map[WebInterface]int)
+	v.latency = NewLatency()
+	v.clientLatency = NewLatency()
+}
+
+// Director returns the director of the proxy, nil if it has none
+func (v *VarnishProxy) Director() Director {
+	return v.director
+}
+
+// Restart empties the cache, as a new proxy joining with a cold cache.
+// Metrics are kept and counted from now on, to show the cold start.
+func (v *VarnishProxy) Restart() error {
+	var storage Storage[string, int]
+	var err error
+	if pools, ok := v.cache.(*Pools); ok {
+		storage, err = pools.empty()
+	} else {
+		storage, err = NewStorage[string, int](v.cache.String(), v.cache.Size())
+	}
+	if err != nil {
+		return err
+	}
+	v.cache = storage
+	v.applyOverhead()
+	foresee(v.cache, v.future)
+	v.expiry = newExpiry()
+	v.inflight = newInflight()
+	v.markers = newMarkers(v.markers.kind, v.markers.ttl)
+	v.variants = newVariants()
+	if v.optimal != nil {
+		v.optimal.restart(v.overhead)
+	}
+	v.warmuped = true
+	return nil
 }
 
 func (v *VarnishProxy) SetDirector(d Director) *VarnishProxy {
@@ -155,41 +498,345 @@ func (v *VarnishProxy) SetBackend(w WebInterface) *VarnishProxy {
 	return v
 }
 
+// SetOptimal sets if the offline optimal storage of the same size is simulated beside the cache,
+// to measure the gap to optimal. Next uses of objects are known, when the future is set.
+func (v *VarnishProxy) SetOptimal(enabled bool) *VarnishProxy {
+	v.optimal = nil
+	if enabled {
+		v.optimal = newOptimal(v.cache.Size(), v.overhead, v.future)
+	}
+	return v
+}
+
+// SetFuture sets the future of the trace, storages of the optimal policy
+// and the offline optimal storage look next uses of objects up in it
+func (v *VarnishProxy) SetFuture(f *Future) *VarnishProxy {
+	v.future = f
+	foresee(v.cache, f)
+	if v.optimal != nil {
+		foresee(v.optimal.cache, f)
+	}
+	return v
+}
+
+// SetPools splits the cache into the main pool, that is the storage of the proxy,
+// and the pools, rules select the pool each object is stored in. No pools keep the cache whole.
+func (v *VarnishProxy) SetPools(pools []PoolConfig, rules []PoolRule) error {
+	if len(pools) == 0 && len(rules) == 0 {
+		return nil
+	}
+	main := v.cache
+	if p, ok := v.cache.(*Pools); ok {
+		main = p.storages[0]
+	}
+	p, err := NewPools(main, pools, rules)
+	if err != nil {
+		return err
+	}
+	v.cache = p
+	v.applyOverhead()
+	foresee(v.cache, v.future)
+	return nil
+}
+
+// PoolUsage returns usage of storage pools of the cache, nil if it is not split into pools
+func (v *VarnishProxy) PoolUsage() []PoolUsage {
+	if p, ok := v.cache.(*Pools); ok {
+		return p.Usage()
+	}
+	return nil
+}
+
+// SetOverhead sets a memory overhead of cached objects, storages charge their effective sizes
+func (v *VarnishProxy) SetOverhead(o Overhead) *VarnishProxy {
+	v.overhead = o
+	v.applyOverhead()
+	return v
+}
+
+// applyOverhead makes the storage of each pool and the optimal storage charge the overhead
+func (v *VarnishProxy) applyOverhead() {
+	if v.optimal != nil {
+		v.optimal.cache = withOverhead(v.optimal.cache, v.overhead)
+	}
+	if p, ok := v.cache.(*Pools); ok {
+		for i, storage := range p.storages {
+			p.storages[i] = withOverhead(storage, v.overhead)
+		}
+		return
+	}
+	v.cache = withOverhead(v.cache, v.overhead)
+}
+
+// SetAdmission sets the admission policy of the cache, nil admits all objects
+func (v *VarnishProxy) SetAdmission(a Admission) *VarnishProxy {
+	v.admission = a
+	return v
+}
+
+// SetLifetime sets a default lifetime of cached objects
+func (v *VarnishProxy) SetLifetime(l Lifetime) *VarnishProxy {
+	v.lifetime = l
+	return v
+}
+
+// SetRouter sets the router resolving sites of client requests
+func (v *VarnishProxy) SetRouter(r *Router) *VarnishProxy {
+	v.router = r
+	return v
+}
+
+// SetUplink sets the link to backends of the proxy
+func (v *VarnishProxy) SetUplink(l Link) *VarnishProxy {
+	v.uplink = l
+	return v
+}
+
+// SetClientLink sets the link to clients of the proxy
+func (v *VarnishProxy) SetClientLink(l Link) *VarnishProxy {
+	v.clientLink = l
+	return v
+}
+
+// SetCapacity sets the amount of workers of the proxy and the length of its queue
+func (v *VarnishProxy) SetCapacity(c Capacity) *VarnishProxy {
+	v.pool = newPool(c)
+	return v
+}
+
+// capacity returns the amount of workers of the proxy and the length of its queue
+func (v *VarnishProxy) capacity() Capacity {
+	if v.pool == nil {
+		return Capacity{}
+	}
+	return v.pool.capacity
+}
+
+// SetMarkers sets the kind and TTL of markers of uncacheable objects,
+// zero TTL creates no markers
+func (v *VarnishProxy) SetMarkers(kind string, ttl time.Duration) *VarnishProxy {
+	v.markers = newMarkers(kind, ttl)
+	return v
+}
+
 func (v *VarnishProxy) CacheSize() int {
 	return v.cache.Size()
 }
 
+// CacheEvictions returns eviction policies of the cache, one for each of its pools
+func (v *VarnishProxy) CacheEvictions() []string {
+	if p, ok := v.cache.(*Pools); ok {
+		return p.Policies()
+	}
+	return []string{v.cache.String()}
+}
+
 // String interface webInterface
 func (v *VarnishProxy) String() string {
 	return v.hostname
 }
 
+// Healthy returns if the proxy passes its health checks
+func (v *VarnishProxy) Healthy() bool {
+	return !v.down
+}
+
+// SetHealthy marks the proxy as healthy or down,
+// the cache of the proxy is kept while it is down
+func (v *VarnishProxy) SetHealthy(healthy bool) {
+	v.down = !healthy
+}
+
 // Get interface webInterface
-// req - request URI
-// size - object size in bytes
-func (v *VarnishProxy) Get(req string, size int) int {
+// req - request, holds URI and object size in bytes
+func (v *VarnishProxy) Get(req *Request) int {
+	// response times are measured only after warmup, as cache metrics are
+	warmuped := v.warmuped
+	bytes := v.serve(req)
+	if warmuped {
+		v.latency.Record(req.Duration)
+	}
+	if !req.Bereq {
+		// response travels to the client over the client link
+		req.Duration += v.clientLink.Transfer(bytes)
+		if warmuped {
+			v.clientLatency.Record(req.Duration)
+		}
+		v.clientRequests++
+		v.clientBytes += bytes
+		if req.Failed {
+			v.clientErrors++
+		}
+		if v.router != nil {
+			v.router.Resolve(req).client(bytes, req.Failed)
+		}
+	}
+	return bytes
+}
+
+// serve lets a worker deliver the request, the request waits in the queue
+// until a worker is free, or is rejected if the queue is full.
+// The worker is busy until the response is delivered, fetches included.
+func (v *VarnishProxy) serve(req *Request) int {
+	arrival := req.Timestamp
+	start, ok := v.pool.acquire(req.process, arrival)
+	if !ok {
+		req.Failed = true
+		req.Duration = 0
+		return 0
+	}
+
+	// the proxy and its backends see the request, when the worker starts serving it
+	req.Timestamp = start
+	bytes := v.deliver(req)
+	v.pool.release(req.process, start, req.Duration)
+	req.Timestamp = arrival
+	req.Duration += start.Sub(arrival)
+	return bytes
+}
+
+// deliver looks the object up in the cache, fetching it if needed,
+// returns the size of the object delivered
+func (v *VarnishProxy) deliver(req *Request) int {
+	// remove objects that are out of their keep, as expiry thread would do
+	v.expire(req.Timestamp)
+
+	// metrics are counted only after warmup, fetch may finish it
+	warmuped := v.warmuped
+	req.Duration = 0
+	// variants of the object are stored under their own keys
+	key := req.CacheKey()
+	if len(req.Vary) > 0 {
+		v.varied = true
+	}
+	seek(v.cache, req.Seq)
+	if v.optimal != nil {
+		v.optimal.lookup(req, key, warmuped)
+	}
+	if v.admission != nil {
+		v.admission.record(key)
+	}
+
+	// uncacheable object is marked, request goes to the backend without waiting
+	if v.markers.marked(key, req.Timestamp) {
+		pass := v.markers.pass()
+		f := v.fetch(req, false, pass)
+		req.Failed = !f.ok
+		req.Duration = f.duration
+		if warmuped {
+			if pass {
+				v.cacheMetric.HitForPass(f.size)
+			} else {
+				v.cacheMetric.HitForMiss(f.size)
+			}
+		}
+		return f.size
+	}
+
+	// object is being fetched, it is not delivered from the cache until the fetch ends
+	if busy, ok := v.inflight.busy(key, req.Timestamp); ok {
+		if busy.background {
+			// stale object is delivered from the cache, while background fetch is in progress
+			v.cache.Get(key)
+			if warmuped {
+				v.cacheMetric.StaleHit(busy.size)
+			}
+			return busy.size
+		}
+
+		// request waits on the waiting list and is coalesced into the fetch
+		if busy.pending {
+			v.inflight.wait(busy, req.process)
+			if busy.failed {
+				// nothing to deliver, the request looks the object up again
+				return v.retry(req, busy.done)
+			}
+		}
+		// waiting requests look the object up, when the fetch ends
+		v.cache.Get(key)
+		if warmuped {
+			v.coalesced++
+			if busy.origin {
+				v.originFetchesSaved++
+			}
+			v.cacheMetric.Coalesced(busy.size)
+		}
+		req.Duration = busy.done.Sub(req.Timestamp)
+		return busy.size
+	}
+
 	// callback OnRequest
 	// try to get from Cache
-	obj, ok := v.cache.Get(req)
+	obj, ok := v.cache.Get(key)
 	if ok {
-		if v.warmuped {
-			v.cacheMetric.Hit()
+		switch v.expiry.state(key, req.Timestamp) {
+		case objectFresh:
+			if warmuped {
+				v.cacheMetric.Hit(obj)
+			}
+			return obj
+		case objectGrace:
+			if warmuped {
+				v.cacheMetric.StaleHit(obj)
+			}
+			// stale object is delivered, while background fetch refreshes it,
+			// even if the fetch fails
+			busy := v.inflight.begin(key, obj, true)
+			f := v.fetch(req, false, false)
+			v.inflight.end(key, busy, req.Timestamp, f, obj)
+			return obj
+		case objectKeep:
+			// object kept is used to make a conditional request
+			busy := v.inflight.begin(key, 0, false)
+			f := v.fetch(req, true, false)
+			v.inflight.end(key, busy, req.Timestamp, f, f.size)
+			req.Failed = !f.ok
+			req.Duration = f.duration
+			if warmuped {
+				v.cacheMetric.Revalidation(f.size)
+			}
+			return f.size
 		}
+	}
 
-		return obj
-	} else {
-		if v.warmuped {
-			v.cacheMetric.Miss()
-		}
+	if warmuped && len(req.Vary) > 0 && v.variants.cachedOther(req.HashKey(), key, v.cache) {
+		v.cacheMetric.VariantMiss()
 	}
+	busy := v.inflight.begin(key, 0, false)
+	f := v.fetch(req, false, false)
+	v.inflight.end(key, busy, req.Timestamp, f, f.size)
+	req.Failed = !f.ok
+	req.Duration = f.duration
+	if warmuped {
+		v.cacheMetric.Miss(f.size)
+	}
+
+	return f.size
+}
+
+// retry delivers the request again at the time, after the fetch it waited for
+// has not brought a cacheable object
+func (v *VarnishProxy) retry(req *Request, at time.Time) int {
+	arrival := req.Timestamp
+	req.Timestamp = at
+	bytes := v.deliver(req)
+	req.Timestamp = arrival
+	req.Duration += at.Sub(arrival)
+	return bytes
+}
 
+// fetch gets the object from the backend and stores it in the cache
+// conditional - if the object is revalidated, instead of being fetched completely
+// pass - if the response is passed to the client without being cached
+func (v *VarnishProxy) fetch(req *Request, conditional, pass bool) fetched {
 	// if got a cache miss, we have to get the object from the backend
 	// and store it in the cache
 	// if the VarnishProxy has a director, we get the backend from the director
 	// analogue to `director.backend(req)`
+	var backend WebInterface
 	if v.director != nil {
 		// director based on its internal logic selects a backend
-		backend := v.director.GetBackend(req)
+		backend = PickBackend(v.director, req)
 
 		// if director returning this instance, we may have a case
 		// when we have a shard director and hash-ring tells us that we are
@@ -198,33 +845,104 @@ func (v *VarnishProxy) Get(req string, size int) int {
 			// set original backend, as we can not send request to ourselves
 			backend = v.backend
 		}
+	} else {
+		backend = v.backend
+	}
 
-		v.routingMetric[backend]++
-
-		artifactSize := backend.Get(req, size)
+	if backend == nil || !IsHealthy(backend) {
+		// director has no healthy backend, or the backend is down
+		v.fetchFailures++
+		return fetched{}
+	}
 
-		// cache the result
-		isNuked := v.cache.Store(req, artifactSize)
-		if isNuked {
-			v.warmuped = true
+	key := req.CacheKey()
+	bereq := *req
+	bereq.Conditional = conditional
+	bereq.Bereq = true
+	artifactSize := backend.Get(&bereq)
+
+	v.routingMetric[backend]++
+	if bereq.Failed {
+		// errors are not cached
+		v.fetchFailures++
+		return fetched{duration: bereq.Duration + v.uplink.Transfer(0)}
+	}
+	body := 0
+	if !conditional {
+		// conditional request is answered without a body
+		body = artifactSize
+		v.routingBytes[backend] += artifactSize
+	}
+	// response of the backend travels over the uplink
+	duration := bereq.Duration + v.uplink.Transfer(body)
+
+	origin := isOrigin(backend)
+	if pass || bereq.Uncacheable {
+		if !pass {
+			// marker replaces the object, as Varnish does for uncacheable responses
+			v.cache.Remove(key)
+			v.expiry.remove(key)
+			v.markers.mark(key, req.Timestamp)
 		}
+		return fetched{size: artifactSize, ok: true, duration: duration, origin: origin, uncacheable: true}
+	}
+	v.markers.remove(key)
 
-		return artifactSize
+	lifetime, limited := v.lifetimeOf(req)
+	if pools, ok := v.cache.(*Pools); ok {
+		pools.choose(req, lifetime, limited, artifactSize)
 	}
 
-	if v.backend != nil {
-		artifactSize := v.backend.Get(req, size)
+	// admission policy may keep a new object out of the cache, objects cached are refreshed
+	if _, cached := v.cache.Peek(key); !cached && v.admission != nil && !v.admission.admit(key, artifactSize, v.cache) {
+		v.expiry.remove(key)
+		return fetched{size: artifactSize, ok: true, duration: duration, origin: origin}
+	}
 
-		// cache the result
-		isNuked := v.cache.Store(req, artifactSize)
-		if isNuked {
-			v.warmuped = true
-		}
+	// cache the result
+	isNuked := v.cache.Store(key, artifactSize)
+	if isNuked {
+		v.warmuped = true
+	}
+	if len(req.Vary) > 0 {
+		v.variants.add(req.HashKey(), key, v.cache)
+	}
 
-		return artifactSize
+	if limited {
+		v.expiry.set(key, req.Timestamp, lifetime)
+	} else {
+		v.expiry.remove(key)
 	}
 
-	return 0
+	return fetched{size: artifactSize, ok: true, duration: duration, origin: origin}
+}
+
+// isOrigin returns if the web interface is an origin or routes to origins
+func isOrigin(w WebInterface) bool {
+	switch w.(type) {
+	case *Backend, *Router:
+		return true
+	}
+	return false
+}
+
+// lifetimeOf returns lifetime of the requested object and if it expires at all
+func (v *VarnishProxy) lifetimeOf(req *Request) (Lifetime, bool) {
+	if req.TTL == UnsetDuration && v.lifetime.TTL <= 0 {
+		// no TTL is known, object is kept until nuked
+		return Lifetime{}, false
+	}
+	return v.lifetime.Override(req), true
+}
+
+// expire removes from the cache objects that are expired at the time
+// as lifetime limits the cache as well as its size, the first expired
+// object also marks the VarnishProxy as warmed up.
+func (v *VarnishProxy) expire(now time.Time) {
+	for _, key := range v.expiry.expired(now) {
+		v.cache.Remove(key)
+		v.warmuped = true
+	}
 }
 
 func (v *VarnishProxy) PrintResult() {
@@ -0,0 +1,355 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func newRequestAt(url string, seconds int64) *Request {
+	req := NewRequest(url, 10)
+	req.Timestamp = time.Unix(seconds, 0)
+	return req
+}
+
+func TestLifetime(t *testing.T) {
+	backend := &Backend{Hostname: "default"}
+	proxy, err := NewVarnishProxy("proxy", 100, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(backend).SetLifetime(Lifetime{TTL: 10 * time.Second, Grace: 5 * time.Second, Keep: 5 * time.Second})
+	proxy.warmuped = true
+
+	steps := []struct {
+		at            int64
+		requests      int
+		revalidations int
+	}{
+		{0, 1, 0},  // miss
+		{5, 1, 0},  // fresh hit
+		{12, 2, 0}, // stale hit, background fetch refreshes the object
+		{20, 2, 0}, // fresh hit of the refreshed object
+		{29, 3, 1}, // keep, conditional fetch
+		{60, 4, 1}, // expired and removed, miss
+	}
+
+	for _, step := range steps {
+		proxy.Get(newRequestAt("/obj", step.at))
+		if backend.requests != step.requests || backend.revalidations != step.revalidations {
+			t.Fatalf("error: at %ds backend got %d requests, %d revalidations", step.at, backend.requests, backend.revalidations)
+		}
+	}
+
+	metric := proxy.cacheMetric.ExportType()
+	if metric["hit"] != 2 || metric["stale_hit"] != 1 || metric["miss"] != 3 || metric["revalidation"] != 1 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+}
+
+func TestLifetimeFromRequest(t *testing.T) {
+	backend := &Backend{Hostname: "default"}
+	proxy, err := NewVarnishProxy("proxy", 100, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(backend)
+
+	// without TTL objects are kept forever
+	proxy.Get(newRequestAt("/forever", 0))
+	proxy.Get(newRequestAt("/forever", 1000))
+
+	req := newRequestAt("/short", 0)
+	req.TTL = time.Second
+	proxy.Get(req)
+	req = newRequestAt("/short", 2)
+	req.TTL = time.Second
+	proxy.Get(req)
+
+	if backend.requests != 3 {
+		t.Fatalf("error: backend got %d requests instead of 3", backend.requests)
+	}
+}
+
+func TestByteMetrics(t *testing.T) {
+	origin := &Backend{Hostname: "origin"}
+	shield, err := NewVarnishProxy("shield", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	shield.SetBackend(origin)
+	edge, err := NewVarnishProxy("edge", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	edge.SetBackend(shield)
+	edge.warmuped = true
+
+	for _, url := range []string{"/a", "/b", "/a", "/a"} {
+		req := NewRequest(url, 100)
+		if url == "/b" {
+			req.Size = 300
+		}
+		edge.Get(req)
+	}
+
+	metric := edge.cacheMetric.ExportType()
+	if metric["hit_bytes"] != 200 || metric["miss_bytes"] != 400 || metric["byte_hit_ratio"] != 200.0/600 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+	if edge.routingBytes[shield] != 400 || edge.routingMetric[shield] != 2 {
+		t.Fatalf("error: edge fetched %d requests, %d bytes from shield", edge.routingMetric[shield], edge.routingBytes[shield])
+	}
+
+	offload := NewOriginOffload([]*VarnishProxy{edge, shield}, []*Backend{origin})
+	if offload.Requests != 4 || offload.Bytes != 600 || offload.OriginRequests != 2 || offload.OriginBytes != 400 {
+		t.Fatalf("error: unexpected offload %+v", offload)
+	}
+	if offload.RequestOffload() != 0.5 {
+		t.Fatalf("error: request offload %f instead of 0.5", offload.RequestOffload())
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin)
+	proxy.warmuped = true
+
+	// flash crowd arrives while the first miss is being fetched
+	start := time.Unix(0, 0)
+	for i := 0; i < 10; i++ {
+		req := NewRequest("/hot", 10)
+		req.Timestamp = start.Add(time.Duration(i) * 50 * time.Millisecond)
+		proxy.Get(req)
+		if want := time.Second - time.Duration(i)*50*time.Millisecond; req.Duration != want {
+			t.Fatalf("error: request %d waited %s instead of %s", i, req.Duration, want)
+		}
+	}
+	proxy.Get(newRequestAt("/hot", 2))
+
+	if origin.requests != 1 {
+		t.Fatalf("error: origin got %d requests instead of 1", origin.requests)
+	}
+	metric := proxy.cacheMetric.ExportType()
+	if metric["miss"] != 1 || metric["coalesced"] != 9 || metric["hit"] != 1 || metric["coalesced_bytes"] != 90 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+	offload := NewOriginOffload([]*VarnishProxy{proxy}, []*Backend{origin})
+	if offload.Coalesced != 9 || offload.OriginFetchesSaved != 9 {
+		t.Fatalf("error: unexpected offload %+v", offload)
+	}
+}
+
+func TestCoalescingWarmup(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin)
+
+	// requests coalesced during warmup are not counted
+	for i := 0; i < 3; i++ {
+		req := NewRequest("/hot", 10)
+		req.Timestamp = time.Unix(0, 0).Add(time.Duration(i) * 50 * time.Millisecond)
+		proxy.Get(req)
+	}
+	offload := NewOriginOffload([]*VarnishProxy{proxy}, []*Backend{origin})
+	if proxy.warmuped || offload.Coalesced != 0 || offload.OriginFetchesSaved != 0 {
+		t.Fatalf("error: unexpected offload during warmup %+v", offload)
+	}
+}
+
+func TestCoalescingGrace(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(2 * time.Second)}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin).SetLifetime(Lifetime{TTL: 10 * time.Second, Grace: 10 * time.Second})
+	proxy.warmuped = true
+
+	proxy.Get(newRequestAt("/obj", 0))
+	// object in grace is refreshed in background, requests during it get the stale object
+	for _, at := range []int64{12, 13} {
+		req := newRequestAt("/obj", at)
+		proxy.Get(req)
+		if req.Duration != 0 {
+			t.Fatalf("error: request at %ds waited %s for a background fetch", at, req.Duration)
+		}
+	}
+
+	if origin.requests != 2 {
+		t.Fatalf("error: origin got %d requests instead of 2", origin.requests)
+	}
+	metric := proxy.cacheMetric.ExportType()
+	if metric["stale_hit"] != 2 || metric["coalesced"] != 0 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+}
+
+func TestHitForMiss(t *testing.T) {
+	origin := &Backend{Hostname: "origin"}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin).SetMarkers(HitForMiss, 10*time.Second)
+	proxy.warmuped = true
+
+	steps := []struct {
+		at          int64
+		uncacheable bool
+		requests    int
+	}{
+		{0, true, 1},   // miss creates the marker
+		{1, true, 2},   // hit-for-miss, marker is refreshed
+		{5, false, 3},  // hit-for-miss, cacheable response replaces the marker
+		{6, false, 3},  // hit
+		{30, true, 3},  // hit, cached object is not refetched
+		{31, false, 3}, // hit
+	}
+	for _, step := range steps {
+		req := newRequestAt("/obj", step.at)
+		req.Uncacheable = step.uncacheable
+		proxy.Get(req)
+		if origin.requests != step.requests {
+			t.Fatalf("error: at %ds origin got %d requests instead of %d", step.at, origin.requests, step.requests)
+		}
+	}
+
+	metric := proxy.cacheMetric.ExportType()
+	if metric["miss"] != 1 || metric["hit_for_miss"] != 2 || metric["hit"] != 3 || metric["pass_bytes"] != 20 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+	if metric["hit_ratio"] != 0.5 {
+		t.Fatalf("error: passed requests should lower hit ratio, got %f", metric["hit_ratio"])
+	}
+}
+
+func TestHitForPass(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(time.Second)}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin).SetMarkers(HitForPass, 10*time.Second)
+	proxy.warmuped = true
+
+	req := newRequestAt("/obj", 0)
+	req.Uncacheable = true
+	proxy.Get(req)
+	// cacheable responses are passed until the marker expires,
+	// requests do not wait for each other
+	for _, at := range []int64{0, 5, 9, 10, 11} {
+		proxy.Get(newRequestAt("/obj", at))
+	}
+
+	if origin.requests != 5 {
+		t.Fatalf("error: origin got %d requests instead of 5", origin.requests)
+	}
+	metric := proxy.cacheMetric.ExportType()
+	if metric["miss"] != 2 || metric["hit_for_pass"] != 3 || metric["coalesced"] != 0 || metric["hit"] != 1 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	origin := &Backend{Hostname: "origin"}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(origin)
+	proxy.warmuped = true
+
+	variant := func(url, encoding string, size int) *Request {
+		req := NewRequest(url, size)
+		req.SetHeader("Accept-Encoding", encoding)
+		req.SetVary("Accept-Encoding")
+		return req
+	}
+	proxy.Get(variant("/a", "gzip", 100))
+	proxy.Get(variant("/a", "br", 80))
+	proxy.Get(variant("/a", "gzip", 100))
+	proxy.Get(variant("/a", "identity", 300))
+	proxy.Get(variant("/b", "gzip", 50))
+
+	if origin.requests != 4 {
+		t.Fatalf("error: origin got %d requests instead of 4", origin.requests)
+	}
+	if proxy.cache.Stored() != 530 {
+		t.Fatalf("error: variants take %d bytes instead of 530", proxy.cache.Stored())
+	}
+
+	variants, bytes := proxy.variants.usage(proxy.cache)
+	if variants != 2 || bytes != 180 {
+		t.Fatalf("error: %d variants take %d bytes besides the largest ones", variants, bytes)
+	}
+	metric := proxy.cacheMetric.ExportType()
+	if metric["variant_miss"] != 2 || metric["variant_hit_ratio_cost"] != 0.4 {
+		t.Fatalf("error: unexpected cache metric %v", metric)
+	}
+
+	// evicted variants are not counted
+	proxy.Get(NewRequest("/c", 900))
+	if variants, bytes := proxy.variants.usage(proxy.cache); variants != 0 || bytes != 0 {
+		t.Fatalf("error: %d evicted variants take %d bytes", variants, bytes)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	origin := &Backend{Hostname: "origin", ServiceTime: ConstantTime(100 * time.Millisecond)}
+	shield, err := NewVarnishProxy("shield", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	edge, err := NewVarnishProxy("edge", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	shield.SetBackend(origin).SetUplink(Link{RTT: 20 * time.Millisecond, Bandwidth: 100})
+	edge.SetBackend(shield).SetUplink(Link{RTT: 10 * time.Millisecond})
+	edge.SetClientLink(Link{RTT: 5 * time.Millisecond})
+	edge.warmuped, shield.warmuped = true, true
+
+	// miss travels to the origin and back, 10 bytes take 100ms over the shield uplink
+	miss := newRequestAt("/obj", 0)
+	edge.Get(miss)
+	if miss.Duration != 235*time.Millisecond {
+		t.Fatalf("error: miss took %s instead of 235ms", miss.Duration)
+	}
+	hit := newRequestAt("/obj", 1)
+	edge.Get(hit)
+	if hit.Duration != 5*time.Millisecond {
+		t.Fatalf("error: hit took %s instead of 5ms", hit.Duration)
+	}
+
+	report := NewLatencyReport([]*VarnishProxy{edge}).AddLayer("edge", []*VarnishProxy{edge}).AddLayer("shield", []*VarnishProxy{shield})
+	if !report.Measured() {
+		t.Fatalf("error: latency should be measured")
+	}
+	within := func(got, want time.Duration) bool {
+		return got >= want && float64(got-want) <= 0.01*float64(want)
+	}
+	if got := report.layers[1].Quantile(0.5); !within(got, 220*time.Millisecond) {
+		t.Fatalf("error: shield p50 is %s instead of 220ms", got)
+	}
+	if got := report.layers[0].Quantile(0.5); got != 0 {
+		t.Fatalf("error: edge p50 is %s instead of a hit", got)
+	}
+	if got := report.total.Quantile(0.99); !within(got, 235*time.Millisecond) {
+		t.Fatalf("error: total p99 is %s instead of 235ms", got)
+	}
+}
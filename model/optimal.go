@@ -0,0 +1,87 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+// optimal is the offline optimal storage of the same size as the cache of a proxy,
+// that sees the same requests. Its hit ratio is an upper bound of the one of the cache,
+// the difference between them is the gap to optimal. Its future is the one of the whole trace,
+// so the bound is approximate for proxies, that see a part of requests of their objects,
+// future accuracy tells how close it is.
+type optimal struct {
+	// cache is the optimal storage charging the overhead of the cache of the proxy
+	cache   Storage[string, int]
+	storage *OptimalStorage[string, int]
+
+	// requests and bytes counted after warmup, the same way cache metrics are
+	hits     int
+	requests int
+	hitBytes int
+	bytes    int
+}
+
+func newOptimal(size int, overhead Overhead, future *Future) *optimal {
+	o := &optimal{}
+	o.reset(size, overhead, future)
+	return o
+}
+
+// reset replaces the storage with an empty one of the size charging the overhead
+func (o *optimal) reset(size int, overhead Overhead, future *Future) {
+	o.storage = NewOptimalStorage[string, int](size, future)
+	o.cache = withOverhead(o.storage, overhead)
+}
+
+// lookup looks the object of the request up, storing it on a miss.
+// Uncacheable objects are never hits. Only counted lookups are measured.
+func (o *optimal) lookup(req *Request, key string, counted bool) {
+	seek(o.cache, req.Seq)
+	hit := false
+	if !req.Uncacheable {
+		if _, ok := o.cache.Get(key); ok {
+			hit = true
+		} else {
+			o.cache.Store(key, req.Size)
+		}
+	}
+	if !counted {
+		return
+	}
+	o.requests++
+	o.bytes += req.Size
+	if hit {
+		o.hits++
+		o.hitBytes += req.Size
+	}
+}
+
+// restart empties the storage, as the cache of the proxy is emptied
+func (o *optimal) restart(overhead Overhead) {
+	o.reset(o.cache.Size(), overhead, o.storage.future)
+}
+
+// CHR returns cache hit ratio of the optimal storage
+func (o *optimal) CHR() float64 {
+	return ratio(o.hits, o.requests)
+}
+
+// BHR returns byte hit ratio of the optimal storage
+func (o *optimal) BHR() float64 {
+	return ratio(o.hitBytes, o.bytes)
+}
+
+// export returns hit ratios of the optimal storage and gaps of the cache metric to them
+func (o *optimal) export(m *CacheMetric) map[string]float64 {
+	return map[string]float64{
+		"hit_ratio":          o.CHR(),
+		"byte_hit_ratio":     o.BHR(),
+		"hit_ratio_gap":      o.CHR() - m.CHR(),
+		"byte_hit_ratio_gap": o.BHR() - m.BHR(),
+		"future_accuracy":    o.storage.Accuracy(),
+	}
+}
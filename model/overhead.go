@@ -0,0 +1,190 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// allocationQuantum is the smallest allocation, sizes are rounded up to its multiple
+const allocationQuantum = 8
+
+// Overhead is a memory overhead of objects cached by Varnish: metadata of each object
+// (about 1KB) and rounding of allocations up to size classes of the allocator.
+// Storages charge effective sizes of objects, as SMA counters of varnishstat do,
+// while nominal sizes are the ones of their bodies.
+type Overhead struct {
+	// PerObject is an amount of bytes of metadata of each object
+	PerObject int
+	// SizeClasses is an amount of size classes per doubling of size, allocations are rounded up to.
+	// jemalloc has 4 of them, 0 rounds only to the quantum of 8 bytes.
+	SizeClasses int
+}
+
+// Validate checks that the overhead is not negative
+func (o Overhead) Validate() error {
+	if o.PerObject < 0 || o.SizeClasses < 0 {
+		return fmt.Errorf("overhead per object and size classes must not be negative")
+	}
+	return nil
+}
+
+// Enabled returns if objects cost more than their bodies
+func (o Overhead) Enabled() bool {
+	return o.PerObject > 0 || o.SizeClasses > 0
+}
+
+// Effective returns bytes the object of the size takes in the storage
+func (o Overhead) Effective(size int) int {
+	return o.PerObject + o.allocation(size)
+}
+
+// allocation returns the size rounded up to its size class, classes of sizes between
+// two powers of two are spaced evenly, as the ones of jemalloc
+func (o Overhead) allocation(size int) int {
+	step := allocationQuantum
+	if o.SizeClasses > 0 && size > allocationQuantum {
+		// size classes of (2^n, 2^(n+1)] are spaced by 2^n / classes
+		if spacing := (1 << (bits.Len(uint(size-1)) - 1)) / o.SizeClasses; spacing > step {
+			step = spacing
+		}
+	}
+	return (size + step - 1) / step * step
+}
+
+func (o Overhead) String() string {
+	return fmt.Sprintf("%dB/object, %d size classes", o.PerObject, o.SizeClasses)
+}
+
+// overheadStorage is a storage charging effective sizes of objects,
+// while it returns their nominal sizes
+type overheadStorage struct {
+	storage  Storage[string, int]
+	overhead Overhead
+
+	// nominal holds nominal sizes of objects, the ones nuked by the storage are pruned
+	nominal map[string]int
+	pruning lazyPrune
+}
+
+func newOverheadStorage(storage Storage[string, int], overhead Overhead) *overheadStorage {
+	return &overheadStorage{
+		storage:  storage,
+		overhead: overhead,
+		nominal:  make(map[string]int),
+	}
+}
+
+func (s *overheadStorage) Size() int {
+	return s.storage.Size()
+}
+
+// Stored returns effective bytes of stored objects
+func (s *overheadStorage) Stored() int {
+	return s.storage.Stored()
+}
+
+// Nominal returns nominal bytes of stored objects
+func (s *overheadStorage) Nominal() int {
+	s.prune()
+	nominal := 0
+	for _, size := range s.nominal {
+		nominal += size
+	}
+	return nominal
+}
+
+// String returns the name of the eviction policy
+func (s *overheadStorage) String() string {
+	return s.storage.String()
+}
+
+func (s *overheadStorage) Store(k string, v int) bool {
+	nuked := s.storage.Store(k, s.overhead.Effective(v))
+	if _, ok := s.storage.Peek(k); ok {
+		s.nominal[k] = v
+	} else {
+		delete(s.nominal, k)
+	}
+	if s.pruning.due(len(s.nominal)) {
+		s.prune()
+	}
+	return nuked
+}
+
+func (s *overheadStorage) Get(k string) (int, bool) {
+	if _, ok := s.storage.Get(k); !ok {
+		return 0, false
+	}
+	return s.nominal[k], true
+}
+
+func (s *overheadStorage) Peek(k string) (int, bool) {
+	if _, ok := s.storage.Peek(k); !ok {
+		return 0, false
+	}
+	return s.nominal[k], true
+}
+
+func (s *overheadStorage) Remove(k string) bool {
+	delete(s.nominal, k)
+	return s.storage.Remove(k)
+}
+
+// prune forgets nominal sizes of objects nuked by the storage
+func (s *overheadStorage) prune() {
+	for key := range s.nominal {
+		if _, ok := s.storage.Peek(key); !ok {
+			delete(s.nominal, key)
+		}
+	}
+	s.pruning.pruned(len(s.nominal))
+}
+
+func (s *overheadStorage) seek(seq int) {
+	seek(s.storage, seq)
+}
+
+func (s *overheadStorage) foresee(f *Future) {
+	foresee(s.storage, f)
+}
+
+// victim returns the object the storage evicts next
+func (s *overheadStorage) victim() (string, bool) {
+	if e, ok := s.storage.(evicting[string]); ok {
+		return e.victim()
+	}
+	return "", false
+}
+
+// withOverhead returns the storage charging the overhead, the storage itself if there is none
+func withOverhead(storage Storage[string, int], overhead Overhead) Storage[string, int] {
+	if s, ok := storage.(*overheadStorage); ok {
+		if s.overhead == overhead {
+			return s
+		}
+		storage = s.storage
+	}
+	if !overhead.Enabled() {
+		return storage
+	}
+	return newOverheadStorage(storage, overhead)
+}
+
+// nominalOf returns nominal bytes stored by the storage
+func nominalOf(storage Storage[string, int]) int {
+	switch s := storage.(type) {
+	case *overheadStorage:
+		return s.Nominal()
+	case *Pools:
+		return s.Nominal()
+	}
+	return storage.Stored()
+}
@@ -0,0 +1,98 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverheadEffective(t *testing.T) {
+	cases := []struct {
+		overhead Overhead
+		size     int
+		expected int
+	}{
+		{Overhead{}, 5, 8},
+		{Overhead{}, 100, 104},
+		{Overhead{SizeClasses: 4}, 5, 8},
+		{Overhead{SizeClasses: 4}, 100, 112},
+		{Overhead{SizeClasses: 4}, 128, 128},
+		{Overhead{SizeClasses: 4}, 129, 160},
+		{Overhead{PerObject: 1024, SizeClasses: 4}, 1000, 2048},
+	}
+	for _, c := range cases {
+		if effective := c.overhead.Effective(c.size); effective != c.expected {
+			t.Fatalf("error: %v charges %d bytes for %d instead of %d", c.overhead, effective, c.size, c.expected)
+		}
+	}
+	if (Overhead{PerObject: -1}).Validate() == nil {
+		t.Fatalf("error: negative overhead should be invalid")
+	}
+}
+
+func TestProxyOverhead(t *testing.T) {
+	proxy, err := NewVarnishProxy("proxy", 3000, LRUPolicy)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(&Backend{Hostname: "default"}).SetLifetime(Lifetime{TTL: time.Hour})
+	proxy.SetOverhead(Overhead{PerObject: 1000})
+
+	for _, url := range []string{"/a", "/b", "/c"} {
+		proxy.Get(NewRequest(url, 100))
+	}
+	if proxy.cache.Stored() != 2208 || nominalOf(proxy.cache) != 200 {
+		t.Fatalf("error: cache uses %d bytes, %d nominal", proxy.cache.Stored(), nominalOf(proxy.cache))
+	}
+	if _, ok := proxy.cache.Peek("/a"); ok {
+		t.Fatalf("error: the overhead should nuke the first object")
+	}
+	if size, ok := proxy.cache.Get("/c"); !ok || size != 100 {
+		t.Fatalf("error: nominal size %d of a cached object instead of 100", size)
+	}
+
+	export := proxy.Export()["proxy"].(map[string]interface{})
+	if export["cache_used"] != 2208 || export["cache_used_nominal"] != 200 {
+		t.Fatalf("error: unexpected usage %v, %v", export["cache_used"], export["cache_used_nominal"])
+	}
+
+	if err := proxy.Restart(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.Get(NewRequest("/d", 100))
+	if proxy.cache.Stored() != 1104 {
+		t.Fatalf("error: restart should keep the overhead")
+	}
+}
+
+func TestOptimalOverhead(t *testing.T) {
+	proxy, err := NewVarnishProxy("proxy", 3000, LRUPolicy)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(&Backend{Hostname: "default"}).SetOptimal(true)
+	proxy.SetOverhead(Overhead{PerObject: 1000})
+
+	for _, url := range []string{"/a", "/b", "/c"} {
+		proxy.Get(NewRequest(url, 100))
+	}
+	// the optimal storage charges the overhead as the cache does
+	if proxy.optimal.cache.Stored() != proxy.cache.Stored() || nominalOf(proxy.optimal.cache) != 200 {
+		t.Fatalf("error: optimal storage uses %d bytes, cache %d", proxy.optimal.cache.Stored(), proxy.cache.Stored())
+	}
+
+	if err := proxy.Restart(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.Get(NewRequest("/d", 100))
+	if proxy.optimal.cache.Stored() != 1104 {
+		t.Fatalf("error: restart should keep the overhead of the optimal storage")
+	}
+}
@@ -0,0 +1,235 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"math"
+)
+
+// remapSample is an amount of keys sampled by shard directors, placement of keys
+// and their remapping on removal of backends are measured on
+const remapSample = 10000
+
+// ShardPlacement is a placement of keys requested from shard directors of a layer
+// on their backends by the hashing scheme. It shows how balanced keys and bytes
+// are, and how many keys of other backends are remapped, when a backend is removed.
+// Keys are a uniform sample of up to remapSample keys requested.
+type ShardPlacement struct {
+	hashing  string
+	backends []WebInterface
+	weights  []float64
+
+	keys       []int
+	bytes      []int
+	totalKeys  int
+	totalBytes int
+
+	// remapped is an average share of keys of other backends,
+	// that are remapped on removal of a backend
+	remapped float64
+}
+
+// NewShardPlacement places keys requested from the directors, that share their backends
+// and hashing scheme, by the hashing of the first one
+func NewShardPlacement(directors ...*ShardDirector) *ShardPlacement {
+	p := &ShardPlacement{}
+	if len(directors) == 0 {
+		return p
+	}
+	first := directors[0]
+	p.hashing = first.hashing.String()
+	p.backends = append(p.backends, first.backends...)
+	p.weights = append(p.weights, first.weights...)
+	p.keys = make([]int, len(p.backends))
+	p.bytes = make([]int, len(p.backends))
+	if len(p.backends) == 0 {
+		return p
+	}
+
+	index := make(map[WebInterface]int)
+	for i, backend := range p.backends {
+		index[backend] = i
+	}
+
+	sample := NewKeySample(remapSample)
+	for _, director := range directors {
+		for _, key := range director.sample.Keys() {
+			sample.Add(key, director.sample.Size(key))
+		}
+	}
+
+	keys := sample.Keys()
+	for _, key := range keys {
+		owner := index[first.hashing.Locate(key, 1)[0]]
+		p.keys[owner]++
+		p.bytes[owner] += sample.Size(key)
+		p.totalKeys++
+		p.totalBytes += sample.Size(key)
+	}
+
+	p.remapped = p.remapping(first, keys)
+	return p
+}
+
+// remapping returns an average share of keys of other backends remapped on removal
+// of a backend, hashing without the backend is built from scratch in order of the rest
+func (p *ShardPlacement) remapping(director *ShardDirector, keys []string) float64 {
+	if len(p.backends) < 2 || len(keys) == 0 {
+		return 0
+	}
+
+	owners := make([]WebInterface, len(keys))
+	for i, key := range keys {
+		owners[i] = director.hashing.Locate(key, 1)[0]
+	}
+
+	total := 0.0
+	for removed := range p.backends {
+		// hashing is valid, as the director was built with it
+		hashing, _ := NewHashing(director.params.Hashing, director.params.Replicas)
+		for i, backend := range p.backends {
+			if i != removed {
+				hashing.Add(backend, p.weights[i])
+			}
+		}
+
+		kept, remapped := 0, 0
+		for i, key := range keys {
+			if owners[i] == p.backends[removed] {
+				continue
+			}
+			kept++
+			if hashing.Locate(key, 1)[0] != owners[i] {
+				remapped++
+			}
+		}
+		if kept > 0 {
+			total += float64(remapped) / float64(kept)
+		}
+	}
+	return total / float64(len(p.backends))
+}
+
+// Measured returns if any key was placed
+func (p *ShardPlacement) Measured() bool {
+	return p.totalKeys > 0
+}
+
+// loads returns counts per unit of weight of the backends
+func (p *ShardPlacement) loads(counts []int) []float64 {
+	loads := make([]float64, len(counts))
+	for i, count := range counts {
+		loads[i] = float64(count) / float64(units(p.weights[i]))
+	}
+	return loads
+}
+
+// KeysCV returns the coefficient of variation of keys per unit of weight of the backends
+func (p *ShardPlacement) KeysCV() float64 {
+	return CoefficientOfVariation(p.loads(p.keys))
+}
+
+// BytesCV returns the coefficient of variation of bytes per unit of weight of the backends
+func (p *ShardPlacement) BytesCV() float64 {
+	return CoefficientOfVariation(p.loads(p.bytes))
+}
+
+// Remapped returns an average share of keys of other backends remapped on removal of a backend,
+// 0 is the minimal disruption, only keys of the removed backend move
+func (p *ShardPlacement) Remapped() float64 {
+	return p.remapped
+}
+
+// CoefficientOfVariation returns the standard deviation of the values divided by their mean,
+// 0 if the mean is 0
+func CoefficientOfVariation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance/float64(len(values))) / mean
+}
+
+// maxToMean returns the maximum of the values divided by their mean, 0 if the mean is 0
+func maxToMean(values []float64) float64 {
+	max, sum := 0.0, 0.0
+	for _, v := range values {
+		sum += v
+		if v > max {
+			max = v
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return max / (sum / float64(len(values)))
+}
+
+// share returns a part of the total, 0 if the total is 0
+func share(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+func (p *ShardPlacement) TableData() (name string, rows [][]string) {
+	name = "ShardPlacement"
+	rows = append(rows, []string{"Hashing", p.hashing})
+	rows = append(rows, []string{"Keys sampled", fmt.Sprintf("%d", p.totalKeys)})
+	rows = append(rows, []string{"Keys CV", fmt.Sprintf("%f", p.KeysCV())})
+	rows = append(rows, []string{"Keys max/mean", fmt.Sprintf("%f", maxToMean(p.loads(p.keys)))})
+	rows = append(rows, []string{"Bytes CV", fmt.Sprintf("%f", p.BytesCV())})
+	rows = append(rows, []string{"Bytes max/mean", fmt.Sprintf("%f", maxToMean(p.loads(p.bytes)))})
+	rows = append(rows, []string{"Remapped on removal", fmt.Sprintf("%f", p.remapped)})
+	for i, backend := range p.backends {
+		rows = append(rows, []string{fmt.Sprintf("-> %s keys", backend), fmt.Sprintf("%d (%f)", p.keys[i], share(p.keys[i], p.totalKeys))})
+		rows = append(rows, []string{fmt.Sprintf("-> %s bytes", backend), fmt.Sprintf("%d (%f)", p.bytes[i], share(p.bytes[i], p.totalBytes))})
+	}
+	return
+}
+
+func (p *ShardPlacement) Export() map[string]interface{} {
+	backends := make(map[string]interface{})
+	for i, backend := range p.backends {
+		backends[backend.String()] = map[string]interface{}{
+			"weight":     p.weights[i],
+			"keys":       p.keys[i],
+			"key_share":  share(p.keys[i], p.totalKeys),
+			"bytes":      p.bytes[i],
+			"byte_share": share(p.bytes[i], p.totalBytes),
+		}
+	}
+	return map[string]interface{}{
+		"shard_placement": map[string]interface{}{
+			"hashing":             p.hashing,
+			"keys":                p.totalKeys,
+			"bytes":               p.totalBytes,
+			"keys_cv":             p.KeysCV(),
+			"keys_max_to_mean":    maxToMean(p.loads(p.keys)),
+			"bytes_cv":            p.BytesCV(),
+			"bytes_max_to_mean":   maxToMean(p.loads(p.bytes)),
+			"remapped_on_removal": p.remapped,
+			"backends":            backends,
+		},
+	}
+}
@@ -0,0 +1,374 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPool is a name of the main storage pool of a proxy, sized by its cache size,
+	// objects no rule selects a pool for are stored in it
+	DefaultPool = "s0"
+	// TransientPool is a name of the pool of short-lived objects, as Transient storage of Varnish
+	TransientPool = "Transient"
+	// Shortlived is a lifetime, objects living shorter than it are stored in Transient pool if there is one.
+	// Unlike Transient storage of Varnish, the pool is limited by its size.
+	Shortlived = 10 * time.Second
+)
+
+// PoolConfig is a named storage pool of a proxy besides the main one, as a stevedore of Varnish
+type PoolConfig struct {
+	Name     string `json:"name" yaml:"name"`
+	Size     int    `json:"size" yaml:"size"`
+	Eviction string `json:"eviction,omitempty" yaml:"eviction"`
+}
+
+// ParsePool parses a pool in name:size[:eviction] format, e.g. `Transient:1000000` or `large:50000000:s3fifo`
+func ParsePool(spec string) (PoolConfig, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return PoolConfig{}, fmt.Errorf("pool %q is not in name:size[:eviction] format", spec)
+	}
+	size, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return PoolConfig{}, fmt.Errorf("pool %q: size must be an integer", spec)
+	}
+	pool := PoolConfig{Name: parts[0], Size: size}
+	if len(parts) == 3 {
+		pool.Eviction = parts[2]
+	}
+	return pool, nil
+}
+
+// PoolRule selects the pool objects are stored in by their lifetime, size or URL.
+// Conditions not set match any object, an object is stored in the pool of the first rule,
+// whose conditions all match.
+type PoolRule struct {
+	Pool string `json:"pool" yaml:"pool"`
+	// MaxTTL matches objects living (TTL, grace and keep) not longer,
+	// objects kept until nuked never match it
+	MaxTTL time.Duration `json:"maxTtl,omitempty" yaml:"maxTtl"`
+	// MinSize and MaxSize match objects of sizes in the range, zero is no limit
+	MinSize int `json:"minSize,omitempty" yaml:"minSize"`
+	MaxSize int `json:"maxSize,omitempty" yaml:"maxSize"`
+	// URL is a regular expression matching URLs of objects
+	URL string `json:"url,omitempty" yaml:"url"`
+}
+
+// ParsePoolRule parses a rule in pool:condition=value,... format with conditions max-ttl, min-size,
+// max-size and url, e.g. `Transient:max-ttl=1m` or `large:min-size=1000000,url=^/video/`.
+// URL is the last condition, as its regular expression may hold commas.
+func ParsePoolRule(spec string) (PoolRule, error) {
+	pool, conditions, ok := strings.Cut(spec, ":")
+	if !ok || pool == "" || conditions == "" {
+		return PoolRule{}, fmt.Errorf("pool rule %q is not in pool:condition=value,... format", spec)
+	}
+
+	rule := PoolRule{Pool: pool}
+	for conditions != "" {
+		var condition string
+		if strings.HasPrefix(conditions, "url=") {
+			condition, conditions = conditions, ""
+		} else {
+			condition, conditions, _ = strings.Cut(conditions, ",")
+		}
+		key, value, ok := strings.Cut(condition, "=")
+		if !ok {
+			return PoolRule{}, fmt.Errorf("pool rule %q: condition %q is not in <name>=<value> format", spec, condition)
+		}
+
+		var err error
+		switch key {
+		case "max-ttl":
+			rule.MaxTTL, err = time.ParseDuration(value)
+		case "min-size":
+			rule.MinSize, err = strconv.Atoi(value)
+		case "max-size":
+			rule.MaxSize, err = strconv.Atoi(value)
+		case "url":
+			rule.URL = value
+		default:
+			return PoolRule{}, fmt.Errorf("pool rule %q has no condition %q", spec, key)
+		}
+		if err != nil {
+			return PoolRule{}, fmt.Errorf("pool rule %q: %s: %w", spec, key, err)
+		}
+	}
+	return rule, nil
+}
+
+// ValidatePools checks pools and rules selecting them, pools are named uniquely
+// and differ from the main one, rules select known pools
+func ValidatePools(pools []PoolConfig, rules []PoolRule) error {
+	names := map[string]bool{DefaultPool: true}
+	for _, pool := range pools {
+		if names[pool.Name] {
+			return fmt.Errorf("pool %q is defined twice", pool.Name)
+		}
+		names[pool.Name] = true
+		if pool.Size <= 0 {
+			return fmt.Errorf("pool %q: size must be greater than 0", pool.Name)
+		}
+		if !IsEvictionPolicy(pool.Eviction) {
+			return fmt.Errorf("pool %q: unknown eviction policy %q", pool.Name, pool.Eviction)
+		}
+	}
+	for _, rule := range rules {
+		if !names[rule.Pool] {
+			return fmt.Errorf("pool rule selects unknown pool %q", rule.Pool)
+		}
+		if rule.MaxTTL < 0 || rule.MinSize < 0 || rule.MaxSize < 0 {
+			return fmt.Errorf("pool rule of %q: conditions must not be negative", rule.Pool)
+		}
+		if _, err := regexp.Compile(rule.URL); err != nil {
+			return fmt.Errorf("pool rule of %q: %w", rule.Pool, err)
+		}
+	}
+	return nil
+}
+
+// poolRule is a rule with its URL expression compiled
+type poolRule struct {
+	PoolRule
+	pool int
+	url  *regexp.Regexp
+}
+
+// matches returns if the object of the request matches conditions of the rule,
+// limited is false for objects kept until nuked
+func (r *poolRule) matches(req *Request, lifetime Lifetime, limited bool, size int) bool {
+	if r.MaxTTL > 0 && (!limited || lifetime.TTL+lifetime.Grace+lifetime.Keep > r.MaxTTL) {
+		return false
+	}
+	if r.MinSize > 0 && size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+	return r.url == nil || r.url.MatchString(req.Url)
+}
+
+// PoolUsage is a usage of a storage pool
+type PoolUsage struct {
+	Name     string
+	Eviction string
+	Size     int
+	// Used is effective bytes stored in the pool, Nominal is bytes of bodies of the objects
+	Used    int
+	Nominal int
+}
+
+// Pools is a storage of a proxy split into named pools, each with its own size and eviction policy.
+// A fetched object is stored in the pool selected for it, the main pool by default.
+// Objects are looked up in all pools, there are only a few of them.
+type Pools struct {
+	names    []string
+	storages []Storage[string, int]
+	rules    []poolRule
+	// transient is an index of Transient pool, -1 if there is none
+	transient int
+
+	// selected is the pool the next object is stored in
+	selected int
+}
+
+// NewPools returns pools of the main storage and the configured ones
+func NewPools(main Storage[string, int], configs []PoolConfig, rules []PoolRule) (*Pools, error) {
+	if err := ValidatePools(configs, rules); err != nil {
+		return nil, err
+	}
+
+	p := &Pools{
+		names:     []string{DefaultPool},
+		storages:  []Storage[string, int]{main},
+		transient: -1,
+	}
+	index := map[string]int{DefaultPool: 0}
+	for _, config := range configs {
+		storage, err := NewStorage[string, int](config.Eviction, config.Size)
+		if err != nil {
+			return nil, err
+		}
+		index[config.Name] = len(p.names)
+		if config.Name == TransientPool {
+			p.transient = len(p.names)
+		}
+		p.names = append(p.names, config.Name)
+		p.storages = append(p.storages, storage)
+	}
+	for _, rule := range rules {
+		compiled := poolRule{PoolRule: rule, pool: index[rule.Pool]}
+		if rule.URL != "" {
+			compiled.url = regexp.MustCompile(rule.URL)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+// empty returns pools of the same sizes and policies without objects
+func (p *Pools) empty() (*Pools, error) {
+	empty := &Pools{
+		names:     p.names,
+		rules:     p.rules,
+		transient: p.transient,
+	}
+	for _, storage := range p.storages {
+		fresh, err := NewStorage[string, int](storage.String(), storage.Size())
+		if err != nil {
+			return nil, err
+		}
+		empty.storages = append(empty.storages, fresh)
+	}
+	return empty, nil
+}
+
+// choose selects the pool the object of the request is stored in by the next Store.
+// Short-lived objects go to Transient pool, the rest to the pool of the first matching rule.
+func (p *Pools) choose(req *Request, lifetime Lifetime, limited bool, size int) {
+	p.selected = 0
+	if p.transient >= 0 && limited && lifetime.TTL+lifetime.Grace+lifetime.Keep < Shortlived {
+		p.selected = p.transient
+		return
+	}
+	for i := range p.rules {
+		if p.rules[i].matches(req, lifetime, limited, size) {
+			p.selected = p.rules[i].pool
+			return
+		}
+	}
+}
+
+// Usage returns usage of each pool, the main one first
+func (p *Pools) Usage() []PoolUsage {
+	usage := make([]PoolUsage, len(p.storages))
+	for i, storage := range p.storages {
+		usage[i] = PoolUsage{
+			Name:     p.names[i],
+			Eviction: storage.String(),
+			Size:     storage.Size(),
+			Used:     storage.Stored(),
+			Nominal:  nominalOf(storage),
+		}
+	}
+	return usage
+}
+
+// Policies returns eviction policies of the pools
+func (p *Pools) Policies() []string {
+	policies := make([]string, len(p.storages))
+	for i, storage := range p.storages {
+		policies[i] = storage.String()
+	}
+	return policies
+}
+
+func (p *Pools) Size() int {
+	size := 0
+	for _, storage := range p.storages {
+		size += storage.Size()
+	}
+	return size
+}
+
+// Nominal returns bytes of bodies of objects stored in the pools
+func (p *Pools) Nominal() int {
+	nominal := 0
+	for _, storage := range p.storages {
+		nominal += nominalOf(storage)
+	}
+	return nominal
+}
+
+func (p *Pools) Stored() int {
+	stored := 0
+	for _, storage := range p.storages {
+		stored += storage.Stored()
+	}
+	return stored
+}
+
+// String returns eviction policies of the pools by their names
+func (p *Pools) String() string {
+	policies := make([]string, len(p.storages))
+	for i, storage := range p.storages {
+		policies[i] = p.names[i] + "=" + storage.String()
+	}
+	return strings.Join(policies, ",")
+}
+
+// Store stores the object in the selected pool, a copy in another pool is removed.
+// Objects are stored in the main pool, unless a pool is chosen for them.
+func (p *Pools) Store(k string, v int) bool {
+	selected := p.selected
+	p.selected = 0
+	p.Remove(k)
+
+	return p.storages[selected].Store(k, v)
+}
+
+func (p *Pools) Get(k string) (int, bool) {
+	pool, ok := p.lookup(k)
+	if !ok {
+		return 0, false
+	}
+	return p.storages[pool].Get(k)
+}
+
+func (p *Pools) Peek(k string) (int, bool) {
+	pool, ok := p.lookup(k)
+	if !ok {
+		return 0, false
+	}
+	return p.storages[pool].Peek(k)
+}
+
+func (p *Pools) Remove(k string) bool {
+	pool, ok := p.lookup(k)
+	if !ok {
+		return false
+	}
+	return p.storages[pool].Remove(k)
+}
+
+// lookup returns the pool the object is stored in
+func (p *Pools) lookup(k string) (int, bool) {
+	for i, storage := range p.storages {
+		if _, ok := storage.Peek(k); ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (p *Pools) seek(seq int) {
+	for _, storage := range p.storages {
+		seek(storage, seq)
+	}
+}
+
+func (p *Pools) foresee(f *Future) {
+	for _, storage := range p.storages {
+		foresee(storage, f)
+	}
+}
+
+// victim returns the object the selected pool evicts next
+func (p *Pools) victim() (string, bool) {
+	if s, ok := p.storages[p.selected].(evicting[string]); ok {
+		return s.victim()
+	}
+	return "", false
+}
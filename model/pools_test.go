@@ -0,0 +1,112 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePool(t *testing.T) {
+	pool, err := ParsePool("large:5000:s3fifo")
+	if err != nil || pool != (PoolConfig{Name: "large", Size: 5000, Eviction: S3FIFOPolicy}) {
+		t.Fatalf("error: parsed %v, %v", pool, err)
+	}
+	for _, spec := range []string{"large", "large:big", ":100", "large:100:lru:x"} {
+		if _, err := ParsePool(spec); err == nil {
+			t.Fatalf("error: %q should be invalid", spec)
+		}
+	}
+}
+
+func TestParsePoolRule(t *testing.T) {
+	rule, err := ParsePoolRule("large:max-ttl=1m,min-size=100,url=^/(a|b),c")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	expected := PoolRule{Pool: "large", MaxTTL: time.Minute, MinSize: 100, URL: "^/(a|b),c"}
+	if rule != expected {
+		t.Fatalf("error: parsed %v instead of %v", rule, expected)
+	}
+	for _, spec := range []string{"large", "large:size=1", "large:max-ttl=1", "large:min-size"} {
+		if _, err := ParsePoolRule(spec); err == nil {
+			t.Fatalf("error: %q should be invalid", spec)
+		}
+	}
+}
+
+func TestValidatePools(t *testing.T) {
+	invalid := []struct {
+		pools []PoolConfig
+		rules []PoolRule
+	}{
+		{[]PoolConfig{{Name: DefaultPool, Size: 10}}, nil},
+		{[]PoolConfig{{Name: "a", Size: 10}, {Name: "a", Size: 10}}, nil},
+		{[]PoolConfig{{Name: "a"}}, nil},
+		{[]PoolConfig{{Name: "a", Size: 10, Eviction: "mru"}}, nil},
+		{nil, []PoolRule{{Pool: "a"}}},
+		{[]PoolConfig{{Name: "a", Size: 10}}, []PoolRule{{Pool: "a", URL: "("}}},
+	}
+	for i, c := range invalid {
+		if err := ValidatePools(c.pools, c.rules); err == nil {
+			t.Fatalf("error: case %d should be invalid", i)
+		}
+	}
+}
+
+func TestPools(t *testing.T) {
+	proxy, err := NewVarnishProxy("proxy", 1000, LRUPolicy)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(&Backend{Hostname: "default"}).SetLifetime(Lifetime{TTL: time.Hour})
+	err = proxy.SetPools(
+		[]PoolConfig{{Name: TransientPool, Size: 100}, {Name: "large", Size: 500, Eviction: LFUPolicy}, {Name: "video", Size: 300}},
+		[]PoolRule{{Pool: "large", MinSize: 200}, {Pool: "video", URL: "^/video/"}},
+	)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	short := NewRequest("/short", 10)
+	short.TTL = time.Second
+	requests := map[string]*Request{
+		TransientPool: short,
+		"large":       NewRequest("/video/large", 250),
+		"video":       NewRequest("/video/small", 20),
+		DefaultPool:   NewRequest("/page", 30),
+	}
+	for _, req := range requests {
+		proxy.Get(req)
+	}
+
+	for _, pool := range proxy.PoolUsage() {
+		if pool.Used != requests[pool.Name].Size {
+			t.Fatalf("error: pool %s uses %d bytes instead of %d", pool.Name, pool.Used, requests[pool.Name].Size)
+		}
+	}
+	if proxy.cache.Size() != 1900 || proxy.cache.Stored() != 310 {
+		t.Fatalf("error: pools of %d bytes store %d bytes", proxy.cache.Size(), proxy.cache.Stored())
+	}
+	if _, ok := proxy.cache.Get("/video/large"); !ok {
+		t.Fatalf("error: objects should be looked up in all pools")
+	}
+
+	pools := proxy.Export()["proxy"].(map[string]interface{})["pools"].(map[string]interface{})
+	if pools["large"].(map[string]interface{})["eviction"] != LFUPolicy {
+		t.Fatalf("error: unexpected pools %v", pools)
+	}
+
+	if err := proxy.Restart(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(proxy.PoolUsage()) != 4 || proxy.cache.Stored() != 0 {
+		t.Fatalf("error: restart should empty pools, keeping them")
+	}
+}
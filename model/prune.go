@@ -0,0 +1,29 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+// pruneMin is a size of lazily pruned collections, below which they are not pruned
+const pruneMin = 1024
+
+// lazyPrune decides, when a collection, whose entries go stale without being removed
+// (e.g. expired markers or objects nuked by the storage), is pruned: when it has grown
+// twice as large as it was after the last pruning. Zero value is ready to use.
+type lazyPrune struct {
+	at int
+}
+
+// due returns if the collection of the size should be pruned
+func (p *lazyPrune) due(size int) bool {
+	return size >= pruneMin && size >= p.at
+}
+
+// pruned notes the size of the collection after it was pruned
+func (p *lazyPrune) pruned(size int) {
+	p.at = 2 * size
+}
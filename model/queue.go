@@ -0,0 +1,109 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import "container/list"
+
+// queueEntry is an object placed in byteQueue
+type queueEntry[K comparable, V Numeric] struct {
+	key   K
+	value V
+
+	// freq is an access counter, used only by some policies
+	freq int
+}
+
+// byteQueue is a queue of objects with an index by key,
+// that keeps track of the amount of bytes the objects take.
+// Front of the queue is the most recent object, back is the oldest one.
+// Used as a building block for eviction policies, also for ghost queues
+// where the value is a size of an object that is no longer stored.
+type byteQueue[K comparable, V Numeric] struct {
+	list  *list.List
+	items map[K]*list.Element
+	bytes V
+}
+
+func newByteQueue[K comparable, V Numeric]() *byteQueue[K, V] {
+	return &byteQueue[K, V]{
+		list:  list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+func (q *byteQueue[K, V]) Len() int {
+	return q.list.Len()
+}
+
+func (q *byteQueue[K, V]) get(k K) (*queueEntry[K, V], bool) {
+	el, ok := q.items[k]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*queueEntry[K, V]), true
+}
+
+func (q *byteQueue[K, V]) contains(k K) bool {
+	_, ok := q.items[k]
+	return ok
+}
+
+// pushFront adds the entry to the front of the queue
+func (q *byteQueue[K, V]) pushFront(e *queueEntry[K, V]) {
+	q.items[e.key] = q.list.PushFront(e)
+	q.bytes += e.value
+}
+
+func (q *byteQueue[K, V]) moveToFront(k K) {
+	if el, ok := q.items[k]; ok {
+		q.list.MoveToFront(el)
+	}
+}
+
+func (q *byteQueue[K, V]) remove(k K) (*queueEntry[K, V], bool) {
+	el, ok := q.items[k]
+	if !ok {
+		return nil, false
+	}
+	return q.removeElement(el), true
+}
+
+// back returns the oldest entry
+func (q *byteQueue[K, V]) back() (*queueEntry[K, V], bool) {
+	el := q.list.Back()
+	if el == nil {
+		return nil, false
+	}
+	return el.Value.(*queueEntry[K, V]), true
+}
+
+// popBack removes the oldest entry
+func (q *byteQueue[K, V]) popBack() (*queueEntry[K, V], bool) {
+	el := q.list.Back()
+	if el == nil {
+		return nil, false
+	}
+	return q.removeElement(el), true
+}
+
+// trim removes the oldest entries until the queue takes no more than limit bytes
+func (q *byteQueue[K, V]) trim(limit V) {
+	for q.bytes > limit {
+		if _, ok := q.popBack(); !ok {
+			return
+		}
+	}
+}
+
+func (q *byteQueue[K, V]) removeElement(el *list.Element) *queueEntry[K, V] {
+	e := q.list.Remove(el).(*queueEntry[K, V])
+	delete(q.items, e.key)
+	q.bytes -= e.value
+	return e
+}
@@ -0,0 +1,125 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import "fmt"
+
+// Replication is a cost of hot keys replicated across shards by shard directors of a layer.
+// Hot keys are spread among replicas to balance the load, each replica caches its copy
+// of each variant. Keys, that are hot at the end of the run, are reported.
+type Replication struct {
+	threshold int
+	replicas  int
+
+	// keys is an amount of keys replicated, requests is an amount of requests of them
+	keys     int
+	requests int
+	total    int
+
+	// copies is an amount of extra copies of replicated keys cached by the shards,
+	// duplicated is their size, stored is the size of all objects cached by the shards
+	copies     int
+	duplicated int
+	stored     int
+}
+
+// NewReplication returns the cost of replication by the directors, that share their shards
+func NewReplication(directors ...*ShardDirector) *Replication {
+	r := &Replication{}
+	if len(directors) == 0 {
+		return r
+	}
+	r.threshold = directors[0].params.HotThreshold
+	r.replicas = directors[0].params.hotReplicas()
+
+	replicated := make(map[string]map[string]bool)
+	for _, director := range directors {
+		for hash, keys := range director.replicated {
+			if replicated[hash] == nil {
+				replicated[hash] = make(map[string]bool)
+			}
+			for key := range keys {
+				replicated[hash][key] = true
+			}
+		}
+	}
+	for _, director := range directors {
+		for _, load := range director.load {
+			r.total += load.requests
+			// hot keys are heavy enough to be among the heaviest keys tracked
+			for hash, keyLoad := range load.hot.keys {
+				if _, ok := replicated[hash]; ok {
+					r.requests += keyLoad.requests
+				}
+			}
+		}
+	}
+	r.keys = len(replicated)
+
+	shards := make([]*VarnishProxy, 0)
+	for _, backend := range directors[0].backends {
+		if proxy, ok := backend.(*VarnishProxy); ok {
+			shards = append(shards, proxy)
+			r.stored += proxy.cache.Stored()
+		}
+	}
+	for _, keys := range replicated {
+		for key := range keys {
+			copies := 0
+			for _, shard := range shards {
+				if size, ok := shard.cache.Peek(key); ok {
+					copies++
+					if copies > 1 {
+						r.duplicated += size
+					}
+				}
+			}
+			if copies > 1 {
+				r.copies += copies - 1
+			}
+		}
+	}
+	return r
+}
+
+// Enabled returns if the directors replicate hot keys
+func (r *Replication) Enabled() bool {
+	return r.threshold > 0
+}
+
+// DuplicatedShare returns a share of bytes cached by the shards, that are extra copies of replicated keys
+func (r *Replication) DuplicatedShare() float64 {
+	return share(r.duplicated, r.stored)
+}
+
+func (r *Replication) TableData() (name string, rows [][]string) {
+	name = "Replication"
+	rows = append(rows, []string{"Hot threshold", fmt.Sprintf("%d", r.threshold)})
+	rows = append(rows, []string{"Replicas", fmt.Sprintf("%d", r.replicas)})
+	rows = append(rows, []string{"Hot keys", fmt.Sprintf("%d", r.keys)})
+	rows = append(rows, []string{"Hot requests", fmt.Sprintf("%d (%f)", r.requests, share(r.requests, r.total))})
+	rows = append(rows, []string{"Extra copies", fmt.Sprintf("%d", r.copies)})
+	rows = append(rows, []string{"Duplicated bytes", fmt.Sprintf("%d (%f)", r.duplicated, r.DuplicatedShare())})
+	return
+}
+
+func (r *Replication) Export() map[string]interface{} {
+	return map[string]interface{}{
+		"replication": map[string]interface{}{
+			"hot_threshold":    r.threshold,
+			"replicas":         r.replicas,
+			"hot_keys":         r.keys,
+			"hot_requests":     r.requests,
+			"hot_share":        share(r.requests, r.total),
+			"extra_copies":     r.copies,
+			"duplicated_bytes": r.duplicated,
+			"duplicated_share": r.DuplicatedShare(),
+		},
+	}
+}
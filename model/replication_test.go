@@ -0,0 +1,89 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHotKeyReplication(t *testing.T) {
+	origin := &Backend{Hostname: "origin"}
+	director, err := NewShardDirectorWithParams(DirectorParams{HotThreshold: 5, HotReplicas: 2})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	shards := make([]*VarnishProxy, 0)
+	for i := 0; i < 3; i++ {
+		shard, err := NewVarnishProxy(fmt.Sprintf("shard-%d", i), 10000, "")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		shard.SetBackend(origin)
+		shards = append(shards, shard)
+		director.AddBackend(shard)
+	}
+
+	picked := make(map[WebInterface]int)
+	for i := 0; i < 100; i++ {
+		req := NewRequest("/viral", 100)
+		backend := PickBackend(director, req)
+		backend.Get(req)
+		picked[backend]++
+	}
+	if len(picked) != 2 {
+		t.Fatalf("error: viral key was served by %d shards", len(picked))
+	}
+	for backend, requests := range picked {
+		if requests < 30 {
+			t.Fatalf("error: replica %s served %d requests of the viral key", backend, requests)
+		}
+	}
+
+	// keys requested less than the threshold are not replicated
+	for i := 0; i < 4; i++ {
+		req := NewRequest("/cold", 100)
+		PickBackend(director, req).Get(req)
+	}
+
+	replication := NewReplication(director)
+	if replication.keys != 1 || replication.copies != 1 || replication.duplicated != 100 {
+		t.Fatalf("error: unexpected replication %+v", replication)
+	}
+	if replication.requests != 100 || replication.DuplicatedShare() != 100.0/300 {
+		t.Fatalf("error: unexpected replication %+v", replication)
+	}
+}
+
+func TestReplicatedVariantsAge(t *testing.T) {
+	director, err := NewShardDirectorWithParams(DirectorParams{HotThreshold: 5, HotReplicas: 2})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	director.AddBackend(&Backend{Hostname: "shard-0"})
+	director.AddBackend(&Backend{Hostname: "shard-1"})
+
+	for i := 0; i < 20; i++ {
+		req := NewRequest("/viral", 100)
+		req.Headers = map[string]string{"Accept-Encoding": []string{"gzip", "br"}[i%2]}
+		req.SetVary("Accept-Encoding")
+		PickBackend(director, req)
+	}
+	if variants := director.replicated["/viral"]; len(variants) != 2 {
+		t.Fatalf("error: %d variants of the hot key are replicated", len(variants))
+	}
+
+	// popularity of the key fades, as other keys are requested
+	for i := 0; i < 4*hotAgingPeriod; i++ {
+		PickBackend(director, NewRequest(fmt.Sprintf("/tail/%d", i%1000), 1))
+	}
+	if _, ok := director.replicated["/viral"]; ok {
+		t.Fatalf("error: key, that is not hot anymore, is replicated")
+	}
+}
@@ -0,0 +1,165 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UnsetDuration marks a duration of Request that is not set by the trace,
+// web interface uses its own default instead.
+const UnsetDuration time.Duration = -1
+
+// Request is a request passed through the simulation topology.
+// Besides request attributes it carries attributes of the response
+// (size, TTL, ...), as they are taken from the same line of the trace.
+type Request struct {
+	Url  string
+	Size int
+
+	// Host is a value of Host header of the request
+	Host string
+	// Client is an address of the client, that sent the request
+	Client string
+	// Headers are selected headers of the request by their canonical names,
+	// responses may vary on them (e.g. Accept-Encoding, Accept-Language, a device class)
+	Headers map[string]string
+	// Vary are sorted canonical names of request headers the response varies on
+	Vary []string
+	// Status is a status code of the response
+	Status int
+	// CacheControl is a value of Cache-Control header of the response
+	CacheControl string
+
+	// Timestamp is a time of the request on the virtual clock of simulation
+	Timestamp time.Time
+	// Seq is a position of the request in the trace, optimal storages
+	// look next uses of objects up by it
+	Seq int
+
+	// TTL, Grace and Keep of the object, UnsetDuration if not set by the trace
+	TTL   time.Duration
+	Grace time.Duration
+	Keep  time.Duration
+
+	// Uncacheable marks a response, that can not be cached (e.g. Set-Cookie,
+	// private or 5xx), proxies replace the object by a marker
+	Uncacheable bool
+
+	// Conditional marks a revalidation request of an object kept
+	// after its grace, upstream answers it 304-style.
+	Conditional bool
+	// Bereq marks a backend request sent by a proxy, as opposed to a client request
+	Bereq bool
+	// Failed marks a request answered with an error, as no healthy backend
+	// was found on its way. Failed responses are not cached.
+	Failed bool
+	// Duration is the time the response took on the simulated timeline,
+	// set by the web interface answering the request
+	Duration time.Duration
+
+	// process is the process of the engine serving the request, nil if it is served at once
+	process *process
+}
+
+// NewRequest is a constructor for Request with durations not set
+func NewRequest(url string, size int) *Request {
+	return &Request{
+		Url:   url,
+		Size:  size,
+		TTL:   UnsetDuration,
+		Grace: UnsetDuration,
+		Keep:  UnsetDuration,
+	}
+}
+
+// SetHeader sets the request header
+func (r *Request) SetHeader(name, value string) {
+	if r.Headers == nil {
+		r.Headers = make(map[string]string)
+	}
+	r.Headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+}
+
+// SetVary sets names of headers the response varies on from Vary header,
+// responses varying on `*` are uncacheable
+func (r *Request) SetVary(value string) {
+	r.Vary = nil
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "-":
+			continue
+		case "*":
+			r.Uncacheable = true
+			continue
+		}
+		r.Vary = append(r.Vary, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	sort.Strings(r.Vary)
+}
+
+// HashKey returns the key the object is hashed by, the host followed by the URL,
+// as the builtin vcl_hash does. Variants of the object share it, directors hash requests by it.
+func (r *Request) HashKey() string {
+	return r.Host + r.Url
+}
+
+// CacheKey returns the key of the object variant in the cache,
+// the hash key followed by values of headers the response varies on
+func (r *Request) CacheKey() string {
+	if len(r.Vary) == 0 {
+		return r.HashKey()
+	}
+
+	key := strings.Builder{}
+	key.WriteString(r.HashKey())
+	for _, name := range r.Vary {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(r.Headers[name])
+	}
+	return key.String()
+}
+
+// Lifetime is TTL, grace and keep of a cached object
+type Lifetime struct {
+	TTL   time.Duration `json:"ttl"`
+	Grace time.Duration `json:"grace"`
+	Keep  time.Duration `json:"keep"`
+}
+
+// Override returns lifetime with durations set by the request
+func (l Lifetime) Override(req *Request) Lifetime {
+	if req.TTL != UnsetDuration {
+		l.TTL = req.TTL
+	}
+	if req.Grace != UnsetDuration {
+		l.Grace = req.Grace
+	}
+	if req.Keep != UnsetDuration {
+		l.Keep = req.Keep
+	}
+	return l
+}
+
+// String returns lifetime in TTL/Grace/Keep format
+func (l Lifetime) String() string {
+	return fmt.Sprintf("%s/%s/%s", l.TTL, l.Grace, l.Keep)
+}
+
+// Export returns a map of lifetime durations for exporting
+func (l Lifetime) Export() map[string]string {
+	return map[string]string{"ttl": l.TTL.String(), "grace": l.Grace.String(), "keep": l.Keep.String()}
+}
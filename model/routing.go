@@ -0,0 +1,143 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// RouterName is a name of the router among nodes of the topology
+	RouterName = "router"
+	// DefaultOrigin is a name of the origin serving requests no route matches
+	DefaultOrigin = "default"
+)
+
+// Route maps requests of a host, a URL prefix or both to a named origin
+type Route struct {
+	// Host is either an exact host or a wildcard like `*.example.com`, empty matches any host
+	Host string `json:"host,omitempty" yaml:"host"`
+	// Prefix is a prefix of the URL path, empty matches any URL
+	Prefix string `json:"prefix,omitempty" yaml:"prefix"`
+	Origin string `json:"origin" yaml:"origin"`
+}
+
+// ParseRoute parses a route in `[host][/prefix]=origin` format,
+// e.g. `www.example.com=www`, `/api=api` or `*.example.com/static=static`
+func ParseRoute(spec string) (Route, error) {
+	match, origin, ok := strings.Cut(spec, "=")
+	if !ok || match == "" || origin == "" {
+		return Route{}, fmt.Errorf("route %q is not in [host][/prefix]=origin format", spec)
+	}
+
+	route := Route{Origin: origin}
+	if idx := strings.IndexByte(match, '/'); idx >= 0 {
+		route.Host, route.Prefix = match[:idx], match[idx:]
+	} else {
+		route.Host = match
+	}
+	return route, nil
+}
+
+// Matches returns if the request matches the route
+func (r Route) Matches(req *Request) bool {
+	if r.Prefix != "" && !strings.HasPrefix(req.Url, r.Prefix) {
+		return false
+	}
+
+	switch {
+	case r.Host == "":
+		return true
+	case strings.HasPrefix(r.Host, "*."):
+		// wildcard matches subdomains, not the domain itself
+		return strings.HasSuffix(strings.ToLower(req.Host), strings.ToLower(r.Host[1:]))
+	}
+	return strings.EqualFold(req.Host, r.Host)
+}
+
+// String returns the route in the format it is parsed from
+func (r Route) String() string {
+	return fmt.Sprintf("%s%s=%s", r.Host, r.Prefix, r.Origin)
+}
+
+// Router routes requests to origins by the routing table, the way VCL
+// sets the backend by the host and the URL in vcl_recv.
+// The first matching route wins, requests no route matches go to the default origin.
+type Router struct {
+	routes []Route
+	// origins by name, the default one serves unmatched requests
+	origins  map[string]*Backend
+	fallback *Backend
+}
+
+// NewRouter is a constructor for Router, origins of routes should be among the origins,
+// the first origin is the default one
+func NewRouter(routes []Route, origins []*Backend) (*Router, error) {
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("router has no origins")
+	}
+
+	r := &Router{
+		routes:   routes,
+		origins:  make(map[string]*Backend),
+		fallback: origins[0],
+	}
+	for _, origin := range origins {
+		r.origins[origin.Hostname] = origin
+	}
+	for _, route := range routes {
+		if _, ok := r.origins[route.Origin]; !ok {
+			return nil, fmt.Errorf("route %q: unknown origin %q", route, route.Origin)
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the origin serving the request
+func (r *Router) Resolve(req *Request) *Backend {
+	for _, route := range r.routes {
+		if route.Matches(req) {
+			return r.origins[route.Origin]
+		}
+	}
+	return r.fallback
+}
+
+// Get interface WebInterface for Router,
+// requests routed to an origin that is down fail
+func (r *Router) Get(req *Request) int {
+	origin := r.Resolve(req)
+	if !origin.Healthy() {
+		req.Failed = true
+		req.Duration = 0
+		return 0
+	}
+	return origin.Get(req)
+}
+
+// String returns the name of the web interface
+func (r *Router) String() string {
+	return RouterName
+}
+
+// Sites is a per-site origin offload, a site is the traffic routed to an origin
+type Sites []*Backend
+
+func (s Sites) TableData() (name string, rows [][]string) {
+	name = "Sites"
+	for _, origin := range s {
+		offload := origin.Offload()
+		rows = append(rows, []string{origin.Hostname + " requests", fmt.Sprintf("%d", offload.Requests)})
+		rows = append(rows, []string{origin.Hostname + " origin requests", fmt.Sprintf("%d", offload.OriginRequests)})
+		rows = append(rows, []string{origin.Hostname + " offload", fmt.Sprintf("%f", offload.RequestOffload())})
+		rows = append(rows, []string{origin.Hostname + " byte offload", fmt.Sprintf("%f", offload.ByteOffload())})
+	}
+	return
+}
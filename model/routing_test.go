@@ -0,0 +1,134 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	valid := map[string]Route{
+		"www.example.com=www":         {Host: "www.example.com", Origin: "www"},
+		"/api=api":                    {Prefix: "/api", Origin: "api"},
+		"*.example.com/static=static": {Host: "*.example.com", Prefix: "/static", Origin: "static"},
+	}
+	for spec, want := range valid {
+		route, err := ParseRoute(spec)
+		if err != nil {
+			t.Fatalf("error: %s: %v", spec, err)
+		}
+		if route != want || route.String() != spec {
+			t.Fatalf("error: %s parsed as %+v", spec, route)
+		}
+	}
+
+	for _, invalid := range []string{"www", "=www", "www="} {
+		if _, err := ParseRoute(invalid); err == nil {
+			t.Fatalf("error: route %q should not be parsed", invalid)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	origins := []*Backend{{Hostname: DefaultOrigin}, {Hostname: "www"}, {Hostname: "static"}}
+	router, err := NewRouter([]Route{
+		{Host: "*.example.com", Prefix: "/static", Origin: "static"},
+		{Host: "www.example.com", Origin: "www"},
+	}, origins)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for _, c := range []struct {
+		host, url, origin string
+	}{
+		{"www.example.com", "/static/a.png", "static"},
+		{"img.EXAMPLE.com", "/static/a.png", "static"},
+		{"example.com", "/static/a.png", DefaultOrigin},
+		{"www.example.com", "/index.html", "www"},
+		{"other.org", "/", DefaultOrigin},
+	} {
+		req := NewRequest(c.url, 10)
+		req.Host = c.host
+		if origin := router.Resolve(req); origin.Hostname != c.origin {
+			t.Fatalf("error: %s%s routed to %s instead of %s", c.host, c.url, origin, c.origin)
+		}
+	}
+
+	if _, err := NewRouter([]Route{{Origin: "nowhere"}}, origins); err == nil {
+		t.Fatalf("error: route to unknown origin should fail")
+	}
+}
+
+func TestRouterOriginDown(t *testing.T) {
+	origins := []*Backend{{Hostname: DefaultOrigin}, {Hostname: "api"}}
+	router, err := NewRouter([]Route{{Prefix: "/api", Origin: "api"}}, origins)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(router).SetRouter(router)
+
+	origins[1].SetHealthy(false)
+	req := NewRequest("/api/a", 10)
+	proxy.Get(req)
+	proxy.Get(NewRequest("/a", 10))
+
+	if !req.Failed || origins[1].Requests() != 0 || origins[0].Requests() != 1 {
+		t.Fatalf("error: request to the origin down should fail")
+	}
+	if api := origins[1].Offload(); api.Requests != 1 || api.Errors != 1 {
+		t.Fatalf("error: unexpected offload of api %+v", api)
+	}
+}
+
+func TestSitesSharingURL(t *testing.T) {
+	origins := []*Backend{{Hostname: DefaultOrigin}, {Hostname: "a"}, {Hostname: "b"}}
+	router, err := NewRouter([]Route{{Host: "a.com", Origin: "a"}, {Host: "b.com", Origin: "b"}}, origins)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy, err := NewVarnishProxy("proxy", 1000, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(router).SetRouter(router)
+
+	for _, host := range []string{"a.com", "b.com", "a.com", "b.com"} {
+		req := NewRequest("/robots.txt", 10)
+		req.Host = host
+		proxy.Get(req)
+	}
+
+	// each site fetches its own object once, the rest are hits
+	for _, origin := range origins[1:] {
+		if offload := origin.Offload(); offload.Requests != 2 || offload.OriginRequests != 1 {
+			t.Fatalf("error: unexpected offload of %s %+v", origin, offload)
+		}
+	}
+
+	// the URL of the sites is spread among shards by hosts
+	director := NewShardDirector()
+	for _, name := range []string{"s1", "s2", "s3", "s4"} {
+		director.AddBackend(&Backend{Hostname: name})
+	}
+	shards := make(map[WebInterface]bool)
+	for i := 0; i < 10; i++ {
+		req := NewRequest("/robots.txt", 10)
+		req.Host = fmt.Sprintf("site%d.com", i)
+		shards[PickBackend(director, req)] = true
+	}
+	if len(shards) < 2 {
+		t.Fatalf("error: directors should hash requests by host and URL")
+	}
+}
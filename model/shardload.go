@@ -0,0 +1,217 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// DefaultHotKeys is a default amount of the hottest keys reported for each shard
+	DefaultHotKeys = 10
+	// MaxHotKeys is an amount of the heaviest keys shard directors track for each shard,
+	// more of the hottest keys can not be reported
+	MaxHotKeys = 1000
+)
+
+// keyLoad is an amount of requests and bytes of a key,
+// index is its position in the heap of the heaviest keys
+type keyLoad struct {
+	key      string
+	requests int
+	bytes    int
+	index    int
+}
+
+// backendLoad is an amount of requests and bytes a shard director sent to a backend,
+// hot are the heaviest keys among them
+type backendLoad struct {
+	requests int
+	bytes    int
+	hot      *spaceSaving
+}
+
+// HotKey is a key with its load on a shard
+type HotKey struct {
+	Key      string
+	Requests int
+	Bytes    int
+}
+
+// ShardLoad is a load of requests shard directors of a layer sent to each shard.
+// It explains imbalance of shards by the hottest keys of each one. Loads of hot keys
+// are estimated by directors in bounded memory, they may be overestimated
+// by requests of keys, that are not among MaxHotKeys heaviest ones of the shard.
+type ShardLoad struct {
+	backends []WebInterface
+
+	requests      []int
+	bytes         []int
+	totalRequests int
+	totalBytes    int
+
+	// hot are the hottest keys of each shard, hotRequests are requests of them
+	hot         [][]HotKey
+	hotRequests []int
+}
+
+// NewShardLoad sums loads of shards of the directors, that share their backends,
+// and reports up to hotKeys (at most MaxHotKeys) of the hottest keys of each shard
+func NewShardLoad(hotKeys int, directors ...*ShardDirector) *ShardLoad {
+	l := &ShardLoad{}
+	if len(directors) == 0 {
+		return l
+	}
+	if hotKeys > MaxHotKeys {
+		hotKeys = MaxHotKeys
+	}
+
+	// shards removed during the run are reported too, after the present ones
+	seen := make(map[WebInterface]bool)
+	for _, backend := range directors[0].backends {
+		seen[backend] = true
+		l.backends = append(l.backends, backend)
+	}
+	for _, director := range directors {
+		for _, backend := range director.picked {
+			if !seen[backend] {
+				seen[backend] = true
+				l.backends = append(l.backends, backend)
+			}
+		}
+	}
+
+	for _, backend := range l.backends {
+		loads := make(map[string]*HotKey)
+		requests, bytes := 0, 0
+		for _, director := range directors {
+			load, ok := director.load[backend]
+			if !ok {
+				continue
+			}
+			requests += load.requests
+			bytes += load.bytes
+			for key, keyLoad := range load.hot.keys {
+				if loads[key] == nil {
+					loads[key] = &HotKey{Key: key}
+				}
+				loads[key].Requests += keyLoad.requests
+				loads[key].Bytes += keyLoad.bytes
+			}
+		}
+
+		hot := make([]HotKey, 0, len(loads))
+		for _, load := range loads {
+			hot = append(hot, *load)
+		}
+		sort.Slice(hot, func(i, j int) bool {
+			if hot[i].Requests != hot[j].Requests {
+				return hot[i].Requests > hot[j].Requests
+			}
+			return hot[i].Key < hot[j].Key
+		})
+		if len(hot) > hotKeys {
+			hot = hot[:hotKeys]
+		}
+		hotRequests := 0
+		for _, key := range hot {
+			hotRequests += key.Requests
+		}
+
+		l.requests = append(l.requests, requests)
+		l.bytes = append(l.bytes, bytes)
+		l.totalRequests += requests
+		l.totalBytes += bytes
+		l.hot = append(l.hot, hot)
+		l.hotRequests = append(l.hotRequests, hotRequests)
+	}
+	return l
+}
+
+// Measured returns if any request was sent to a shard
+func (l *ShardLoad) Measured() bool {
+	return l.totalRequests > 0
+}
+
+// floats converts counts to floats
+func floats(counts []int) []float64 {
+	values := make([]float64, len(counts))
+	for i, count := range counts {
+		values[i] = float64(count)
+	}
+	return values
+}
+
+// RequestsCV returns the coefficient of variation of requests of the shards
+func (l *ShardLoad) RequestsCV() float64 {
+	return CoefficientOfVariation(floats(l.requests))
+}
+
+// BytesCV returns the coefficient of variation of bytes of the shards
+func (l *ShardLoad) BytesCV() float64 {
+	return CoefficientOfVariation(floats(l.bytes))
+}
+
+// HotShare returns a share of requests of the i-th shard, that are requests of its hottest keys
+func (l *ShardLoad) HotShare(i int) float64 {
+	return share(l.hotRequests[i], l.requests[i])
+}
+
+func (l *ShardLoad) TableData() (name string, rows [][]string) {
+	name = "ShardLoad"
+	rows = append(rows, []string{"Requests", fmt.Sprintf("%d", l.totalRequests)})
+	rows = append(rows, []string{"Requests CV", fmt.Sprintf("%f", l.RequestsCV())})
+	rows = append(rows, []string{"Requests max/mean", fmt.Sprintf("%f", maxToMean(floats(l.requests)))})
+	rows = append(rows, []string{"Bytes CV", fmt.Sprintf("%f", l.BytesCV())})
+	rows = append(rows, []string{"Bytes max/mean", fmt.Sprintf("%f", maxToMean(floats(l.bytes)))})
+	for i, backend := range l.backends {
+		rows = append(rows, []string{fmt.Sprintf("-> %s requests", backend), fmt.Sprintf("%d (%f)", l.requests[i], share(l.requests[i], l.totalRequests))})
+		rows = append(rows, []string{fmt.Sprintf("-> %s bytes", backend), fmt.Sprintf("%d (%f)", l.bytes[i], share(l.bytes[i], l.totalBytes))})
+		rows = append(rows, []string{fmt.Sprintf("-> %s top %d share", backend, len(l.hot[i])), fmt.Sprintf("%f", l.HotShare(i))})
+		for rank, key := range l.hot[i] {
+			rows = append(rows, []string{fmt.Sprintf("   #%d %s", rank+1, key.Key), fmt.Sprintf("%d (%f)", key.Requests, share(key.Requests, l.requests[i]))})
+		}
+	}
+	return
+}
+
+func (l *ShardLoad) Export() map[string]interface{} {
+	shards := make(map[string]interface{})
+	for i, backend := range l.backends {
+		hot := make([]map[string]interface{}, 0, len(l.hot[i]))
+		for _, key := range l.hot[i] {
+			hot = append(hot, map[string]interface{}{
+				"key":      key.Key,
+				"requests": key.Requests,
+				"bytes":    key.Bytes,
+				"share":    share(key.Requests, l.requests[i]),
+			})
+		}
+		shards[backend.String()] = map[string]interface{}{
+			"requests":      l.requests[i],
+			"request_share": share(l.requests[i], l.totalRequests),
+			"bytes":         l.bytes[i],
+			"byte_share":    share(l.bytes[i], l.totalBytes),
+			"hot_keys":      hot,
+			"hot_share":     l.HotShare(i),
+		}
+	}
+	return map[string]interface{}{
+		"shard_load": map[string]interface{}{
+			"requests":             l.totalRequests,
+			"bytes":                l.totalBytes,
+			"requests_cv":          l.RequestsCV(),
+			"requests_max_to_mean": maxToMean(floats(l.requests)),
+			"bytes_cv":             l.BytesCV(),
+			"bytes_max_to_mean":    maxToMean(floats(l.bytes)),
+			"shards":               shards,
+		},
+	}
+}
@@ -0,0 +1,98 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardLoad(t *testing.T) {
+	directors := make([]*ShardDirector, 0)
+	backends := []*Backend{{Hostname: "backend-0"}, {Hostname: "backend-1"}}
+	for i := 0; i < 2; i++ {
+		director := NewShardDirector()
+		for _, backend := range backends {
+			director.AddBackend(backend)
+		}
+		directors = append(directors, director)
+	}
+
+	// one viral key and a long tail of keys requested once
+	hot := "/viral"
+	for i := 0; i < 100; i++ {
+		PickBackend(directors[i%2], NewRequest(hot, 10))
+		PickBackend(directors[i%2], NewRequest(fmt.Sprintf("/tail/%d", i), 1))
+	}
+	// requests, that are not observed, are not counted
+	directors[0].GetBackend(hot)
+
+	load := NewShardLoad(DefaultHotKeys, directors...)
+	if load.totalRequests != 200 || load.totalBytes != 1100 {
+		t.Fatalf("error: unexpected load %+v", load)
+	}
+	owner := 0
+	if directors[0].GetBackend(hot) == WebInterface(backends[1]) {
+		owner = 1
+	}
+	if key := load.hot[owner][0]; key.Key != hot || key.Requests != 100 || key.Bytes != 1000 {
+		t.Fatalf("error: hottest key of the owner is %+v", key)
+	}
+	if load.HotShare(owner) <= 0.6 || load.RequestsCV() <= 0.2 {
+		t.Fatalf("error: viral key does not explain imbalance, top share %f, CV %f", load.HotShare(owner), load.RequestsCV())
+	}
+	if len(load.hot[1-owner]) != DefaultHotKeys {
+		t.Fatalf("error: %d hot keys reported", len(load.hot[1-owner]))
+	}
+}
+
+func TestShardLoadBounded(t *testing.T) {
+	director := NewShardDirector()
+	backend := &Backend{Hostname: "backend"}
+	director.AddBackend(backend)
+
+	// a hot key among many more keys than tracked
+	for i := 0; i < 10*MaxHotKeys; i++ {
+		if i%10 == 0 {
+			PickBackend(director, NewRequest("/hot", 10))
+		}
+		PickBackend(director, NewRequest(fmt.Sprintf("/tail/%d", i), 1))
+	}
+	if len(director.load[backend].hot.keys) != MaxHotKeys {
+		t.Fatalf("error: director tracks %d keys", len(director.load[backend].hot.keys))
+	}
+
+	load := NewShardLoad(2*MaxHotKeys, director)
+	if load.totalRequests != 11*MaxHotKeys || load.totalBytes != 20*MaxHotKeys {
+		t.Fatalf("error: unexpected totals %d, %d", load.totalRequests, load.totalBytes)
+	}
+	if len(load.hot[0]) != MaxHotKeys {
+		t.Fatalf("error: %d hot keys reported", len(load.hot[0]))
+	}
+	if key := load.hot[0][0]; key.Key != "/hot" || key.Requests < MaxHotKeys {
+		t.Fatalf("error: hottest key is %+v", key)
+	}
+}
+
+func TestShardLoadNested(t *testing.T) {
+	shard := NewShardDirector()
+	shard.AddBackend(&Backend{Hostname: "backend"})
+	fallback := NewFallbackDirector(false)
+	fallback.AddBackend(NewDirectorBackend("shards", shard))
+
+	for i := 0; i < 10; i++ {
+		PickBackend(fallback, NewRequest(fmt.Sprintf("/%d", i), 1))
+	}
+	// picks for keys only are not counted
+	fallback.GetBackend("/0")
+
+	if load := NewShardLoad(DefaultHotKeys, shard); load.totalRequests != 10 {
+		t.Fatalf("error: nested shard director counted %d requests", load.totalRequests)
+	}
+}
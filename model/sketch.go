@@ -0,0 +1,222 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"github.com/cespare/xxhash"
+	"sort"
+	"strconv"
+)
+
+const (
+	// defaultSketchWidth is an amount of counters in each row of count-min sketches
+	defaultSketchWidth = 4096
+	// defaultSketchDepth is an amount of rows of count-min sketches
+	defaultSketchDepth = 4
+)
+
+// countMinSketch estimates frequencies of keys in fixed memory, estimates are never lower
+// than true counts, collisions of keys in all rows make them higher
+type countMinSketch struct {
+	width  int
+	counts [][]uint32
+}
+
+func newCountMinSketch(width, depth int) *countMinSketch {
+	counts := make([][]uint32, depth)
+	for i := range counts {
+		counts[i] = make([]uint32, width)
+	}
+	return &countMinSketch{width: width, counts: counts}
+}
+
+// index returns a counter of the key in the row
+func (s *countMinSketch) index(key string, row int) int {
+	return int(xxhash.Sum64String(strconv.Itoa(row)+"/"+key) % uint64(s.width))
+}
+
+// add counts an occurrence of the key, returns its estimated frequency
+func (s *countMinSketch) add(key string) uint32 {
+	estimate := ^uint32(0)
+	for row := range s.counts {
+		i := s.index(key, row)
+		if s.counts[row][i] < ^uint32(0) {
+			s.counts[row][i]++
+		}
+		if s.counts[row][i] < estimate {
+			estimate = s.counts[row][i]
+		}
+	}
+	return estimate
+}
+
+// estimate returns the estimated frequency of the key
+func (s *countMinSketch) estimate(key string) uint32 {
+	estimate := ^uint32(0)
+	for row := range s.counts {
+		if c := s.counts[row][s.index(key, row)]; c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// age halves all counters, so frequencies of the past fade
+func (s *countMinSketch) age() {
+	for _, row := range s.counts {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+}
+
+// KeySample is a uniform sample of distinct keys with their sizes, bounded by its capacity.
+// It keeps keys with the lowest hashes (bottom-k sampling), so every key has the same chance
+// to be sampled no matter how often or when it is requested, and samples of streams
+// sharing keys sample the same ones, so they are merged by adding keys of one to another.
+type KeySample struct {
+	capacity int
+	keys     map[string]*sampledKey
+	// highest is a max-heap of sampled keys by their hashes
+	highest sampleHeap
+}
+
+// NewKeySample is a constructor for KeySample
+func NewKeySample(capacity int) *KeySample {
+	return &KeySample{capacity: capacity, keys: make(map[string]*sampledKey)}
+}
+
+// Add samples the key of the size, if its hash is lower than the highest one sampled,
+// size of a sampled key is the last one added
+func (s *KeySample) Add(key string, size int) {
+	if sampled, ok := s.keys[key]; ok {
+		sampled.size = size
+		return
+	}
+	hash := xxhash.Sum64String(key)
+	if len(s.keys) >= s.capacity {
+		if s.capacity < 1 || hash >= s.highest[0].hash {
+			return
+		}
+		delete(s.keys, heap.Pop(&s.highest).(*sampledKey).key)
+	}
+	sampled := &sampledKey{key: key, hash: hash, size: size}
+	s.keys[key] = sampled
+	heap.Push(&s.highest, sampled)
+}
+
+// Len returns an amount of keys sampled
+func (s *KeySample) Len() int {
+	return len(s.keys)
+}
+
+// Keys returns keys sampled in order of their hashes
+func (s *KeySample) Keys() []string {
+	sampled := make([]*sampledKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		sampled = append(sampled, key)
+	}
+	sort.Slice(sampled, func(i, j int) bool {
+		return sampled[i].hash < sampled[j].hash
+	})
+	keys := make([]string, len(sampled))
+	for i, key := range sampled {
+		keys[i] = key.key
+	}
+	return keys
+}
+
+// Size returns the size of the sampled key
+func (s *KeySample) Size(key string) int {
+	if sampled, ok := s.keys[key]; ok {
+		return sampled.size
+	}
+	return 0
+}
+
+type sampledKey struct {
+	key  string
+	hash uint64
+	size int
+}
+
+// sampleHeap is a max-heap of sampled keys by their hashes
+type sampleHeap []*sampledKey
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[i].hash > h[j].hash }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(*sampledKey)) }
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// spaceSaving counts requests and bytes of the heaviest keys in bounded memory
+// (the space-saving algorithm). It holds up to capacity keys, a new key replaces
+// the lightest one and inherits its counts, so counts of keys are overestimated
+// by at most requests of the lightest key, and every key requested more than
+// total/capacity times is held.
+type spaceSaving struct {
+	capacity int
+	keys     map[string]*keyLoad
+	// lightest is a min-heap of keys by their requests
+	lightest loadHeap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, keys: make(map[string]*keyLoad)}
+}
+
+// add counts a request of the key of the size
+func (s *spaceSaving) add(key string, size int) {
+	load, ok := s.keys[key]
+	switch {
+	case ok:
+	case len(s.keys) < s.capacity:
+		load = &keyLoad{key: key}
+		s.keys[key] = load
+		heap.Push(&s.lightest, load)
+	case s.capacity > 0:
+		load = s.lightest[0]
+		delete(s.keys, load.key)
+		load.key = key
+		s.keys[key] = load
+	default:
+		return
+	}
+	load.requests++
+	load.bytes += size
+	heap.Fix(&s.lightest, load.index)
+}
+
+// loadHeap is a min-heap of keys by their requests
+type loadHeap []*keyLoad
+
+func (h loadHeap) Len() int           { return len(h) }
+func (h loadHeap) Less(i, j int) bool { return h[i].requests < h[j].requests }
+func (h loadHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *loadHeap) Push(x interface{}) {
+	load := x.(*keyLoad)
+	load.index = len(*h)
+	*h = append(*h, load)
+}
+func (h *loadHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -10,10 +10,30 @@ package model
 
 import lru "github.com/hashicorp/golang-lru/v2"
 
+// Storage is a byte-size aware cache storage, implementations differ
+// in the eviction policy used to free space for new objects.
 type Storage[C comparable, V Numeric] interface { // TODO: any other name?
+	// Size returns the capacity of the storage
 	Size() V
+
+	// Stored returns the amount of space used by stored objects
+	Stored() V
+
+	// Store stores an object, replacing the previous one with the same key.
+	// Returns if any object was nuked to free space for the new one.
 	Store(C, V) bool
+
+	// Get returns the stored object and marks it as accessed
 	Get(C) (V, bool)
+
+	// Peek returns the stored object without marking it as accessed
+	Peek(C) (V, bool)
+
+	// Remove removes the object, returns if it was stored
+	Remove(C) bool
+
+	// String returns the name of the eviction policy
+	String() string
 }
 
 // CacheStorage is a storage that uses LRU cache
@@ -32,18 +52,26 @@ func (s *CacheStorage[K, V]) Stored() V {
 	return s.stored
 }
 
+// String returns the name of the eviction policy
+func (s *CacheStorage[K, V]) String() string {
+	return LRUPolicy
+}
+
 // Store stores a value in the cache
 // if the value is bigger than the cache size, it returns false
 // if the value can be stored, it returns if object was nuked.
 func (s *CacheStorage[K, V]) Store(k K, v V) bool {
-	// check if size of cache allows to store a new artifact
-	size := s.cache.Len()
-
 	if v > s.size {
 		// if the object is bigger than the cache size, we cannot store it
 		return false
 	}
 
+	// replacing an object, forget the old one first
+	s.Remove(k)
+
+	// check if size of cache allows to store a new artifact
+	size := s.cache.Len()
+
 	nuked := false
 	if s.stored+v <= s.size {
 		// if we can store, resize cache to store more keys.
@@ -90,6 +118,26 @@ func (s *CacheStorage[K, V]) Get(k K) (V, bool) {
 	return s.cache.Get(k)
 }
 
+func (s *CacheStorage[K, V]) Peek(k K) (V, bool) {
+	return s.cache.Peek(k)
+}
+
+// victim returns the least recently used object, the one evicted next
+func (s *CacheStorage[K, V]) victim() (K, bool) {
+	k, _, ok := s.cache.GetOldest()
+	return k, ok
+}
+
+func (s *CacheStorage[K, V]) Remove(k K) bool {
+	old, ok := s.cache.Peek(k)
+	if !ok {
+		return false
+	}
+	s.cache.Remove(k)
+	s.stored -= old
+	return true
+}
+
 func NewCacheStorage[K comparable, V Numeric](size V) (*CacheStorage[K, V], error) {
 	// set size of lru to 1 key. LRU cache will be resized based on size of CacheStorage.
 	// As we need to watch size of stored objects, not the count.
@@ -0,0 +1,142 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+const (
+	// twoQueueInRatio is a part of storage size for the A1in FIFO queue
+	twoQueueInRatio = 0.25
+	// twoQueueOutRatio is a part of storage size remembered by the A1out ghost queue
+	twoQueueOutRatio = 0.5
+)
+
+// TwoQueueStorage is a storage that implements full version of 2Q algorithm.
+// New objects are placed in A1in FIFO queue, evicted ones are remembered
+// in A1out ghost queue. Objects requested again while being remembered by A1out
+// are promoted to the Am LRU queue.
+// Limits of the queues are counted in bytes.
+type TwoQueueStorage[K comparable, V Numeric] struct {
+	in   *byteQueue[K, V] // A1in, FIFO of recently added objects
+	out  *byteQueue[K, V] // A1out, ghost FIFO of objects evicted from A1in
+	main *byteQueue[K, V] // Am, LRU of frequently used objects
+
+	size V
+}
+
+// NewTwoQueueStorage is a constructor for TwoQueueStorage
+func NewTwoQueueStorage[K comparable, V Numeric](size V) *TwoQueueStorage[K, V] {
+	return &TwoQueueStorage[K, V]{
+		in:   newByteQueue[K, V](),
+		out:  newByteQueue[K, V](),
+		main: newByteQueue[K, V](),
+		size: size,
+	}
+}
+
+func (s *TwoQueueStorage[K, V]) Size() V {
+	return s.size
+}
+
+func (s *TwoQueueStorage[K, V]) Stored() V {
+	return s.in.bytes + s.main.bytes
+}
+
+// String returns the name of the eviction policy
+func (s *TwoQueueStorage[K, V]) String() string {
+	return TwoQueuePolicy
+}
+
+// Get returns the object, only objects in Am are reordered on access
+func (s *TwoQueueStorage[K, V]) Get(k K) (V, bool) {
+	if e, ok := s.main.get(k); ok {
+		s.main.moveToFront(k)
+		return e.value, true
+	}
+	if e, ok := s.in.get(k); ok {
+		return e.value, true
+	}
+	return 0, false
+}
+
+// Store stores a value in the cache
+// if the value is bigger than the cache size, it returns false
+// if the value can be stored, it returns if object was nuked.
+func (s *TwoQueueStorage[K, V]) Store(k K, v V) bool {
+	if v > s.size {
+		return false
+	}
+
+	// object remembered by A1out is considered as a frequent one
+	promote := false
+	if _, ok := s.out.remove(k); ok {
+		promote = true
+	}
+	if _, ok := s.main.remove(k); ok {
+		promote = true
+	}
+	s.in.remove(k)
+
+	nuked := false
+	for s.Stored()+v > s.size {
+		s.reclaim()
+		nuked = true
+	}
+
+	e := &queueEntry[K, V]{key: k, value: v}
+	if promote {
+		s.main.pushFront(e)
+	} else {
+		s.in.pushFront(e)
+	}
+
+	return nuked
+}
+
+// reclaim evicts one object, A1in is shrunk while it is over its limit,
+// otherwise the least recently used object from Am is evicted.
+func (s *TwoQueueStorage[K, V]) reclaim() {
+	if s.in.bytes > fraction(s.size, twoQueueInRatio) || s.main.Len() == 0 {
+		if e, ok := s.in.popBack(); ok {
+			s.out.pushFront(e)
+			s.out.trim(fraction(s.size, twoQueueOutRatio))
+			return
+		}
+	}
+	s.main.popBack()
+}
+
+// victim returns the object evicted next, the oldest one of the queue reclaimed first
+func (s *TwoQueueStorage[K, V]) victim() (K, bool) {
+	q := s.main
+	if s.in.bytes > fraction(s.size, twoQueueInRatio) || s.main.Len() == 0 {
+		q = s.in
+	}
+	if e, ok := q.back(); ok {
+		return e.key, true
+	}
+	var k K
+	return k, false
+}
+
+// Peek returns the object without moving it between queues
+func (s *TwoQueueStorage[K, V]) Peek(k K) (V, bool) {
+	if e, ok := s.main.get(k); ok {
+		return e.value, true
+	}
+	if e, ok := s.in.get(k); ok {
+		return e.value, true
+	}
+	return 0, false
+}
+
+// Remove removes the object, it is not remembered by A1out
+func (s *TwoQueueStorage[K, V]) Remove(k K) bool {
+	_, inMain := s.main.remove(k)
+	_, inIn := s.in.remove(k)
+	return inMain || inIn
+}
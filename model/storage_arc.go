@@ -0,0 +1,180 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+// ARCStorage is a storage that implements Adaptive Replacement Cache.
+// T1 holds objects seen once recently, T2 objects seen at least twice,
+// B1 and B2 are ghost queues of objects evicted from T1 and T2.
+// Target size of T1 (p) adapts on ghost hits.
+// Byte-aware variant: sizes of the queues and p are counted in bytes,
+// adaptation step is scaled by the size of the object.
+type ARCStorage[K comparable, V Numeric] struct {
+	t1 *byteQueue[K, V]
+	t2 *byteQueue[K, V]
+	b1 *byteQueue[K, V]
+	b2 *byteQueue[K, V]
+
+	// p is a target size of T1 in bytes
+	p V
+
+	size V
+}
+
+// NewARCStorage is a constructor for ARCStorage
+func NewARCStorage[K comparable, V Numeric](size V) *ARCStorage[K, V] {
+	return &ARCStorage[K, V]{
+		t1:   newByteQueue[K, V](),
+		t2:   newByteQueue[K, V](),
+		b1:   newByteQueue[K, V](),
+		b2:   newByteQueue[K, V](),
+		size: size,
+	}
+}
+
+func (s *ARCStorage[K, V]) Size() V {
+	return s.size
+}
+
+func (s *ARCStorage[K, V]) Stored() V {
+	return s.t1.bytes + s.t2.bytes
+}
+
+// String returns the name of the eviction policy
+func (s *ARCStorage[K, V]) String() string {
+	return ARCPolicy
+}
+
+// Get returns the object, any hit moves the object to the front of T2
+func (s *ARCStorage[K, V]) Get(k K) (V, bool) {
+	if e, ok := s.t1.remove(k); ok {
+		s.t2.pushFront(e)
+		return e.value, true
+	}
+	if e, ok := s.t2.get(k); ok {
+		s.t2.moveToFront(k)
+		return e.value, true
+	}
+	return 0, false
+}
+
+// Store stores a value in the cache
+// if the value is bigger than the cache size, it returns false
+// if the value can be stored, it returns if object was nuked.
+func (s *ARCStorage[K, V]) Store(k K, v V) bool {
+	if v > s.size {
+		return false
+	}
+
+	// replacing a resident object keeps it in T2
+	frequent := false
+	if _, ok := s.t1.remove(k); ok {
+		frequent = true
+	}
+	if _, ok := s.t2.remove(k); ok {
+		frequent = true
+	}
+
+	inB2 := false
+	if _, ok := s.b1.remove(k); ok {
+		// ghost hit in B1, recency is favoured
+		s.p = minNumeric(s.size, s.p+s.delta(v, s.b2.bytes, s.b1.bytes+v))
+		frequent = true
+	} else if _, ok := s.b2.remove(k); ok {
+		// ghost hit in B2, frequency is favoured
+		d := s.delta(v, s.b1.bytes, s.b2.bytes+v)
+		if d > s.p {
+			s.p = 0
+		} else {
+			s.p -= d
+		}
+		frequent = true
+		inB2 = true
+	} else {
+		// completely new object, keep directories within their limits
+		for s.t1.bytes+s.b1.bytes+v > s.size && s.b1.Len() > 0 {
+			s.b1.popBack()
+		}
+		for s.t1.bytes+s.t2.bytes+s.b1.bytes+s.b2.bytes+v > 2*s.size && s.b2.Len() > 0 {
+			s.b2.popBack()
+		}
+	}
+
+	nuked := false
+	for s.Stored()+v > s.size {
+		s.replace(inB2)
+		nuked = true
+	}
+
+	e := &queueEntry[K, V]{key: k, value: v}
+	if frequent {
+		s.t2.pushFront(e)
+	} else {
+		s.t1.pushFront(e)
+	}
+
+	return nuked
+}
+
+// Peek returns the object without moving it between queues
+func (s *ARCStorage[K, V]) Peek(k K) (V, bool) {
+	if e, ok := s.t1.get(k); ok {
+		return e.value, true
+	}
+	if e, ok := s.t2.get(k); ok {
+		return e.value, true
+	}
+	return 0, false
+}
+
+// Remove removes the object, it is not remembered by ghost queues
+func (s *ARCStorage[K, V]) Remove(k K) bool {
+	_, inT1 := s.t1.remove(k)
+	_, inT2 := s.t2.remove(k)
+	return inT1 || inT2
+}
+
+// delta returns adaptation step of p scaled by the size of the object
+func (s *ARCStorage[K, V]) delta(v, other, own V) V {
+	if own <= 0 || other <= own {
+		return v
+	}
+	return V(float64(v) * float64(other) / float64(own))
+}
+
+// replace evicts one object either from T1 or T2 to the corresponding ghost queue
+func (s *ARCStorage[K, V]) replace(inB2 bool) {
+	if s.t1.Len() > 0 && (s.t1.bytes > s.p || (inB2 && s.t1.bytes == s.p) || s.t2.Len() == 0) {
+		e, _ := s.t1.popBack()
+		s.b1.pushFront(e)
+		return
+	}
+	if e, ok := s.t2.popBack(); ok {
+		s.b2.pushFront(e)
+	}
+}
+
+// victim returns the object evicted next by replace of a new object
+func (s *ARCStorage[K, V]) victim() (K, bool) {
+	q := s.t2
+	if s.t1.Len() > 0 && (s.t1.bytes > s.p || s.t2.Len() == 0) {
+		q = s.t1
+	}
+	if e, ok := q.back(); ok {
+		return e.key, true
+	}
+	var k K
+	return k, false
+}
+
+func minNumeric[V Numeric](a, b V) V {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,163 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import "container/heap"
+
+// lfuEntry is an object stored in LFUStorage
+type lfuEntry[K comparable, V Numeric] struct {
+	key   K
+	value V
+
+	// frequency of accesses to the object
+	freq int
+	// tick of the last access, breaks ties between objects with the same frequency
+	tick uint64
+	// position in the heap
+	index int
+}
+
+// lfuHeap is a min-heap of entries, the least frequently used one on top
+type lfuHeap[K comparable, V Numeric] []*lfuEntry[K, V]
+
+func (h lfuHeap[K, V]) Len() int { return len(h) }
+
+func (h lfuHeap[K, V]) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].tick < h[j].tick
+	}
+	return h[i].freq < h[j].freq
+}
+
+func (h lfuHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K, V]) Push(x any) {
+	e := x.(*lfuEntry[K, V])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// LFUStorage is a storage that evicts the least frequently used objects,
+// objects with the same frequency are evicted in LRU order.
+type LFUStorage[K comparable, V Numeric] struct {
+	entries map[K]*lfuEntry[K, V]
+	heap    lfuHeap[K, V]
+	tick    uint64
+
+	size   V
+	stored V
+}
+
+// NewLFUStorage is a constructor for LFUStorage
+func NewLFUStorage[K comparable, V Numeric](size V) *LFUStorage[K, V] {
+	return &LFUStorage[K, V]{
+		entries: make(map[K]*lfuEntry[K, V]),
+		size:    size,
+	}
+}
+
+func (s *LFUStorage[K, V]) Size() V {
+	return s.size
+}
+
+func (s *LFUStorage[K, V]) Stored() V {
+	return s.stored
+}
+
+// String returns the name of the eviction policy
+func (s *LFUStorage[K, V]) String() string {
+	return LFUPolicy
+}
+
+func (s *LFUStorage[K, V]) Get(k K) (V, bool) {
+	e, ok := s.entries[k]
+	if !ok {
+		return 0, false
+	}
+
+	s.tick++
+	e.freq++
+	e.tick = s.tick
+	heap.Fix(&s.heap, e.index)
+
+	return e.value, true
+}
+
+// Store stores a value in the cache
+// if the value is bigger than the cache size, it returns false
+// if the value can be stored, it returns if object was nuked.
+func (s *LFUStorage[K, V]) Store(k K, v V) bool {
+	if v > s.size {
+		return false
+	}
+
+	// replacing an object keeps its frequency
+	freq := 1
+	if e, ok := s.entries[k]; ok {
+		freq = e.freq
+		s.Remove(k)
+	}
+
+	nuked := false
+	for s.stored+v > s.size {
+		e := heap.Pop(&s.heap).(*lfuEntry[K, V])
+		delete(s.entries, e.key)
+		s.stored -= e.value
+		nuked = true
+	}
+
+	s.tick++
+	e := &lfuEntry[K, V]{key: k, value: v, freq: freq, tick: s.tick}
+	heap.Push(&s.heap, e)
+	s.entries[k] = e
+	s.stored += v
+
+	return nuked
+}
+
+func (s *LFUStorage[K, V]) Peek(k K) (V, bool) {
+	e, ok := s.entries[k]
+	if !ok {
+		return 0, false
+	}
+	return e.value, true
+}
+
+// victim returns the least frequently used object, the one evicted next
+func (s *LFUStorage[K, V]) victim() (K, bool) {
+	if len(s.heap) == 0 {
+		var k K
+		return k, false
+	}
+	return s.heap[0].key, true
+}
+
+func (s *LFUStorage[K, V]) Remove(k K) bool {
+	e, ok := s.entries[k]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.heap, e.index)
+	delete(s.entries, k)
+	s.stored -= e.value
+	return true
+}
@@ -0,0 +1,310 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+)
+
+// never is the next use of an object, that is not requested again
+const never = math.MaxInt
+
+// Future is positions of requests of each key in the trace, it is known only offline,
+// after the trace is scanned before the simulation
+type Future struct {
+	uses   map[string][]int
+	length int
+}
+
+// NewFuture is a constructor for Future
+func NewFuture() *Future {
+	return &Future{uses: make(map[string][]int)}
+}
+
+// Record appends a request of the key to the trace
+func (f *Future) Record(key string) {
+	f.uses[key] = append(f.uses[key], f.length)
+	f.length++
+}
+
+// Len returns an amount of requests in the trace
+func (f *Future) Len() int {
+	return f.length
+}
+
+// NextUse returns a position of the next request of the key after the position seq,
+// never if the key is not requested again
+func (f *Future) NextUse(key string, seq int) int {
+	if f == nil {
+		return never
+	}
+	uses := f.uses[key]
+	lo, hi := 0, len(uses)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if uses[mid] <= seq {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(uses) {
+		return never
+	}
+	return uses[lo]
+}
+
+// sequenced is a storage, that needs to know the position in the trace
+// of the request being served
+type sequenced interface {
+	seek(seq int)
+}
+
+// seek passes the position of the request to the storage, if it needs it
+func seek[K comparable, V Numeric](s Storage[K, V], seq int) {
+	if s, ok := s.(sequenced); ok {
+		s.seek(seq)
+	}
+}
+
+// foreseeing is a storage, that looks next uses of objects up in the future of the trace
+type foreseeing interface {
+	foresee(f *Future)
+}
+
+// foresee passes the future of the trace to the storage, if it needs it
+func foresee[K comparable, V Numeric](s Storage[K, V], f *Future) {
+	if s, ok := s.(foreseeing); ok {
+		s.foresee(f)
+	}
+}
+
+// optimalEntry is an object stored in OptimalStorage
+type optimalEntry[K comparable, V Numeric] struct {
+	key   K
+	value V
+
+	// next is a position of the next request of the object
+	next int
+	// positions in the heaps of entries
+	victimIndex int
+	nextIndex   int
+}
+
+// priority returns the priority of the object to be evicted, the product of its next use
+// and its size, objects never reused are evicted first
+func (e *optimalEntry[K, V]) priority() float64 {
+	if e.next == never {
+		return math.Inf(1)
+	}
+	return float64(e.next) * float64(e.value)
+}
+
+// optimalHeap is a heap of entries of OptimalStorage ordered by the priority to be evicted,
+// or by the next use, to find the objects, which next uses have passed
+type optimalHeap[K comparable, V Numeric] struct {
+	entries []*optimalEntry[K, V]
+	byNext  bool
+}
+
+func (h *optimalHeap[K, V]) Len() int {
+	return len(h.entries)
+}
+
+func (h *optimalHeap[K, V]) Less(i, j int) bool {
+	if h.byNext {
+		return h.entries[i].next < h.entries[j].next
+	}
+	return h.entries[i].priority() > h.entries[j].priority()
+}
+
+func (h *optimalHeap[K, V]) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	*h.index(h.entries[i]) = i
+	*h.index(h.entries[j]) = j
+}
+
+func (h *optimalHeap[K, V]) Push(x any) {
+	e := x.(*optimalEntry[K, V])
+	*h.index(e) = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *optimalHeap[K, V]) Pop() any {
+	e := h.entries[len(h.entries)-1]
+	h.entries[len(h.entries)-1] = nil
+	h.entries = h.entries[:len(h.entries)-1]
+	return e
+}
+
+// index returns the position of the entry in the heap
+func (h *optimalHeap[K, V]) index(e *optimalEntry[K, V]) *int {
+	if h.byNext {
+		return &e.nextIndex
+	}
+	return &e.victimIndex
+}
+
+// top returns the first entry of the heap
+func (h *optimalHeap[K, V]) top() *optimalEntry[K, V] {
+	return h.entries[0]
+}
+
+// OptimalStorage is the offline optimal storage by Belady, it evicts the object
+// reused furthest in the future. As objects differ in size, it is Belady-Size:
+// the object with the largest product of its next use and its size is evicted.
+// Next uses are the ones of the whole trace, not of the requests the storage sees, so
+// it is an approximation for storages, that see only a part of the requests of their objects
+// (e.g. proxies behind a round-robin balancer or of an inner layer): next uses served
+// by other proxies look closer than they are. They are refreshed, when they have passed,
+// and Accuracy reports how often the next use predicted was the request of the storage.
+// Without the future of the trace all objects look never reused.
+type OptimalStorage[K comparable, V Numeric] struct {
+	entries map[K]*optimalEntry[K, V]
+	// victims orders entries by the priority to be evicted, nexts by their next uses
+	victims optimalHeap[K, V]
+	nexts   optimalHeap[K, V]
+	future  *Future
+	// seq is a position in the trace of the request being served
+	seq int
+	// found counts objects found in the storage, predicted counts those,
+	// which request was the next use predicted by the future
+	found     int
+	predicted int
+
+	size   V
+	stored V
+}
+
+// NewOptimalStorage is a constructor for OptimalStorage,
+// future is the one of the trace, nil if it was not scanned
+func NewOptimalStorage[K comparable, V Numeric](size V, future *Future) *OptimalStorage[K, V] {
+	return &OptimalStorage[K, V]{
+		entries: make(map[K]*optimalEntry[K, V]),
+		nexts:   optimalHeap[K, V]{byNext: true},
+		future:  future,
+		size:    size,
+	}
+}
+
+func (s *OptimalStorage[K, V]) seek(seq int) {
+	s.seq = seq
+}
+
+// foresee sets the future of the trace, next uses of stored objects are looked up again
+func (s *OptimalStorage[K, V]) foresee(f *Future) {
+	s.future = f
+	for _, e := range s.entries {
+		s.refresh(e)
+	}
+}
+
+// nextUse returns the next use of the key in the trace after the request being served
+func (s *OptimalStorage[K, V]) nextUse(k K) int {
+	if key, ok := any(k).(string); ok {
+		return s.future.NextUse(key, s.seq)
+	}
+	return s.future.NextUse(fmt.Sprint(k), s.seq)
+}
+
+// refresh looks the next use of the object up again and reorders it
+func (s *OptimalStorage[K, V]) refresh(e *optimalEntry[K, V]) {
+	e.next = s.nextUse(e.key)
+	heap.Fix(&s.victims, e.victimIndex)
+	heap.Fix(&s.nexts, e.nextIndex)
+}
+
+func (s *OptimalStorage[K, V]) Size() V {
+	return s.size
+}
+
+func (s *OptimalStorage[K, V]) Stored() V {
+	return s.stored
+}
+
+// String returns the name of the eviction policy
+func (s *OptimalStorage[K, V]) String() string {
+	return OptimalPolicy
+}
+
+func (s *OptimalStorage[K, V]) Get(k K) (V, bool) {
+	e, ok := s.entries[k]
+	if !ok {
+		return 0, false
+	}
+	s.found++
+	if e.next == s.seq {
+		s.predicted++
+	}
+	s.refresh(e)
+	return e.value, true
+}
+
+// Accuracy returns a share of objects found, which request was the next use predicted,
+// it is lower, when the storage does not see all requests of its objects
+func (s *OptimalStorage[K, V]) Accuracy() float64 {
+	return ratio(s.predicted, s.found)
+}
+
+// Store stores a value in the cache
+// if the value is bigger than the cache size, it returns false
+// if the value can be stored, it returns if object was nuked.
+func (s *OptimalStorage[K, V]) Store(k K, v V) bool {
+	if v > s.size {
+		return false
+	}
+	s.Remove(k)
+
+	nuked := false
+	for s.stored+v > s.size {
+		s.Remove(s.victim().key)
+		nuked = true
+	}
+
+	e := &optimalEntry[K, V]{key: k, value: v, next: s.nextUse(k)}
+	heap.Push(&s.victims, e)
+	heap.Push(&s.nexts, e)
+	s.entries[k] = e
+	s.stored += v
+
+	return nuked
+}
+
+// victim returns the object to evict, the one never reused or reused furthest
+// in the future weighted by its size
+func (s *OptimalStorage[K, V]) victim() *optimalEntry[K, V] {
+	// objects requested by requests this storage has not seen
+	for s.nexts.top().next <= s.seq {
+		s.refresh(s.nexts.top())
+	}
+	return s.victims.top()
+}
+
+func (s *OptimalStorage[K, V]) Peek(k K) (V, bool) {
+	e, ok := s.entries[k]
+	if !ok {
+		return 0, false
+	}
+	return e.value, true
+}
+
+func (s *OptimalStorage[K, V]) Remove(k K) bool {
+	e, ok := s.entries[k]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.victims, e.victimIndex)
+	heap.Remove(&s.nexts, e.nextIndex)
+
+	delete(s.entries, k)
+	s.stored -= e.value
+	return true
+}
@@ -0,0 +1,182 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+// futureOf returns the future of the trace of the keys
+func futureOf(keys ...string) *Future {
+	f := NewFuture()
+	for _, key := range keys {
+		f.Record(key)
+	}
+	return f
+}
+
+func TestFutureNextUse(t *testing.T) {
+	f := NewFuture()
+	for _, key := range []string{"a", "b", "a", "c", "a"} {
+		f.Record(key)
+	}
+
+	if f.Len() != 5 {
+		t.Fatalf("error: future of %d requests instead of 5", f.Len())
+	}
+	for _, c := range []struct {
+		key  string
+		seq  int
+		next int
+	}{{"a", 0, 2}, {"a", 2, 4}, {"a", 4, never}, {"b", 0, 1}, {"b", 1, never}, {"d", 0, never}} {
+		if next := f.NextUse(c.key, c.seq); next != c.next {
+			t.Fatalf("error: next use of %s after %d is %d instead of %d", c.key, c.seq, next, c.next)
+		}
+	}
+}
+
+func TestOptimalEvictsFurthest(t *testing.T) {
+	future := futureOf("a", "b", "c", "d", "e", "a", "c", "b")
+	store := NewOptimalStorage[string, int](30, future)
+
+	for seq, key := range []string{"a", "b", "c", "d"} {
+		store.seek(seq)
+		if _, ok := store.Get(key); !ok {
+			store.Store(key, 10)
+		}
+	}
+
+	// d is never reused and b is reused after a and c
+	if _, ok := store.Peek("d"); !ok {
+		t.Fatalf("error: d should be stored, as it was just stored")
+	}
+	if _, ok := store.Peek("b"); ok {
+		t.Fatalf("error: b reused furthest in the future should be evicted")
+	}
+
+	store.seek(4)
+	store.Store("e", 10)
+	if _, ok := store.Peek("d"); ok {
+		t.Fatalf("error: d never reused should be evicted")
+	}
+}
+
+func TestOptimalSizeAware(t *testing.T) {
+	future := futureOf("small", "big", "new", "big", "small")
+	store := NewOptimalStorage[string, int](100, future)
+
+	store.seek(0)
+	store.Store("small", 10)
+	store.seek(1)
+	store.Store("big", 80)
+
+	// big is reused sooner, but it frees the space of 8 small objects
+	store.seek(2)
+	store.Store("new", 20)
+	if _, ok := store.Peek("big"); ok {
+		t.Fatalf("error: big object should be evicted, as its distance weighted by size is the largest")
+	}
+	if _, ok := store.Peek("small"); !ok {
+		t.Fatalf("error: small object should be kept")
+	}
+}
+
+func TestOptimalExactVictim(t *testing.T) {
+	// 200 objects of the same size, each reused once in the reverse order after a new one
+	keys := make([]string, 0)
+	for i := 0; i < 200; i++ {
+		keys = append(keys, fmt.Sprintf("/%d", i))
+	}
+	keys = append(keys, "/new")
+	for i := 199; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("/%d", i))
+	}
+	store := NewOptimalStorage[string, int](2000, futureOf(keys...))
+
+	for seq := 0; seq < 200; seq++ {
+		store.seek(seq)
+		store.Store(keys[seq], 10)
+	}
+	// /0 is reused furthest, a sample of the objects would miss it most of the time
+	store.seek(200)
+	store.Store("/new", 10)
+	if _, ok := store.Peek("/0"); ok {
+		t.Fatalf("error: /0 reused furthest should be evicted")
+	}
+	for i := 1; i < 200; i++ {
+		if _, ok := store.Peek(fmt.Sprintf("/%d", i)); !ok {
+			t.Fatalf("error: /%d should be kept", i)
+		}
+	}
+}
+
+func TestOptimalGap(t *testing.T) {
+	keys := make([]string, 0)
+	for i := 0; i < 30; i++ {
+		keys = append(keys, []string{"/a", "/b", "/c"}[i%3])
+	}
+	future := futureOf(keys...)
+
+	proxy, err := NewVarnishProxy("proxy", 20, LRUPolicy)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	proxy.SetBackend(&Backend{Hostname: "default"}).SetOptimal(true).SetFuture(future)
+	proxy.warmuped = true
+
+	for seq, key := range keys {
+		req := NewRequest(key, 10)
+		req.Seq = seq
+		proxy.Get(req)
+	}
+
+	// LRU of two objects misses the loop of three, the optimal storage keeps one of them
+	optimal := proxy.Export()["proxy"].(map[string]interface{})["optimal"].(map[string]float64)
+	if proxy.cacheMetric.CHR() != 0 {
+		t.Fatalf("error: LRU hit ratio is %f instead of 0", proxy.cacheMetric.CHR())
+	}
+	if optimal["hit_ratio"] < 0.4 || optimal["hit_ratio_gap"] != optimal["hit_ratio"] {
+		t.Fatalf("error: unexpected gap to optimal %v", optimal)
+	}
+	if optimal["future_accuracy"] != 1 {
+		t.Fatalf("error: proxy seeing the whole trace has future accuracy %f", optimal["future_accuracy"])
+	}
+}
+
+func TestOptimalTwoProxies(t *testing.T) {
+	keys := make([]string, 0)
+	for i := 0; i < 30; i++ {
+		keys = append(keys, []string{"/a", "/b", "/c"}[i%3])
+	}
+	future := futureOf(keys...)
+
+	proxies := make([]*VarnishProxy, 0)
+	for _, name := range []string{"proxy-0", "proxy-1"} {
+		proxy, err := NewVarnishProxy(name, 20, LRUPolicy)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		proxy.SetBackend(&Backend{Hostname: "default"}).SetOptimal(true).SetFuture(future)
+		proxy.warmuped = true
+		proxies = append(proxies, proxy)
+	}
+
+	// each proxy sees every other request, next uses of the trace are the ones of the other proxy
+	for seq, key := range keys {
+		req := NewRequest(key, 10)
+		req.Seq = seq
+		proxies[seq%2].Get(req)
+	}
+	for _, proxy := range proxies {
+		if accuracy := proxy.optimal.storage.Accuracy(); accuracy >= 0.5 {
+			t.Fatalf("error: %s seeing a part of the trace has future accuracy %f", proxy, accuracy)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+const (
+	// s3fifoSmallRatio is a part of storage size for the small FIFO queue
+	s3fifoSmallRatio = 0.1
+	// s3fifoMaxFreq is a cap of the access counter of an object
+	s3fifoMaxFreq = 3
+)
+
+// S3FIFOStorage is a storage that implements S3-FIFO algorithm.
+// New objects are placed in a small FIFO queue, objects accessed more than once
+// while being there are moved to the main FIFO queue, others are evicted
+// and remembered by a ghost queue. Objects found in the ghost queue
+// are inserted directly to the main queue.
+// Main queue evicts objects with a CLOCK-like second chance.
+type S3FIFOStorage[K comparable, V Numeric] struct {
+	small *byteQueue[K, V]
+	main  *byteQueue[K, V]
+	ghost *byteQueue[K, V]
+
+	size V
+}
+
+// NewS3FIFOStorage is a constructor for S3FIFOStorage
+func NewS3FIFOStorage[K comparable, V Numeric](size V) *S3FIFOStorage[K, V] {
+	return &S3FIFOStorage[K, V]{
+		small: newByteQueue[K, V](),
+		main:  newByteQueue[K, V](),
+		ghost: newByteQueue[K, V](),
+		size:  size,
+	}
+}
+
+func (s *S3FIFOStorage[K, V]) Size() V {
+	return s.size
+}
+
+func (s *S3FIFOStorage[K, V]) Stored() V {
+	return s.small.bytes + s.main.bytes
+}
+
+// String returns the name of the eviction policy
+func (s *S3FIFOStorage[K, V]) String() string {
+	return S3FIFOPolicy
+}
+
+// Get returns the object, access only increments its counter
+func (s *S3FIFOStorage[K, V]) Get(k K) (V, bool) {
+	e, ok := s.small.get(k)
+	if !ok {
+		e, ok = s.main.get(k)
+	}
+	if !ok {
+		return 0, false
+	}
+
+	if e.freq < s3fifoMaxFreq {
+		e.freq++
+	}
+	return e.value, true
+}
+
+// Store stores a value in the cache
+// if the value is bigger than the cache size, it returns false
+// if the value can be stored, it returns if object was nuked.
+func (s *S3FIFOStorage[K, V]) Store(k K, v V) bool {
+	if v > s.size {
+		return false
+	}
+
+	// replacing a resident object keeps it in its queue
+	freq := 0
+	_, toMain := s.ghost.remove(k)
+	if e, ok := s.small.remove(k); ok {
+		freq = e.freq
+	} else if e, ok := s.main.remove(k); ok {
+		freq = e.freq
+		toMain = true
+	}
+
+	nuked := false
+	for s.Stored()+v > s.size {
+		s.evict()
+		nuked = true
+	}
+
+	e := &queueEntry[K, V]{key: k, value: v, freq: freq}
+	if toMain {
+		s.main.pushFront(e)
+	} else {
+		s.small.pushFront(e)
+	}
+
+	return nuked
+}
+
+// Peek returns the object without counting an access to it
+func (s *S3FIFOStorage[K, V]) Peek(k K) (V, bool) {
+	e, ok := s.small.get(k)
+	if !ok {
+		e, ok = s.main.get(k)
+	}
+	if !ok {
+		return 0, false
+	}
+	return e.value, true
+}
+
+// Remove removes the object, it is not remembered by the ghost queue
+func (s *S3FIFOStorage[K, V]) Remove(k K) bool {
+	_, inSmall := s.small.remove(k)
+	_, inMain := s.main.remove(k)
+	return inSmall || inMain
+}
+
+// evict evicts one object, from the small queue while it is over its limit
+func (s *S3FIFOStorage[K, V]) evict() {
+	if s.small.bytes >= fraction(s.size, s3fifoSmallRatio) || s.main.Len() == 0 {
+		if s.evictSmall() {
+			return
+		}
+	}
+	s.evictMain()
+}
+
+// evictSmall moves objects accessed more than once to the main queue,
+// until one object is evicted. Returns false if nothing was evicted.
+func (s *S3FIFOStorage[K, V]) evictSmall() bool {
+	for {
+		e, ok := s.small.popBack()
+		if !ok {
+			return false
+		}
+		if e.freq > 1 {
+			e.freq = 0
+			s.main.pushFront(e)
+			continue
+		}
+
+		s.ghost.pushFront(&queueEntry[K, V]{key: e.key, value: e.value})
+		s.ghost.trim(s.size - fraction(s.size, s3fifoSmallRatio))
+		return true
+	}
+}
+
+// evictMain gives a second chance to objects that were accessed,
+// evicts the first one with zero counter.
+func (s *S3FIFOStorage[K, V]) evictMain() {
+	for {
+		e, ok := s.main.popBack()
+		if !ok {
+			return
+		}
+		if e.freq > 0 {
+			e.freq--
+			s.main.pushFront(e)
+			continue
+		}
+		return
+	}
+}
+
+// victim returns the oldest object of the queue evicted from first, objects given
+// a second chance on eviction are not skipped, so it is only a candidate
+func (s *S3FIFOStorage[K, V]) victim() (K, bool) {
+	q := s.main
+	if s.small.bytes >= fraction(s.size, s3fifoSmallRatio) || s.main.Len() == 0 {
+		q = s.small
+	}
+	if e, ok := q.back(); ok {
+		return e.key, true
+	}
+	var k K
+	return k, false
+}
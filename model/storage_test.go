@@ -97,3 +97,153 @@ func TestLRU2(t *testing.T) {
 		}
 	}
 }
+
+func TestPoliciesRespectSize(t *testing.T) {
+	for _, policy := range EvictionPolicies() {
+		store, err := NewStorage[string, int](policy, 100)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key%d", i%37)
+			if _, ok := store.Get(key); !ok {
+				store.Store(key, 1+i%13)
+			}
+
+			if store.Stored() > store.Size() {
+				t.Fatalf("error: %s stored %v over size %v", policy, store.Stored(), store.Size())
+			}
+		}
+
+		if store.Store("huge", 101) {
+			t.Fatalf("error: %s should not store object bigger than storage", policy)
+		}
+
+		if store.String() != policy {
+			t.Fatalf("error: %s reports policy %s", policy, store.String())
+		}
+	}
+}
+
+func TestPoliciesReplace(t *testing.T) {
+	for _, policy := range EvictionPolicies() {
+		store, err := NewStorage[string, int](policy, 100)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		store.Store("key", 10)
+		store.Store("key", 30)
+
+		if store.Stored() != 30 {
+			t.Fatalf("error: %s stored %v instead of 30 after replace", policy, store.Stored())
+		}
+		if v, ok := store.Get("key"); !ok || v != 30 {
+			t.Fatalf("error: %s returned %v, %v for replaced key", policy, v, ok)
+		}
+	}
+}
+
+func TestPoliciesPeek(t *testing.T) {
+	for _, policy := range EvictionPolicies() {
+		store, err := NewStorage[string, int](policy, 100)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		store.Store("a", 40)
+		store.Store("b", 40)
+		if v, ok := store.Peek("a"); !ok || v != 40 {
+			t.Fatalf("error: %s peeked %v, %v for stored key", policy, v, ok)
+		}
+
+		// peek does not count as an access, the oldest object is evicted
+		store.Store("c", 40)
+		if _, ok := store.Peek("a"); ok {
+			t.Fatalf("error: %s kept peeked object instead of evicting it", policy)
+		}
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := NewStorage[string, int]("mru", 100); err == nil {
+		t.Fatalf("error: unknown policy should not be created")
+	}
+}
+
+func TestLFU(t *testing.T) {
+	store := NewLFUStorage[string, int](30)
+
+	store.Store("key0", 10)
+	store.Store("key1", 10)
+	store.Store("key2", 10)
+	store.Get("key0")
+	store.Get("key2")
+
+	store.Store("key3", 10)
+
+	if _, ok := store.Get("key1"); ok {
+		t.Fatalf("error: key1 should be removed as least frequently used")
+	}
+	for _, k := range []string{"key0", "key2", "key3"} {
+		if _, ok := store.Get(k); !ok {
+			t.Fatalf("error: %s should be stored", k)
+		}
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// popular objects requested repeatedly should survive a scan
+	// of one-hit objects with policies that are not just recency based
+	for _, policy := range []string{LFUPolicy, ARCPolicy, S3FIFOPolicy} {
+		store, err := NewStorage[string, int](policy, 100)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 5; i++ {
+				key := fmt.Sprintf("hot%d", i)
+				if _, ok := store.Get(key); !ok {
+					store.Store(key, 10)
+				}
+			}
+		}
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("scan%d", i)
+			if _, ok := store.Get(key); !ok {
+				store.Store(key, 10)
+			}
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, ok := store.Get(fmt.Sprintf("hot%d", i)); !ok {
+				t.Fatalf("error: %s evicted hot%d during scan", policy, i)
+			}
+		}
+	}
+}
+
+func TestTwoQueuePromotion(t *testing.T) {
+	store := NewTwoQueueStorage[string, int](100)
+
+	// hot is evicted from A1in, remembered by A1out and promoted on the next store
+	store.Store("hot", 10)
+	for i := 0; i < 10; i++ {
+		store.Store(fmt.Sprintf("key%d", i), 10)
+	}
+	if _, ok := store.Get("hot"); ok {
+		t.Fatalf("error: hot should be evicted from A1in")
+	}
+	store.Store("hot", 10)
+
+	for i := 0; i < 100; i++ {
+		store.Store(fmt.Sprintf("scan%d", i), 10)
+	}
+
+	if _, ok := store.Get("hot"); !ok {
+		t.Fatalf("error: hot should be kept in Am")
+	}
+}
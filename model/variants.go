@@ -0,0 +1,93 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package model
+
+// variants tracks cache keys of variants of objects, whose responses vary,
+// to tell how much space variants take and how many misses they cause.
+// Storage evicts variants on its own, so evicted ones are forgotten lazily.
+type variants struct {
+	// keys holds cache keys of variants stored by hash keys of the objects
+	keys map[string]map[string]bool
+	// tracked is an amount of keys, pruning prunes evicted ones
+	tracked int
+	pruning lazyPrune
+}
+
+func newVariants() *variants {
+	return &variants{
+		keys: make(map[string]map[string]bool),
+	}
+}
+
+// add starts tracking the variant stored in the cache
+func (v *variants) add(hash, key string, cache Storage[string, int]) {
+	keys, ok := v.keys[hash]
+	if !ok {
+		keys = make(map[string]bool)
+		v.keys[hash] = keys
+	}
+	if keys[key] {
+		return
+	}
+	keys[key] = true
+	v.tracked++
+
+	if v.pruning.due(v.tracked) {
+		v.prune(cache)
+	}
+}
+
+// cachedOther returns if another variant of the object is stored in the cache,
+// so the request would be a hit, if the response did not vary
+func (v *variants) cachedOther(hash, key string, cache Storage[string, int]) bool {
+	for other := range v.keys[hash] {
+		if other == key {
+			continue
+		}
+		if _, ok := cache.Peek(other); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// usage returns an amount of variants stored besides the largest one of each object
+// and bytes they take, as the space that would be free, if responses did not vary
+func (v *variants) usage(cache Storage[string, int]) (count, bytes int) {
+	v.prune(cache)
+	for _, keys := range v.keys {
+		total, largest := 0, 0
+		for key := range keys {
+			size, _ := cache.Peek(key)
+			total += size
+			if size > largest {
+				largest = size
+			}
+		}
+		count += len(keys) - 1
+		bytes += total - largest
+	}
+	return count, bytes
+}
+
+// prune forgets variants evicted from the cache
+func (v *variants) prune(cache Storage[string, int]) {
+	for hash, keys := range v.keys {
+		for key := range keys {
+			if _, ok := cache.Peek(key); !ok {
+				delete(keys, key)
+				v.tracked--
+			}
+		}
+		if len(keys) == 0 {
+			delete(v.keys, hash)
+		}
+	}
+	v.pruning.pruned(v.tracked)
+}
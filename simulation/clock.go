@@ -0,0 +1,44 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"time"
+	"varnish_sim/simulation/providers"
+)
+
+// untimedTick is a time between requests of a trace without timestamps
+const untimedTick = time.Second
+
+// Clock is a virtual clock of the simulation, advanced by timestamps of the trace.
+type Clock struct {
+	now time.Time
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Observe advances the clock to the timestamp of the request and stamps it.
+// Clock never goes back, requests out of order are stamped with the current time.
+// Requests without timestamp advance the clock by untimedTick.
+func (c *Clock) Observe(req *providers.Request) time.Time {
+	switch {
+	case req.Timestamp.IsZero() && c.now.IsZero():
+		c.now = time.Unix(0, 0)
+	case req.Timestamp.IsZero():
+		c.now = c.now.Add(untimedTick)
+	case req.Timestamp.After(c.now):
+		c.now = req.Timestamp
+	}
+
+	req.Timestamp = c.now
+	return c.now
+}
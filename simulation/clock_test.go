@@ -0,0 +1,46 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"testing"
+	"time"
+	"varnish_sim/simulation/providers"
+)
+
+func TestClockObserve(t *testing.T) {
+	// at returns the time of the second, zero time is a request without timestamp
+	at := func(sec int64) time.Time {
+		return time.Unix(sec, 0)
+	}
+	var untimed time.Time
+
+	tests := []struct {
+		name  string
+		trace []time.Time
+		want  []time.Time
+	}{
+		{"in order", []time.Time{at(10), at(10), at(12)}, []time.Time{at(10), at(10), at(12)}},
+		{"out of order", []time.Time{at(10), at(5), at(12), at(11)}, []time.Time{at(10), at(10), at(12), at(12)}},
+		{"untimed", []time.Time{untimed, untimed, untimed}, []time.Time{at(0), at(1), at(2)}},
+		{"untimed first", []time.Time{untimed, at(100), untimed, at(50)}, []time.Time{at(0), at(100), at(101), at(101)}},
+		{"timed first", []time.Time{at(100), untimed, at(100), at(102)}, []time.Time{at(100), at(101), at(101), at(102)}},
+	}
+	for _, tt := range tests {
+		clock := &Clock{}
+		for i, timestamp := range tt.trace {
+			req := &providers.Request{Url: "/", Timestamp: timestamp}
+			now := clock.Observe(req)
+			if !now.Equal(tt.want[i]) || !req.Timestamp.Equal(now) || !clock.Now().Equal(now) {
+				t.Errorf("%s: request %d is stamped %s, clock is at %s instead of %s",
+					tt.name, i, req.Timestamp.UTC(), now.UTC(), tt.want[i].UTC())
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"varnish_sim/model"
+	"varnish_sim/simulation/providers"
+)
+
+// Failure is a period when nodes fail their health checks.
+// Period is either in client requests, or in time since the start of the trace.
+type Failure struct {
+	// Nodes are hostnames of proxies and origins that are down
+	Nodes []string
+
+	// From is the start of the failure, To is its exclusive end,
+	// nil if nodes are down until the end of the trace
+	From Point
+	To   *Point
+
+	// spec is the failure as it was parsed
+	spec string
+}
+
+// ParseFailure parses a failure in `node[,node...]@from[-to]` format,
+// from and to are either counts of requests (e.g. `proxy-2,proxy-3@1000000-1500000`)
+// or durations since the start of the trace (e.g. `proxy-1@1h-90m`).
+// Nodes are down until the end of the trace, if `to` is omitted.
+func ParseFailure(spec string) (Failure, error) {
+	failure := Failure{spec: spec}
+
+	nodes, period, ok := strings.Cut(spec, "@")
+	if !ok || nodes == "" || period == "" {
+		return failure, fmt.Errorf("failure %q is not in node[,node...]@from[-to] format", spec)
+	}
+	failure.Nodes = strings.Split(nodes, ",")
+
+	from, to, bounded := strings.Cut(period, "-")
+	var err error
+	if failure.From, err = ParsePoint(from); err != nil {
+		return failure, fmt.Errorf("failure %q: %w", spec, err)
+	}
+	if !bounded {
+		return failure, nil
+	}
+
+	end, err := ParsePoint(to)
+	if err != nil {
+		return failure, fmt.Errorf("failure %q: %w", spec, err)
+	}
+	if end.ByTime != failure.From.ByTime || !failure.From.Before(end) {
+		return failure, fmt.Errorf("failure %q: period should end after it starts, both in requests or in time", spec)
+	}
+	failure.To = &end
+
+	return failure, nil
+}
+
+// String returns the failure as it was parsed
+func (f Failure) String() string {
+	return f.spec
+}
+
+// active returns if the failure is active at the request number and time since start
+func (f Failure) active(request int, elapsed time.Duration) bool {
+	return f.From.Reached(request, elapsed) && (f.To == nil || !f.To.Reached(request, elapsed))
+}
+
+// FailureImpact is an origin offload before, during and after the failure,
+// before is counted since the previous change of health of any node
+type FailureImpact struct {
+	Failure Failure
+	Before  model.OriginOffload
+	During  model.OriginOffload
+	After   model.OriginOffload
+
+	started, ended bool
+	// offload at the start and the end of the failure
+	start, end model.OriginOffload
+}
+
+// OriginLoadIncrease returns how many times the share of client requests
+// reaching origins grows during the failure, compared to before it
+func (i *FailureImpact) OriginLoadIncrease() float64 {
+	before := 1 - i.Before.RequestOffload()
+	if before == 0 {
+		return 0
+	}
+	return (1 - i.During.RequestOffload()) / before
+}
+
+func (i *FailureImpact) TableData() (name string, rows [][]string) {
+	name = "Failure"
+	rows = append(rows, []string{"Down", i.Failure.String()})
+	for _, period := range []struct {
+		name    string
+		offload model.OriginOffload
+	}{{"before", i.Before}, {"during", i.During}, {"after", i.After}} {
+		rows = append(rows, []string{"Requests " + period.name, fmt.Sprintf("%d", period.offload.Requests)})
+		rows = append(rows, []string{"Errors " + period.name, fmt.Sprintf("%d", period.offload.Errors)})
+		rows = append(rows, []string{"Offload " + period.name, fmt.Sprintf("%f", period.offload.RequestOffload())})
+		rows = append(rows, []string{"Byte offload " + period.name, fmt.Sprintf("%f", period.offload.ByteOffload())})
+	}
+	rows = append(rows, []string{"Origin load", fmt.Sprintf("x%f", i.OriginLoadIncrease())})
+	return
+}
+
+func (i *FailureImpact) Export() map[string]interface{} {
+	return map[string]interface{}{
+		"failure": map[string]interface{}{
+			"down":                 i.Failure.String(),
+			"before":               i.Before.Export()["offload"],
+			"during":               i.During.Export()["offload"],
+			"after":                i.After.Export()["offload"],
+			"origin_load_increase": i.OriginLoadIncrease(),
+		},
+	}
+}
+
+// FailureSchedule applies failures to nodes of the topology
+// and measures their impact on origin offload
+type FailureSchedule struct {
+	impacts []*FailureImpact
+
+	nodes   map[string]model.HealthChecked
+	proxies []*model.VarnishProxy
+	origins []*model.Backend
+
+	// lastChange is an offload at the last change of health of any node
+	lastChange model.OriginOffload
+}
+
+// NewFailureSchedule resolves nodes of failures among the proxies and origins
+func NewFailureSchedule(failures []Failure, proxies []*model.VarnishProxy, origins []*model.Backend) (*FailureSchedule, error) {
+	s := &FailureSchedule{
+		nodes:   make(map[string]model.HealthChecked),
+		proxies: proxies,
+		origins: origins,
+	}
+	for _, proxy := range proxies {
+		s.nodes[proxy.Hostname()] = proxy
+	}
+	for _, origin := range origins {
+		s.nodes[origin.Hostname] = origin
+	}
+
+	for _, failure := range failures {
+		for _, node := range failure.Nodes {
+			if _, ok := s.nodes[node]; !ok {
+				return nil, fmt.Errorf("failure %q: unknown node %q", failure, node)
+			}
+		}
+		s.impacts = append(s.impacts, &FailureImpact{Failure: failure})
+	}
+	return s, nil
+}
+
+// Start does nothing, as failures do not change front proxies
+func (s *FailureSchedule) Start(_ model.Director) {}
+
+// Apply sets health of nodes before the request with the number
+// and time since the start of the trace
+func (s *FailureSchedule) Apply(request int, elapsed time.Duration, _ *providers.Request) {
+	down := make(map[string]bool)
+	changed := false
+	var offload model.OriginOffload
+	for _, impact := range s.impacts {
+		active := impact.Failure.active(request, elapsed)
+		if active {
+			for _, node := range impact.Failure.Nodes {
+				down[node] = true
+			}
+		}
+
+		starts := active && !impact.started
+		ends := !active && impact.started && !impact.ended
+		if !starts && !ends {
+			continue
+		}
+		if !changed {
+			offload = model.NewOriginOffload(s.proxies, s.origins)
+			changed = true
+		}
+		if starts {
+			impact.started = true
+			impact.start = offload
+			impact.Before = offload.Sub(s.lastChange)
+		} else {
+			impact.ended = true
+			impact.end = offload
+		}
+	}
+
+	if !changed {
+		return
+	}
+	s.lastChange = offload
+	for name, node := range s.nodes {
+		node.SetHealthy(!down[name])
+	}
+}
+
+// Finish computes impacts of failures at the end of the trace
+func (s *FailureSchedule) Finish() {
+	offload := model.NewOriginOffload(s.proxies, s.origins)
+	for _, impact := range s.impacts {
+		if !impact.started {
+			continue
+		}
+		if !impact.ended {
+			impact.end = offload
+		}
+		impact.During = impact.end.Sub(impact.start)
+		impact.After = offload.Sub(impact.end)
+	}
+}
+
+// Reports returns impacts of the failures, that have started
+func (s *FailureSchedule) Reports() []Report {
+	reports := make([]Report, 0)
+	for _, impact := range s.impacts {
+		if impact.started {
+			reports = append(reports, impact)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,98 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"testing"
+	"time"
+	"varnish_sim/cases"
+	"varnish_sim/model"
+)
+
+func TestParseFailure(t *testing.T) {
+	failure, err := ParseFailure("proxy-2,proxy-3@1000000-1500000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failure.Nodes) != 2 || failure.From.ByTime || failure.From.Request != 1000000 || failure.To.Request != 1500000 {
+		t.Errorf("unexpected failure %+v", failure)
+	}
+
+	failure, err = ParseFailure("proxy-1@3600s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !failure.From.ByTime || failure.From.Time != time.Hour || failure.To != nil || !failure.active(0, 2*time.Hour) {
+		t.Errorf("unexpected failure %+v", failure)
+	}
+
+	for _, spec := range []string{"proxy-1", "@10", "proxy-1@10-5", "proxy-1@1h-10", "proxy-1@soon"} {
+		if _, err := ParseFailure(spec); err == nil {
+			t.Errorf("failure %q should be invalid", spec)
+		}
+	}
+}
+
+func TestFailureSchedule(t *testing.T) {
+	c := cases.NewOneLayerSharded(cases.LayerConfig{Amount: 4, CacheSize: 1000000})
+	proxies, err := c.SetUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := Load([]string{"catalog=1000", "requests=30000"}, nil, nil, "zipf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure, err := ParseFailure("proxy-1@10000-20000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := NewFailureSchedule([]Failure{failure}, c.Proxies(), c.Origins())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	director, err := model.NewLoadBalancer(model.RoundRobinBalancer, proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := 0
+	for i, req := range requests {
+		schedule.Apply(i, 0, req)
+		if proxies[1].Healthy() != (i < 10000 || i >= 20000) {
+			t.Fatalf("proxy-1 health is wrong at request %d", i)
+		}
+		b := director.GetBackend(req.Url)
+		b.Get(req)
+		if i >= 10000 && i < 20000 && b == proxies[1] {
+			served++
+		}
+	}
+	schedule.Finish()
+
+	if served != 0 {
+		t.Errorf("proxy-1 got %d requests while down", served)
+	}
+	reports := schedule.Reports()
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	impact := reports[0].(*FailureImpact)
+	if impact.Before.Requests != 10000 || impact.During.Requests != 10000 || impact.After.Requests != 10000 {
+		t.Errorf("unexpected impact %+v", impact)
+	}
+	if impact.During.Errors != 0 {
+		t.Errorf("shard director should skip the proxy, got %d errors", impact.During.Errors)
+	}
+
+	if _, err := NewFailureSchedule([]Failure{{Nodes: []string{"unknown"}}}, c.Proxies(), c.Origins()); err == nil {
+		t.Error("unknown node should be rejected")
+	}
+}
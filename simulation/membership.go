@@ -0,0 +1,379 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"varnish_sim/model"
+	"varnish_sim/simulation/providers"
+)
+
+// membershipSample is an amount of keys, remapping by the event is measured on
+const membershipSample = 10000
+
+// MembershipEvent is a scale-out or scale-in event,
+// proxies join or leave all directors they are members of
+type MembershipEvent struct {
+	// Join marks proxies joining, otherwise they leave
+	Join  bool
+	Nodes []string
+	At    Point
+
+	// spec is the event as it was parsed
+	spec string
+}
+
+// ParseMembershipEvent parses an event in `join:node[,node...]@at`
+// or `leave:node[,node...]@at` format, at is either a count
+// of requests or a duration since the start of the trace.
+// Proxies, whose first event is join, are not members until it.
+func ParseMembershipEvent(spec string) (MembershipEvent, error) {
+	event := MembershipEvent{spec: spec}
+
+	kind, rest, ok := strings.Cut(spec, ":")
+	nodes, at, ok2 := strings.Cut(rest, "@")
+	if !ok || !ok2 || nodes == "" {
+		return event, fmt.Errorf("event %q is not in join|leave:node[,node...]@at format", spec)
+	}
+
+	switch kind {
+	case "join":
+		event.Join = true
+	case "leave":
+	default:
+		return event, fmt.Errorf("event %q is neither join nor leave", spec)
+	}
+	event.Nodes = strings.Split(nodes, ",")
+
+	var err error
+	if event.At, err = ParsePoint(at); err != nil {
+		return event, fmt.Errorf("event %q: %w", spec, err)
+	}
+	return event, nil
+}
+
+// String returns the event as it was parsed
+func (e MembershipEvent) String() string {
+	return e.spec
+}
+
+// MembershipImpact is an impact of the event on the origin offload of the topology.
+// Offload is measured in windows of requests, the first window after the event,
+// which offload is not lower than before the event (within the tolerance), ends the recovery.
+type MembershipImpact struct {
+	Event MembershipEvent
+
+	// Keys is an amount of distinct keys requested before the event
+	// in the sample (up to membershipSample of them),
+	// Remapped is an amount of those, that moved to another shard
+	Keys     int
+	Remapped int
+
+	// RestartErr is an error of restarting a joining proxy,
+	// the proxy joins with its old cache then
+	RestartErr error
+
+	// Baseline is an offload of the window before the event,
+	// Lowest is the lowest offload of windows after the event until the recovery
+	Baseline float64
+	Lowest   float64
+
+	Recovered bool
+	// RecoveryRequests and RecoveryTime are requests and time since the event
+	// until the end of the recovery
+	RecoveryRequests int
+	RecoveryTime     time.Duration
+
+	applied bool
+	// request and time of the event
+	request int
+	elapsed time.Duration
+	// offload at the start of the current window
+	window model.OriginOffload
+}
+
+// Drop returns how much the offload dropped after the event
+func (i *MembershipImpact) Drop() float64 {
+	return i.Baseline - i.Lowest
+}
+
+// RemappedShare returns a share of requested keys moved to another shard
+func (i *MembershipImpact) RemappedShare() float64 {
+	if i.Keys == 0 {
+		return 0
+	}
+	return float64(i.Remapped) / float64(i.Keys)
+}
+
+func (i *MembershipImpact) TableData() (name string, rows [][]string) {
+	name = "Membership"
+	rows = append(rows, []string{"Event", i.Event.String()})
+	rows = append(rows, []string{"Keys", fmt.Sprintf("%d", i.Keys)})
+	rows = append(rows, []string{"Keys remapped", fmt.Sprintf("%d", i.Remapped)})
+	rows = append(rows, []string{"Remapped share", fmt.Sprintf("%f", i.RemappedShare())})
+	rows = append(rows, []string{"Offload before", fmt.Sprintf("%f", i.Baseline)})
+	rows = append(rows, []string{"Lowest offload", fmt.Sprintf("%f", i.Lowest)})
+	rows = append(rows, []string{"Offload drop", fmt.Sprintf("%f", i.Drop())})
+	if i.Recovered {
+		rows = append(rows, []string{"Recovery requests", fmt.Sprintf("%d", i.RecoveryRequests)})
+		rows = append(rows, []string{"Recovery time", i.RecoveryTime.String()})
+	} else {
+		rows = append(rows, []string{"Recovery", "not recovered"})
+	}
+	if i.RestartErr != nil {
+		rows = append(rows, []string{"Restart error", i.RestartErr.Error()})
+	}
+	return
+}
+
+func (i *MembershipImpact) Export() map[string]interface{} {
+	restartErr := ""
+	if i.RestartErr != nil {
+		restartErr = i.RestartErr.Error()
+	}
+	return map[string]interface{}{
+		"membership": map[string]interface{}{
+			"event":             i.Event.String(),
+			"keys":              i.Keys,
+			"keys_remapped":     i.Remapped,
+			"remapped_share":    i.RemappedShare(),
+			"offload_before":    i.Baseline,
+			"lowest_offload":    i.Lowest,
+			"offload_drop":      i.Drop(),
+			"recovered":         i.Recovered,
+			"recovery_requests": i.RecoveryRequests,
+			"recovery_time":     i.RecoveryTime.Seconds(),
+			"restart_error":     restartErr,
+		},
+	}
+}
+
+// MembershipSchedule adds proxies to and removes them from directors during the run
+type MembershipSchedule struct {
+	impacts []*MembershipImpact
+
+	proxies map[string]*model.VarnishProxy
+	// members are directors, that have the proxy as a backend at the start
+	members map[string][]model.Director
+
+	all     []*model.VarnishProxy
+	origins []*model.Backend
+
+	// keys is a sample of keys requested so far
+	keys *model.KeySample
+
+	// window is a size of windows in requests, tolerance is an allowed
+	// difference of offload from the baseline to consider it recovered
+	window    int
+	tolerance float64
+	// baseline is an offload of the last window, lastWindow is the offload at its end
+	baseline   float64
+	lastWindow model.OriginOffload
+}
+
+// NewMembershipSchedule resolves proxies of the events,
+// offload is measured in windows of `window` requests
+func NewMembershipSchedule(
+	events []MembershipEvent,
+	proxies []*model.VarnishProxy,
+	origins []*model.Backend,
+	window int,
+	tolerance float64,
+) (*MembershipSchedule, error) {
+	if window < 1 {
+		return nil, fmt.Errorf("recovery window should be greater than 0")
+	}
+
+	s := &MembershipSchedule{
+		proxies:   make(map[string]*model.VarnishProxy),
+		members:   make(map[string][]model.Director),
+		all:       proxies,
+		origins:   origins,
+		keys:      model.NewKeySample(membershipSample),
+		window:    window,
+		tolerance: tolerance,
+	}
+	for _, proxy := range proxies {
+		s.proxies[proxy.Hostname()] = proxy
+	}
+
+	for _, event := range events {
+		for _, node := range event.Nodes {
+			if _, ok := s.proxies[node]; !ok {
+				return nil, fmt.Errorf("event %q: unknown proxy %q", event, node)
+			}
+		}
+		s.impacts = append(s.impacts, &MembershipImpact{Event: event})
+	}
+	return s, nil
+}
+
+// Start finds directors of the proxies and removes from them
+// the proxies, whose first event is join
+func (s *MembershipSchedule) Start(front model.Director) {
+	directors := nestedDirectors(front)
+	for _, proxy := range s.all {
+		if director := proxy.Director(); director != nil {
+			directors = append(directors, nestedDirectors(director)...)
+		}
+	}
+
+	for name, proxy := range s.proxies {
+		for _, director := range directors {
+			if isMember(director, proxy) {
+				s.members[name] = append(s.members[name], director)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, impact := range s.impacts {
+		for _, node := range impact.Event.Nodes {
+			if !seen[node] && impact.Event.Join {
+				for _, director := range s.members[node] {
+					director.RemoveBackend(s.proxies[node])
+				}
+			}
+			seen[node] = true
+		}
+	}
+}
+
+// nestedDirectors returns the director and directors nested in it
+func nestedDirectors(director model.Director) []model.Director {
+	directors := []model.Director{director}
+	for _, backend := range director.Backends() {
+		if nested, ok := backend.(*model.DirectorBackend); ok {
+			directors = append(directors, nestedDirectors(nested.Director)...)
+		}
+	}
+	return directors
+}
+
+// isMember returns if the proxy is a backend of the director
+func isMember(director model.Director, proxy *model.VarnishProxy) bool {
+	for _, backend := range director.Backends() {
+		if backend == model.WebInterface(proxy) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies events reached before the request and measures offload in windows
+func (s *MembershipSchedule) Apply(request int, elapsed time.Duration, req *providers.Request) {
+	if request > 0 && request%s.window == 0 {
+		offload := model.NewOriginOffload(s.all, s.origins)
+		s.baseline = offload.Sub(s.lastWindow).RequestOffload()
+		s.lastWindow = offload
+	}
+
+	for _, impact := range s.impacts {
+		if !impact.applied {
+			if impact.Event.At.Reached(request, elapsed) {
+				s.apply(impact, request, elapsed)
+			}
+			continue
+		}
+		if impact.Recovered || request == impact.request || (request-impact.request)%s.window != 0 {
+			continue
+		}
+
+		offload := model.NewOriginOffload(s.all, s.origins)
+		current := offload.Sub(impact.window).RequestOffload()
+		impact.window = offload
+		if current < impact.Lowest {
+			impact.Lowest = current
+		}
+		if current >= impact.Baseline-s.tolerance {
+			impact.Recovered = true
+			impact.RecoveryRequests = request - impact.request
+			impact.RecoveryTime = elapsed - impact.elapsed
+		}
+	}
+
+	s.keys.Add(req.HashKey(), req.Size)
+}
+
+// apply adds or removes proxies of the event and counts keys remapped
+// by the first shard director the proxies are members of
+func (s *MembershipSchedule) apply(impact *MembershipImpact, request int, elapsed time.Duration) {
+	impact.applied = true
+	impact.request = request
+	impact.elapsed = elapsed
+	impact.window = model.NewOriginOffload(s.all, s.origins)
+	impact.Baseline = s.baseline
+	impact.Lowest = s.baseline
+
+	var shard *model.ShardDirector
+	for _, node := range impact.Event.Nodes {
+		for _, director := range s.members[node] {
+			if d, ok := director.(*model.ShardDirector); ok && shard == nil {
+				shard = d
+			}
+		}
+	}
+
+	owners := make(map[string]model.WebInterface)
+	if shard != nil {
+		for _, key := range s.keys.Keys() {
+			owners[key] = ownerOf(shard, key)
+		}
+	}
+
+	for _, node := range impact.Event.Nodes {
+		proxy := s.proxies[node]
+		if impact.Event.Join {
+			// new proxy starts with a cold cache
+			if err := proxy.Restart(); err != nil && impact.RestartErr == nil {
+				impact.RestartErr = fmt.Errorf("restart of %s: %w", node, err)
+			}
+		}
+		for _, director := range s.members[node] {
+			switch {
+			case impact.Event.Join && !isMember(director, proxy):
+				director.AddBackend(proxy)
+			case !impact.Event.Join:
+				director.RemoveBackend(proxy)
+			}
+		}
+	}
+
+	impact.Keys = len(owners)
+	for key, owner := range owners {
+		if ownerOf(shard, key) != owner {
+			impact.Remapped++
+		}
+	}
+}
+
+// ownerOf returns the shard owning the key, without side effects of GetBackend
+// on the state of the director
+func ownerOf(shard *model.ShardDirector, key string) model.WebInterface {
+	if owners := shard.Hashing().Locate(key, 1); len(owners) > 0 {
+		return owners[0]
+	}
+	return nil
+}
+
+// Finish does nothing, impacts are measured during the run
+func (s *MembershipSchedule) Finish() {}
+
+// Reports returns impacts of the events, that were applied
+func (s *MembershipSchedule) Reports() []Report {
+	reports := make([]Report, 0)
+	for _, impact := range s.impacts {
+		if impact.applied {
+			reports = append(reports, impact)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,79 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"testing"
+	"varnish_sim/cases"
+	"varnish_sim/model"
+)
+
+func TestParseMembershipEvent(t *testing.T) {
+	event, err := ParseMembershipEvent("join:proxy-3,proxy-4@1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.Join || len(event.Nodes) != 2 || event.At.Request != 1000 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	for _, spec := range []string{"proxy-3@1000", "grow:proxy-3@1000", "join:@1000", "leave:proxy-1"} {
+		if _, err := ParseMembershipEvent(spec); err == nil {
+			t.Errorf("event %q should be invalid", spec)
+		}
+	}
+}
+
+func TestMembershipSchedule(t *testing.T) {
+	c := cases.NewOneLayerSharded(cases.LayerConfig{Amount: 4, CacheSize: 1000000})
+	proxies, err := c.SetUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := Load([]string{"catalog=1000", "requests=30000"}, nil, nil, "zipf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := ParseMembershipEvent("join:proxy-3@10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := NewMembershipSchedule([]MembershipEvent{event}, c.Proxies(), c.Origins(), 1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	director, err := model.NewLoadBalancer(model.RoundRobinBalancer, proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule.Start(director)
+	for i, req := range requests {
+		schedule.Apply(i, 0, req)
+		b := director.GetBackend(req.Url)
+		if i < 10000 && b == model.WebInterface(proxies[3]) {
+			t.Fatalf("proxy-3 got a request %d before joining", i)
+		}
+		b.Get(req)
+	}
+	schedule.Finish()
+
+	reports := schedule.Reports()
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	impact := reports[0].(*MembershipImpact)
+	if impact.Keys == 0 || impact.Remapped == 0 || impact.Remapped == impact.Keys {
+		t.Errorf("some keys should be remapped to the new proxy: %+v", impact)
+	}
+	if !impact.Recovered {
+		t.Errorf("offload should recover after scale-out: %+v", impact)
+	}
+}
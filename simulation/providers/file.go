@@ -17,10 +17,10 @@ const fileProviderName = "file"
 
 type FileProvider struct {
 	Files     []string
-	Formatter func(string) (string, int)
+	Formatter func(string) *Request
 }
 
-func (f *FileProvider) SetFormatter(frmt func(string) (string, int)) {
+func (f *FileProvider) SetFormatter(frmt func(string) *Request) {
 	if frmt == nil {
 		f.Formatter = defaultFormatter
 	}
@@ -54,7 +54,7 @@ func (f *FileProvider) Channel() <-chan *Request {
 	return ch
 }
 
-func openAndProvide(file string, frmt func(string) (string, int), ch chan *Request) bool {
+func openAndProvide(file string, frmt func(string) *Request, ch chan *Request) bool {
 	// open file
 	fd, err := os.Open(file)
 	if err != nil {
@@ -67,13 +67,15 @@ func openAndProvide(file string, frmt func(string) (string, int), ch chan *Reque
 	return false
 }
 
-func pipeReaderChannel(r *bufio.Reader, frmt func(string) (string, int), ch chan *Request) {
+func pipeReaderChannel(r *bufio.Reader, frmt func(string) *Request, ch chan *Request) {
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
 			break
 		}
-		url, size := frmt(line)
-		ch <- &Request{url, size}
+		// skip lines the formatter can not parse
+		if req := frmt(line); req != nil {
+			ch <- req
+		}
 	}
 }
@@ -0,0 +1,303 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"varnish_sim/model"
+)
+
+const (
+	// CombinedLogFormat is Apache combined log format
+	CombinedLogFormat = `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-agent}i"`
+	// CommonLogFormat is Apache common log format
+	CommonLogFormat = `%h %l %u %t "%r" %s %b`
+	// VarnishncsaLogFormat is the default format of varnishncsa,
+	// it is the combined one, but %r holds absolute URL with the host.
+	VarnishncsaLogFormat = CombinedLogFormat
+
+	// clfTimeLayout is a layout of %t field
+	clfTimeLayout = "[02/Jan/2006:15:04:05 -0700]"
+)
+
+// logFormats are named log formats, any other format passed
+// is considered as a custom one in varnishncsa -F syntax
+var logFormats = map[string]string{
+	"combined":    CombinedLogFormat,
+	"common":      CommonLogFormat,
+	"varnishncsa": VarnishncsaLogFormat,
+}
+
+// LogFormats returns names of the predefined log formats
+func LogFormats() []string {
+	return []string{"combined", "common", "varnishncsa"}
+}
+
+// logToken is either a literal text or a field of a log format
+type logToken struct {
+	literal string
+
+	// field is a format letter, e.g. `r` for %r
+	field byte
+	// arg is an argument of the field, e.g. `Host` for %{Host}i
+	arg string
+	// withQuery marks %U field immediately followed by %q
+	withQuery bool
+}
+
+// LogFormat parses lines of access logs written by varnishncsa
+// or Apache in a format described by format string
+type LogFormat struct {
+	tokens []logToken
+}
+
+// NewLogFormat returns a log format by its name, or parses
+// the custom format string in varnishncsa -F syntax
+func NewLogFormat(name string) (*LogFormat, error) {
+	if format, ok := logFormats[name]; ok {
+		return ParseLogFormat(format)
+	}
+	if !strings.Contains(name, "%") {
+		return nil, fmt.Errorf("unknown log format %q", name)
+	}
+	return ParseLogFormat(name)
+}
+
+// ParseLogFormat parses the format string in varnishncsa -F syntax
+func ParseLogFormat(format string) (*LogFormat, error) {
+	tokens := make([]logToken, 0)
+	literal := strings.Builder{}
+
+	flush := func() {
+		if literal.Len() > 0 {
+			tokens = append(tokens, logToken{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		if i >= len(format) {
+			return nil, fmt.Errorf("log format ends with %%")
+		}
+		if format[i] == '%' {
+			literal.WriteByte('%')
+			continue
+		}
+
+		token := logToken{}
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 || i+end+1 >= len(format) {
+				return nil, fmt.Errorf("log format has unterminated %%{")
+			}
+			token.arg = format[i+1 : i+end]
+			i += end + 1
+		}
+		token.field = format[i]
+
+		flush()
+		if last := len(tokens) - 1; last >= 0 && tokens[last].field == 'U' && token.field == 'q' {
+			// %U%q is a path with the query
+			tokens[last].withQuery = true
+			continue
+		}
+		if len(tokens) > 0 && tokens[len(tokens)-1].literal == "" {
+			return nil, fmt.Errorf("log format fields %%%c and %%%c are not separated", tokens[len(tokens)-1].field, token.field)
+		}
+		tokens = append(tokens, token)
+	}
+	flush()
+
+	return &LogFormat{tokens: tokens}, nil
+}
+
+// Format is a formatter of the provider, returns nil if the line
+// does not match the format or does not hold the URL
+func (f *LogFormat) Format(line string) *Request {
+	req, err := f.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return nil
+	}
+	return req
+}
+
+// Parse parses the line into a request
+func (f *LogFormat) Parse(line string) (*Request, error) {
+	req := model.NewRequest("", 0)
+	path, query := "", ""
+	pos := 0
+
+	for i, token := range f.tokens {
+		if token.literal != "" {
+			if !strings.HasPrefix(line[pos:], token.literal) {
+				return nil, fmt.Errorf("line does not match %q at %d", token.literal, pos)
+			}
+			pos += len(token.literal)
+			continue
+		}
+
+		end := len(line)
+		if i+1 < len(f.tokens) {
+			next := f.tokens[i+1].literal
+			quoted := i > 0 && strings.HasSuffix(f.tokens[i-1].literal, `"`) && strings.HasPrefix(next, `"`)
+			end = fieldEnd(line, pos, next, quoted)
+			if end < 0 {
+				return nil, fmt.Errorf("line does not match %q after %d", next, pos)
+			}
+		}
+		value := line[pos:end]
+		pos = end
+
+		switch token.field {
+		case 'h':
+			req.Client = value
+		case 'r':
+			// request line, e.g. `GET /foo HTTP/1.1`
+			split := strings.Split(value, " ")
+			if len(split) < 2 {
+				return nil, fmt.Errorf("invalid request line %q", value)
+			}
+			req.Url = split[1]
+		case 'U':
+			path = value
+			if token.withQuery {
+				if idx := strings.IndexByte(value, '?'); idx >= 0 {
+					path, query = value[:idx], value[idx:]
+				}
+			}
+		case 'q':
+			query = value
+		case 's':
+			req.Status, _ = strconv.Atoi(value)
+			// server errors are not cached
+			if req.Status >= 500 {
+				req.Uncacheable = true
+			}
+		case 'b', 'B', 'O':
+			req.Size, _ = strconv.Atoi(value)
+		case 't':
+			req.Timestamp = parseLogTime(token.arg, value)
+		case 'i':
+			if strings.EqualFold(token.arg, "Host") {
+				req.Host = value
+			} else if value != "-" {
+				// other request headers are kept for responses varying on them
+				req.SetHeader(token.arg, value)
+			}
+		case 'o':
+			switch {
+			case strings.EqualFold(token.arg, "Cache-Control"):
+				req.CacheControl = value
+				applyCacheControl(req)
+			case strings.EqualFold(token.arg, "Vary"):
+				req.SetVary(value)
+			case strings.EqualFold(token.arg, "Set-Cookie"):
+				// varnishncsa logs missing headers as `-`
+				if value != "" && value != "-" {
+					req.Uncacheable = true
+				}
+			}
+		}
+	}
+
+	if req.Url == "" {
+		req.Url = path + query
+	}
+	if req.Url == "" {
+		return nil, fmt.Errorf("line holds no URL")
+	}
+
+	// varnishncsa logs absolute URL, host is split from it
+	for _, scheme := range []string{"http://", "https://"} {
+		if rest, ok := strings.CutPrefix(req.Url, scheme); ok {
+			host, path, _ := strings.Cut(rest, "/")
+			if req.Host == "" {
+				req.Host = host
+			}
+			req.Url = "/" + path
+		}
+	}
+
+	return req, nil
+}
+
+// fieldEnd returns the end of the field starting at pos, that is followed
+// by the literal. Quoted fields may contain the literal escaped by backslash.
+func fieldEnd(line string, pos int, next string, quoted bool) int {
+	for from := pos; ; {
+		idx := strings.Index(line[from:], next)
+		if idx < 0 {
+			return -1
+		}
+		end := from + idx
+		if !quoted || end == 0 || line[end-1] != '\\' {
+			return end
+		}
+		from = end + 1
+	}
+}
+
+// parseLogTime parses %t field, with the default CLF layout,
+// or unix time in seconds or RFC3339 for %{...}t fields.
+// Returns zero time if the field is invalid.
+func parseLogTime(arg, value string) time.Time {
+	if arg == "" {
+		t, err := time.Parse(clfTimeLayout, value)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+	return timestampAt([]string{value}, 0)
+}
+
+// applyCacheControl sets TTL and grace of the request
+// from Cache-Control header of the response, private, no-cache
+// and no-store responses are uncacheable
+func applyCacheControl(req *Request) {
+	maxAge, sMaxAge := model.UnsetDuration, model.UnsetDuration
+
+	for _, directive := range strings.Split(req.CacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "private", "no-cache", "no-store":
+			req.Uncacheable = true
+		}
+
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			continue
+		}
+
+		switch strings.ToLower(name) {
+		case "max-age":
+			maxAge = time.Duration(seconds) * time.Second
+		case "s-maxage":
+			sMaxAge = time.Duration(seconds) * time.Second
+		case "stale-while-revalidate":
+			req.Grace = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// shared caches prefer s-maxage, as Varnish does
+	if sMaxAge != model.UnsetDuration {
+		req.TTL = sMaxAge
+	} else if maxAge != model.UnsetDuration {
+		req.TTL = maxAge
+	}
+}
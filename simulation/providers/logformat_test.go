@@ -0,0 +1,115 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVarnishncsaFormat(t *testing.T) {
+	format, err := NewLogFormat("varnishncsa")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	line := `10.0.0.1 - - [10/Oct/2023:13:55:36 -0700] "GET http://example.com/foo?a=1 HTTP/1.1" 200 2326 "-" "Mozilla/5.0 (\"X11\")"` + "\n"
+	req := format.Format(line)
+	if req == nil {
+		t.Fatalf("error: line is not parsed")
+	}
+
+	if req.Url != "/foo?a=1" || req.Host != "example.com" || req.Status != 200 || req.Size != 2326 || req.Client != "10.0.0.1" {
+		t.Fatalf("error: unexpected request %+v", req)
+	}
+	if req.Timestamp.Unix() != time.Date(2023, 10, 10, 20, 55, 36, 0, time.UTC).Unix() {
+		t.Fatalf("error: unexpected timestamp %v", req.Timestamp)
+	}
+
+	if format.Format("garbage\n") != nil {
+		t.Fatalf("error: invalid line should not be parsed")
+	}
+}
+
+func TestCustomFormat(t *testing.T) {
+	format, err := NewLogFormat(`%{%s}t %{Host}i %U%q %s %b "%{Cache-Control}o"`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	req := format.Format(`1700000000 www.example.com /x?y=1 200 512 "public, max-age=60, s-maxage=300, stale-while-revalidate=30"` + "\n")
+	if req == nil {
+		t.Fatalf("error: line is not parsed")
+	}
+
+	if req.Url != "/x?y=1" || req.Host != "www.example.com" || req.Timestamp.Unix() != 1700000000 {
+		t.Fatalf("error: unexpected request %+v", req)
+	}
+	if req.TTL != 300*time.Second || req.Grace != 30*time.Second {
+		t.Fatalf("error: unexpected TTL %v and grace %v", req.TTL, req.Grace)
+	}
+}
+
+func TestVaryFormat(t *testing.T) {
+	format, err := NewLogFormat(`%U %b "%{Accept-Encoding}i" "%{X-Device}i" "%{Vary}o"`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	gzip := format.Format(`/a 10 "gzip" "mobile" "accept-encoding, X-Device"` + "\n")
+	plain := format.Format(`/a 10 "-" "mobile" "X-Device,Accept-Encoding"` + "\n")
+	if gzip == nil || plain == nil {
+		t.Fatalf("error: lines are not parsed")
+	}
+	if gzip.CacheKey() == plain.CacheKey() || gzip.CacheKey() == gzip.Url {
+		t.Fatalf("error: variants share the key %q", gzip.CacheKey())
+	}
+
+	plain.SetHeader("accept-encoding", "gzip")
+	if gzip.CacheKey() != plain.CacheKey() {
+		t.Fatalf("error: keys %q and %q of the same variant differ", gzip.CacheKey(), plain.CacheKey())
+	}
+
+	star := format.Format(`/b 10 "-" "-" "*"` + "\n")
+	if star == nil || !star.Uncacheable {
+		t.Fatalf("error: responses varying on * should be uncacheable")
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	for _, format := range []string{"%h%u", "%{Host", "%", "nofields"} {
+		if _, err := NewLogFormat(format); err == nil {
+			t.Fatalf("error: format %q should not be parsed", format)
+		}
+	}
+}
+
+func TestUncacheableFormat(t *testing.T) {
+	format, err := NewLogFormat(`%U %s %b "%{Cache-Control}o" "%{Set-Cookie}o"`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for line, uncacheable := range map[string]bool{
+		`/a 200 10 "max-age=60" "-"`:          false,
+		`/b 200 10 "private, max-age=60" "-"`: true,
+		`/c 200 10 "no-store" "-"`:            true,
+		`/d 200 10 "max-age=60" "session=1"`:  true,
+		`/e 503 10 "max-age=60" "-"`:          true,
+		`/f 404 10 "s-maxage=60, public" "-"`: false,
+	} {
+		req := format.Format(line + "\n")
+		if req == nil {
+			t.Fatalf("error: line %q is not parsed", line)
+		}
+		if req.Uncacheable != uncacheable {
+			t.Fatalf("error: line %q is uncacheable: %v", line, req.Uncacheable)
+		}
+	}
+}
@@ -9,9 +9,12 @@
 package providers
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"varnish_sim/model"
 )
 
 const (
@@ -21,6 +24,34 @@ const (
 	// VsimFrmtSizePosEnvName is the name of the environment variable that
 	// holds the position of the Size of request in the line passed to (default) formatter
 	VsimFrmtSizePosEnvName = "VSIM_FRMT_SIZE_POS"
+	// VsimFrmtTtlPosEnvName is the name of the environment variable that
+	// holds the position of the object's TTL in seconds in the line passed to (default) formatter
+	VsimFrmtTtlPosEnvName = "VSIM_FRMT_TTL_POS"
+	// VsimFrmtGracePosEnvName is the name of the environment variable that
+	// holds the position of the object's grace in seconds in the line passed to (default) formatter
+	VsimFrmtGracePosEnvName = "VSIM_FRMT_GRACE_POS"
+	// VsimFrmtKeepPosEnvName is the name of the environment variable that
+	// holds the position of the object's keep in seconds in the line passed to (default) formatter
+	VsimFrmtKeepPosEnvName = "VSIM_FRMT_KEEP_POS"
+	// VsimFrmtTimestampPosEnvName is the name of the environment variable that
+	// holds the position of the request's timestamp in the line passed to (default) formatter,
+	// either unix time in seconds or RFC3339 time.
+	VsimFrmtTimestampPosEnvName = "VSIM_FRMT_TIMESTAMP_POS"
+	// VsimFrmtUncacheablePosEnvName is the name of the environment variable that
+	// holds the position of the flag marking uncacheable responses in the line passed to (default) formatter,
+	// either 1/0 or true/false.
+	VsimFrmtUncacheablePosEnvName = "VSIM_FRMT_UNCACHEABLE_POS"
+	// VsimFrmtHeadersPosEnvName is the name of the environment variable that
+	// holds the position of request headers responses may vary on in the line passed to (default) formatter,
+	// in `name=value;name=value` format.
+	VsimFrmtHeadersPosEnvName = "VSIM_FRMT_HEADERS_POS"
+	// VsimFrmtVaryPosEnvName is the name of the environment variable that
+	// holds the position of Vary header of the response in the line passed to (default) formatter,
+	// comma separated names of headers.
+	VsimFrmtVaryPosEnvName = "VSIM_FRMT_VARY_POS"
+	// VsimFrmtClientPosEnvName is the name of the environment variable that
+	// holds the position of the address of the client in the line passed to (default) formatter
+	VsimFrmtClientPosEnvName = "VSIM_FRMT_CLIENT_POS"
 )
 
 // providers is a slice of strings that holds the names of the providers
@@ -30,15 +61,13 @@ var providers []string
 func init() {
 	providers = make([]string, 0)
 	providers = append(providers, fileProviderName)
+	providers = append(providers, zipfProviderName)
 }
 
 // Request is a struct that holds the URL and the Size of the request
 // passed for simulation
 // Request is struct that are passed into channel connecting provider and simulation
-type Request struct {
-	Url  string
-	Size int
-}
+type Request = model.Request
 
 // Providers returns the list of available providers
 func Providers() []string {
@@ -55,8 +84,8 @@ type Provider interface {
 	// SetFormatter sets the function that will be used to format the line
 	// passed to the provider
 	// Formatter of line should get a line with a break line at the end and return
-	// the URL and the Size of the request
-	SetFormatter(func(string) (string, int))
+	// the request with at least the URL and the Size set, or nil if the line is invalid
+	SetFormatter(func(string) *Request)
 
 	// String returns the name of the provider
 	String() string
@@ -65,21 +94,11 @@ type Provider interface {
 // defaultFormatter is the default function that formats a line provided
 // and extracts from it the URL and the Size of the request
 // that will be passed for simulation
+// Timestamp, TTL, grace, keep, uncacheable flag, headers, Vary and client are extracted only if their positions are set.
 // Note: cuts the last character of the line, which is assumed to be a newline character
-func defaultFormatter(line string) (string, int) {
-	urlPos := 1
-	urlPosEnv := os.Getenv(VsimFrmtUrlPosEnvName)
-	if urlPosEnv != "" {
-		urlPos, _ = strconv.Atoi(urlPosEnv)
-	}
-
-	sizePos := 0
-	sizePosEnv := os.Getenv(VsimFrmtSizePosEnvName)
-	if sizePosEnv != "" {
-		if v, err := strconv.Atoi(sizePosEnv); err == nil {
-			sizePos = v
-		}
-	}
+func defaultFormatter(line string) *Request {
+	urlPos := envPosition(VsimFrmtUrlPosEnvName, 1)
+	sizePos := envPosition(VsimFrmtSizePosEnvName, 0)
 
 	sep := " "
 	sepEnv := os.Getenv("VSIM_FRMT_SEP")
@@ -92,22 +111,90 @@ func defaultFormatter(line string) (string, int) {
 	size, err := strconv.Atoi(split[sizePos])
 	if err != nil {
 		// default fallback
-		return split[urlPos], 1000
+		size = 1000
+	}
+
+	req := model.NewRequest(split[urlPos], size)
+	req.TTL = durationAt(split, envPosition(VsimFrmtTtlPosEnvName, -1))
+	req.Grace = durationAt(split, envPosition(VsimFrmtGracePosEnvName, -1))
+	req.Keep = durationAt(split, envPosition(VsimFrmtKeepPosEnvName, -1))
+	req.Timestamp = timestampAt(split, envPosition(VsimFrmtTimestampPosEnvName, -1))
+	req.Uncacheable = flagAt(split, envPosition(VsimFrmtUncacheablePosEnvName, -1))
+	if pos := envPosition(VsimFrmtHeadersPosEnvName, -1); pos >= 0 && pos < len(split) {
+		for _, header := range strings.Split(split[pos], ";") {
+			if name, value, ok := strings.Cut(header, "="); ok {
+				req.SetHeader(name, value)
+			}
+		}
+	}
+	if pos := envPosition(VsimFrmtVaryPosEnvName, -1); pos >= 0 && pos < len(split) {
+		req.SetVary(split[pos])
+	}
+	if pos := envPosition(VsimFrmtClientPosEnvName, -1); pos >= 0 && pos < len(split) {
+		req.Client = split[pos]
+	}
+
+	return req
+}
+
+// envPosition returns position of a field set by the environment variable,
+// or fallback if the variable is not set or invalid
+func envPosition(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+// durationAt parses a field at the position as duration in seconds,
+// returns model.UnsetDuration if the position is out of the line or the field is invalid
+func durationAt(split []string, pos int) time.Duration {
+	if pos < 0 || pos >= len(split) {
+		return model.UnsetDuration
+	}
+	seconds, err := strconv.ParseFloat(split[pos], 64)
+	if err != nil || seconds < 0 {
+		return model.UnsetDuration
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// flagAt parses a field at the position as boolean,
+// returns false if the position is out of the line or the field is invalid
+func flagAt(split []string, pos int) bool {
+	if pos < 0 || pos >= len(split) {
+		return false
+	}
+	flag, _ := strconv.ParseBool(split[pos])
+	return flag
+}
+
+// timestampAt parses a field at the position as unix time in seconds or RFC3339 time,
+// returns zero time if the position is out of the line or the field is invalid
+func timestampAt(split []string, pos int) time.Time {
+	if pos < 0 || pos >= len(split) {
+		return time.Time{}
+	}
+	if seconds, err := strconv.ParseFloat(split[pos], 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second)))
+	}
+	if t, err := time.Parse(time.RFC3339, split[pos]); err == nil {
+		return t
 	}
-	return split[urlPos], size
+	return time.Time{}
 }
 
 // NewProviderByName returns a new provider that is specified by the name
 // arg is passed to the provider to specify the source of the requests
-func NewProviderByName(providerName string, arg []string) Provider {
+// returns an error if the provider is unknown or arg is invalid
+func NewProviderByName(providerName string, arg []string) (Provider, error) {
 	// use request-provider to generate requests
 	// prob via a channel
 	switch providerName {
-	case "file":
-		return &FileProvider{Files: arg}
-	default:
-		// use default-provider
-		// TODO: implement something
+	case fileProviderName:
+		return &FileProvider{Files: arg}, nil
+	case zipfProviderName:
+		return NewZipfProvider(arg)
 	}
-	return nil
+	return nil, fmt.Errorf("provider %s not found", providerName)
 }
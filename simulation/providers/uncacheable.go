@@ -0,0 +1,47 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UncacheableRules mark requests of matching URLs as uncacheable,
+// for traces, that do not tell cacheability of responses
+type UncacheableRules struct {
+	patterns []*regexp.Regexp
+}
+
+// NewUncacheableRules compiles regular expressions matching URLs of uncacheable objects
+func NewUncacheableRules(patterns []string) (*UncacheableRules, error) {
+	rules := &UncacheableRules{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("uncacheable rule %q: %w", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+	return rules, nil
+}
+
+// Mark marks the request as uncacheable if its URL matches any rule,
+// requests already marked by the trace are kept marked
+func (r *UncacheableRules) Mark(req *Request) {
+	if r == nil {
+		return
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(req.Url) {
+			req.Uncacheable = true
+			return
+		}
+	}
+}
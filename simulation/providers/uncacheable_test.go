@@ -0,0 +1,33 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import (
+	"testing"
+	"varnish_sim/model"
+)
+
+func TestUncacheableRules(t *testing.T) {
+	rules, err := NewUncacheableRules([]string{`^/api/`, `\?session=`})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for url, uncacheable := range map[string]bool{"/api/user": true, "/img?session=1": true, "/img/api/": false} {
+		req := model.NewRequest(url, 10)
+		rules.Mark(req)
+		if req.Uncacheable != uncacheable {
+			t.Fatalf("error: %s is uncacheable: %v", url, req.Uncacheable)
+		}
+	}
+
+	if _, err := NewUncacheableRules([]string{"("}); err == nil {
+		t.Fatalf("error: invalid rule should not be compiled")
+	}
+}
@@ -0,0 +1,302 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"varnish_sim/model"
+)
+
+const (
+	zipfProviderName = "zipf"
+
+	// ZipfVaryHeader is a header responses of zipf provider vary on
+	ZipfVaryHeader = "X-Variant"
+)
+
+// ZipfConfig is a configuration of synthetic Zipf workload
+type ZipfConfig struct {
+	// Catalog is the amount of distinct objects
+	Catalog int
+	// Alpha is the skew of popularity, 0 is uniform
+	Alpha float64
+	// Requests is the amount of requests generated
+	Requests int
+	// Size is a distribution of object sizes
+	Size SizeDistribution
+	// Seed makes the workload reproducible
+	Seed int64
+	// Rate is requests per second used to timestamp requests,
+	// zero leaves requests without timestamps
+	Rate float64
+	// Variants is an amount of variants of every object, requests carry
+	// ZipfVaryHeader with a uniformly chosen variant, zero or one do not vary
+	Variants int
+	// Hosts is an amount of sites objects are spread among, as `site-<n>` hosts,
+	// zero leaves requests without a host
+	Hosts int
+	// Clients is an amount of clients sending requests uniformly, as `10.x.y.z` addresses,
+	// zero leaves requests without a client
+	Clients int
+}
+
+// ZipfProvider generates requests to a catalog of objects
+// with Zipf distributed popularity
+type ZipfProvider struct {
+	config ZipfConfig
+}
+
+// NewZipfProvider is a constructor for ZipfProvider
+// args are `key=value` pairs:
+//
+//	catalog=<objects> alpha=<skew> requests=<count> seed=<seed> rate=<req/s> variants=<count> hosts=<count> clients=<count>
+//	size=constant:<bytes> | uniform:<min>,<max> | lognormal:<mu>,<sigma> | pareto:<scale>,<shape>
+func NewZipfProvider(args []string) (*ZipfProvider, error) {
+	config := ZipfConfig{
+		Catalog:  1000,
+		Alpha:    0.8,
+		Requests: 100000,
+		Size:     ConstantSize(1000),
+		Seed:     1,
+	}
+
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("zipf: argument %q is not in key=value format", arg)
+		}
+
+		var err error
+		switch key {
+		case "catalog":
+			config.Catalog, err = strconv.Atoi(value)
+		case "alpha":
+			config.Alpha, err = strconv.ParseFloat(value, 64)
+		case "requests":
+			var requests float64
+			requests, err = strconv.ParseFloat(value, 64)
+			config.Requests = int(requests)
+		case "seed":
+			config.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "rate":
+			config.Rate, err = strconv.ParseFloat(value, 64)
+		case "size":
+			config.Size, err = ParseSizeDistribution(value)
+		case "variants":
+			config.Variants, err = strconv.Atoi(value)
+		case "hosts":
+			config.Hosts, err = strconv.Atoi(value)
+		case "clients":
+			config.Clients, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown argument")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zipf: %s: %w", key, err)
+		}
+	}
+
+	if config.Catalog < 1 {
+		return nil, fmt.Errorf("zipf: catalog must be greater than 0")
+	}
+	if config.Alpha < 0 {
+		return nil, fmt.Errorf("zipf: alpha must not be negative")
+	}
+	if config.Requests < 0 || config.Rate < 0 || config.Variants < 0 || config.Hosts < 0 || config.Clients < 0 {
+		return nil, fmt.Errorf("zipf: requests, rate, variants, hosts and clients must not be negative")
+	}
+
+	return &ZipfProvider{config: config}, nil
+}
+
+// SetFormatter is not used, as requests are generated, not parsed
+func (z *ZipfProvider) SetFormatter(_ func(string) *Request) {}
+
+func (z *ZipfProvider) String() string {
+	return zipfProviderName
+}
+
+func (z *ZipfProvider) Channel() <-chan *Request {
+	ch := make(chan *Request)
+
+	go func() {
+		defer close(ch)
+
+		// sizes and requests use separate sources, so sizes of objects
+		// do not depend on the amount of requests generated
+		sizes := z.objectSizes(rand.New(rand.NewSource(z.config.Seed)))
+		cdf := z.popularityCDF()
+		rnd := rand.New(rand.NewSource(z.config.Seed + 1))
+		// variants use their own source, so requests do not depend on them
+		variants := rand.New(rand.NewSource(z.config.Seed + 2))
+		clients := rand.New(rand.NewSource(z.config.Seed + 3))
+
+		for i := 0; i < z.config.Requests; i++ {
+			// rank of the object, the most popular one is 0
+			rank := sort.SearchFloat64s(cdf, rnd.Float64()*cdf[len(cdf)-1])
+			if rank >= len(cdf) {
+				rank = len(cdf) - 1
+			}
+
+			req := model.NewRequest(fmt.Sprintf("/zipf/%d", rank), sizes[rank])
+			if z.config.Rate > 0 {
+				req.Timestamp = time.Unix(0, 0).Add(time.Duration(float64(i) / z.config.Rate * float64(time.Second)))
+			}
+			if z.config.Hosts > 0 {
+				req.Host = fmt.Sprintf("site-%d", rank%z.config.Hosts)
+			}
+			if z.config.Variants > 1 {
+				req.SetHeader(ZipfVaryHeader, strconv.Itoa(variants.Intn(z.config.Variants)))
+				req.SetVary(ZipfVaryHeader)
+			}
+			if z.config.Clients > 0 {
+				req.Client = clientAddress(clients.Intn(z.config.Clients))
+			}
+			ch <- req
+		}
+		// send nil to indicate end of data
+		ch <- nil
+	}()
+
+	return ch
+}
+
+// clientAddress returns an IPv4 address of the n-th client in 10.0.0.0/8
+func clientAddress(n int) string {
+	return fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff)
+}
+
+// popularityCDF returns cumulative (not normalized) weights of objects by rank
+func (z *ZipfProvider) popularityCDF() []float64 {
+	cdf := make([]float64, z.config.Catalog)
+	sum := 0.0
+	for i := range cdf {
+		sum += 1 / math.Pow(float64(i+1), z.config.Alpha)
+		cdf[i] = sum
+	}
+	return cdf
+}
+
+func (z *ZipfProvider) objectSizes(rnd *rand.Rand) []int {
+	sizes := make([]int, z.config.Catalog)
+	for i := range sizes {
+		sizes[i] = z.config.Size.Sample(rnd)
+	}
+	return sizes
+}
+
+// SizeDistribution is a distribution of object sizes in bytes
+type SizeDistribution interface {
+	// Sample returns size of an object, at least 1 byte
+	Sample(*rand.Rand) int
+}
+
+// ConstantSize is a distribution where all objects have the same size
+type ConstantSize int
+
+func (c ConstantSize) Sample(_ *rand.Rand) int {
+	return atLeastByte(float64(c))
+}
+
+// UniformSize is a distribution of sizes uniform in [Min, Max]
+type UniformSize struct {
+	Min, Max int
+}
+
+func (u UniformSize) Sample(rnd *rand.Rand) int {
+	return u.Min + rnd.Intn(u.Max-u.Min+1)
+}
+
+// LognormalSize is a distribution of sizes, whose logarithm is normally distributed
+type LognormalSize struct {
+	Mu, Sigma float64
+}
+
+func (l LognormalSize) Sample(rnd *rand.Rand) int {
+	return atLeastByte(math.Exp(l.Mu + l.Sigma*rnd.NormFloat64()))
+}
+
+// ParetoSize is a heavy-tailed distribution of sizes starting at Scale
+type ParetoSize struct {
+	Scale, Shape float64
+}
+
+func (p ParetoSize) Sample(rnd *rand.Rand) int {
+	// inverse transform sampling, 1-U is used to avoid division by zero
+	return atLeastByte(p.Scale / math.Pow(1-rnd.Float64(), 1/p.Shape))
+}
+
+func atLeastByte(size float64) int {
+	if size < 1 || math.IsNaN(size) {
+		return 1
+	}
+	if size > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(size)
+}
+
+// ParseSizeDistribution parses distribution in `name:param,param` format
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	name, rawParams, _ := strings.Cut(s, ":")
+
+	params := make([]float64, 0)
+	if rawParams != "" {
+		for _, raw := range strings.Split(rawParams, ",") {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, v)
+		}
+	}
+
+	expect := func(n int) error {
+		if len(params) != n {
+			return fmt.Errorf("%s distribution expects %d parameters, got %d", name, n, len(params))
+		}
+		return nil
+	}
+
+	switch name {
+	case "constant":
+		if err := expect(1); err != nil {
+			return nil, err
+		}
+		return ConstantSize(params[0]), nil
+	case "uniform":
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		if params[0] < 1 || params[1] < params[0] {
+			return nil, fmt.Errorf("uniform distribution expects 1 <= min <= max")
+		}
+		return UniformSize{int(params[0]), int(params[1])}, nil
+	case "lognormal":
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		return LognormalSize{params[0], params[1]}, nil
+	case "pareto":
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		if params[0] <= 0 || params[1] <= 0 {
+			return nil, fmt.Errorf("pareto distribution expects positive scale and shape")
+		}
+		return ParetoSize{params[0], params[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown size distribution %q", name)
+}
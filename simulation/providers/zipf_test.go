@@ -0,0 +1,102 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package providers
+
+import "testing"
+
+func collect(t *testing.T, args []string) []*Request {
+	provider, err := NewZipfProvider(args)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	requests := make([]*Request, 0)
+	for req := range provider.Channel() {
+		if req == nil {
+			break
+		}
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+func TestZipfDeterministic(t *testing.T) {
+	args := []string{"catalog=100", "alpha=1.1", "requests=1000", "size=pareto:100,1.5", "seed=3"}
+	first := collect(t, args)
+	second := collect(t, args)
+
+	if len(first) != 1000 {
+		t.Fatalf("error: got %d requests instead of 1000", len(first))
+	}
+	for i := range first {
+		if first[i].Url != second[i].Url || first[i].Size != second[i].Size {
+			t.Fatalf("error: request %d differs between runs with the same seed", i)
+		}
+	}
+}
+
+func TestZipfSkew(t *testing.T) {
+	counts := make(map[string]int)
+	for _, req := range collect(t, []string{"catalog=1000", "alpha=1", "requests=10000"}) {
+		counts[req.Url]++
+	}
+
+	if counts["/zipf/0"] <= counts["/zipf/10"] || counts["/zipf/10"] <= counts["/zipf/999"] {
+		t.Fatalf("error: popularity is not decreasing with rank: %d, %d, %d",
+			counts["/zipf/0"], counts["/zipf/10"], counts["/zipf/999"])
+	}
+}
+
+func TestParseSizeDistribution(t *testing.T) {
+	for _, valid := range []string{"constant:10", "uniform:1,10", "lognormal:8,1.5", "pareto:100,1.2"} {
+		if _, err := ParseSizeDistribution(valid); err != nil {
+			t.Fatalf("error: %s: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"constant", "uniform:10,1", "pareto:0,1", "normal:1,1"} {
+		if _, err := ParseSizeDistribution(invalid); err == nil {
+			t.Fatalf("error: %s should not be parsed", invalid)
+		}
+	}
+}
+
+func TestZipfVariants(t *testing.T) {
+	plain := collect(t, []string{"catalog=100", "requests=1000"})
+	varied := collect(t, []string{"catalog=100", "requests=1000", "variants=3"})
+
+	keys := make(map[string]bool)
+	for i := range varied {
+		if varied[i].Url != plain[i].Url {
+			t.Fatalf("error: variants changed request %d", i)
+		}
+		keys[varied[i].CacheKey()] = true
+	}
+	if len(keys) <= 100 || len(keys) > 300 {
+		t.Fatalf("error: %d variants of 100 objects in 3 variants", len(keys))
+	}
+}
+
+func TestZipfClients(t *testing.T) {
+	plain := collect(t, []string{"catalog=100", "requests=1000"})
+	clients := collect(t, []string{"catalog=100", "requests=1000", "clients=10"})
+
+	addresses := make(map[string]bool)
+	for i := range clients {
+		if clients[i].Url != plain[i].Url {
+			t.Fatalf("error: clients changed request %d", i)
+		}
+		addresses[clients[i].Client] = true
+	}
+	if len(addresses) != 10 || !addresses["10.0.0.9"] {
+		t.Fatalf("error: unexpected clients %v", addresses)
+	}
+	if plain[0].Client != "" {
+		t.Fatalf("error: request without clients has client %q", plain[0].Client)
+	}
+}
@@ -0,0 +1,82 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"varnish_sim/model"
+	"varnish_sim/simulation/providers"
+)
+
+// Schedule changes the topology during the run and measures the impact
+type Schedule interface {
+	// Start is called before the first request with the director of front proxies
+	Start(front model.Director)
+
+	// Apply is called before every client request with its number
+	// and time since the start of the trace
+	Apply(request int, elapsed time.Duration, req *providers.Request)
+
+	// Finish is called after the last request
+	Finish()
+
+	// Reports returns impacts of the changes made
+	Reports() []Report
+}
+
+// Report is a result of a schedule, printed as a table or JSON
+type Report interface {
+	TableData() (name string, rows [][]string)
+	Export() map[string]interface{}
+}
+
+// Point is a point of the run, either a number of client request
+// or time since the start of the trace
+type Point struct {
+	ByTime  bool
+	Request int
+	Time    time.Duration
+}
+
+// ParsePoint parses a count of requests (e.g. `1000000`) or a duration (e.g. `1h`)
+func ParsePoint(s string) (Point, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return Point{}, fmt.Errorf("point %q is negative", s)
+		}
+		return Point{Request: n}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return Point{}, fmt.Errorf("point %q is neither a count of requests nor a duration", s)
+	}
+	if d < 0 {
+		return Point{}, fmt.Errorf("point %q is negative", s)
+	}
+	return Point{ByTime: true, Time: d}, nil
+}
+
+// Reached returns if the run has reached the point
+func (p Point) Reached(request int, elapsed time.Duration) bool {
+	if p.ByTime {
+		return elapsed >= p.Time
+	}
+	return request >= p.Request
+}
+
+// Before returns if the point is before the other one of the same kind
+func (p Point) Before(other Point) bool {
+	if p.ByTime {
+		return p.Time < other.Time
+	}
+	return p.Request < other.Request
+}
@@ -11,58 +11,198 @@ package simulation
 import (
 	"fmt"
 	"os"
+	"time"
 	"varnish_sim/model"
 	"varnish_sim/simulation/providers"
 )
 
 // Run starts the simulation
+// balancer distributes requests among front proxies, engine serves them as its processes,
+// nil engine serves each request at once.
 // arg is an argument for provider. For example, a path to a file (for file-provider)
+// rules mark requests of uncacheable objects, nil if the trace marks them itself.
+// steps are registered every stepInterval requests, or every stepTime
+// of the virtual clock if it is set. Schedules change the topology during the run.
 func Run(
-	proxies []*model.VarnishProxy,
+	balancer model.LoadBalancer,
+	engine *model.Engine,
 	args []string,
-	formatter func(string) (string, int),
+	formatter func(string) *providers.Request,
+	rules *providers.UncacheableRules,
 	providerName string,
 	simulationEndCb func() error,
 	stepInterval int,
-	stepRegister func() error,
+	stepTime time.Duration,
+	stepRegister func(time.Time) error,
+	schedules []Schedule,
 ) error {
 	// create dir steps if it does not exist
 	if err := os.Mkdir("steps", 0755); err != nil && !os.IsExist(err) {
 		return err
 	}
 
-	// use directors to distribute requests
-	director := model.NewRoundRobinDirector()
-	for _, proxy := range proxies {
-		director.AddBackend(proxy)
+	// load balancer distributes requests among front proxies
+	for _, schedule := range schedules {
+		schedule.Start(balancer)
 	}
 
 	//
-	provider := providers.NewProviderByName(providerName, args)
-	if provider == nil {
-		return fmt.Errorf("provider %s not found", providerName)
+	provider, err := providers.NewProviderByName(providerName, args)
+	if err != nil {
+		return err
 	}
 	provider.SetFormatter(formatter)
 
 	ch := provider.Channel()
 
 	// start the simulation
-	cnt := 0
+	clock := &Clock{}
+	var start, nextStep time.Time
+	// cnt counts requests, stepped counts the ones since the last step
+	cnt, stepped := 0, 0
 	for req := range ch {
 		if req == nil {
 			break
 		}
-		b := director.GetBackend(req.Url)
-		b.Get(req.Url, req.Size)
+		now := clock.Observe(req)
+		rules.Mark(req)
+		req.Seq = cnt
+		// nodes see requests served by the engine before this one first
+		engine.RunUntil(now)
+		if start.IsZero() {
+			start = now
+		}
+		for _, schedule := range schedules {
+			schedule.Apply(cnt, now.Sub(start), req)
+		}
+
+		if stepTime > 0 {
+			if nextStep.IsZero() {
+				nextStep = now.Add(stepTime)
+			}
+			if !now.Before(nextStep) {
+				// print statistics of the window that has just ended
+				if err := stepRegister(nextStep); err != nil {
+					fmt.Println(err)
+				}
+				// skip windows without requests
+				nextStep = nextStep.Add((now.Sub(nextStep)/stepTime + 1) * stepTime)
+				stepped = 0
+			}
+		}
+
+		// request is lost, if no front proxy is healthy
+		if b := balancer.Pick(req); b != nil {
+			req := req
+			engine.Serve(req, func() {
+				b.Get(req)
+				balancer.Done(b, req)
+			})
+			engine.RunUntil(now)
+		}
 		cnt++
+		stepped++
 
-		if cnt%stepInterval == 0 {
+		if stepTime <= 0 && cnt%stepInterval == 0 {
 			// print statistics
-			if err := stepRegister(); err != nil {
+			if err := stepRegister(now); err != nil {
 				fmt.Println(err)
 			}
 		}
 	}
 
+	engine.Run()
+	if stepTime > 0 && stepped > 0 {
+		// print statistics of the last window, that has not ended
+		if err := stepRegister(clock.Now()); err != nil {
+			fmt.Println(err)
+		}
+	}
+	for _, schedule := range schedules {
+		schedule.Finish()
+	}
+
 	return simulationEndCb()
 }
+
+// Load reads all requests of the provider into memory,
+// requests are stamped by the virtual clock and marked by rules the same way Run does.
+func Load(
+	args []string,
+	formatter func(string) *providers.Request,
+	rules *providers.UncacheableRules,
+	providerName string,
+) ([]*providers.Request, error) {
+	provider, err := providers.NewProviderByName(providerName, args)
+	if err != nil {
+		return nil, err
+	}
+	provider.SetFormatter(formatter)
+
+	clock := &Clock{}
+	requests := make([]*providers.Request, 0)
+	for req := range provider.Channel() {
+		if req == nil {
+			break
+		}
+		clock.Observe(req)
+		rules.Mark(req)
+		req.Seq = len(requests)
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
+
+// Replay sends loaded requests to front proxies picked by the balancer the same way Run does,
+// without registering steps. Proxies get copies of requests, so loaded
+// requests may be replayed by several goroutines at once.
+func Replay(balancer model.LoadBalancer, requests []*providers.Request, engine *model.Engine) {
+	for _, req := range requests {
+		engine.RunUntil(req.Timestamp)
+		r := *req
+		if b := balancer.Pick(&r); b != nil {
+			engine.Serve(&r, func() {
+				b.Get(&r)
+				balancer.Done(b, &r)
+			})
+			engine.RunUntil(req.Timestamp)
+		}
+	}
+	engine.Run()
+}
+
+// Scan reads the trace of the provider ahead of the simulation and returns its future,
+// cache keys are recorded in order of requests, the same way Run sends them.
+// Requests are not kept, so the trace is read twice, without holding it in memory.
+func Scan(
+	args []string,
+	formatter func(string) *providers.Request,
+	rules *providers.UncacheableRules,
+	providerName string,
+) (*model.Future, error) {
+	provider, err := providers.NewProviderByName(providerName, args)
+	if err != nil {
+		return nil, err
+	}
+	provider.SetFormatter(formatter)
+
+	future := model.NewFuture()
+	for req := range provider.Channel() {
+		if req == nil {
+			break
+		}
+		rules.Mark(req)
+		future.Record(req.CacheKey())
+	}
+	return future, nil
+}
+
+// FutureOf returns the future of loaded requests
+func FutureOf(requests []*providers.Request) *model.Future {
+	future := model.NewFuture()
+	for _, req := range requests {
+		future.Record(req.CacheKey())
+	}
+	return future
+}
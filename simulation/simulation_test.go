@@ -0,0 +1,91 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"varnish_sim/cases"
+	"varnish_sim/model"
+	"varnish_sim/simulation/providers"
+)
+
+func TestRunStepTime(t *testing.T) {
+	// Run writes steps into the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// windows of 10s: [0,10) [10,20) [20,30) is empty, [30,40) and [40,50) is not over at the end
+	trace := filepath.Join(dir, "trace.txt")
+	if err := os.WriteFile(trace, []byte("0 /a\n5 /b\n12 /a\n35 /c\n41 /a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	formatter := func(line string) *providers.Request {
+		sec, url, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			return nil
+		}
+		at, err := strconv.Atoi(sec)
+		if err != nil {
+			return nil
+		}
+		req := model.NewRequest(url, 10)
+		req.Timestamp = time.Unix(int64(at), 0)
+		return req
+	}
+
+	c := cases.NewOneLayer(cases.LayerConfig{Amount: 1, CacheSize: 1000})
+	proxies, err := c.SetUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer, err := model.NewLoadBalancer(model.RoundRobinBalancer, proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []int64
+	var requests []int
+	err = Run(balancer, nil, []string{trace}, formatter, nil, "file",
+		func() error { return nil },
+		1000, 10*time.Second,
+		func(at time.Time) error {
+			steps = append(steps, at.Unix())
+			requests = append(requests, model.NewOriginOffload(c.Proxies(), c.Origins()).Requests)
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// steps fire on trace time, the empty window is skipped and the last one is flushed
+	wantSteps, wantRequests := []int64{10, 20, 40, 41}, []int{2, 3, 4, 5}
+	if len(steps) != len(wantSteps) {
+		t.Fatalf("steps at %v instead of %v", steps, wantSteps)
+	}
+	for i := range steps {
+		if steps[i] != wantSteps[i] || requests[i] != wantRequests[i] {
+			t.Errorf("step %d at %d after %d requests instead of at %d after %d",
+				i, steps[i], requests[i], wantSteps[i], wantRequests[i])
+		}
+	}
+}
@@ -0,0 +1,234 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"sort"
+	"sync"
+	"varnish_sim/model"
+	"varnish_sim/simulation/providers"
+)
+
+// SweepPoint is a point of a miss-ratio curve,
+// misses are requests (and bytes) that reached origins
+type SweepPoint struct {
+	CacheSize     int     `json:"cache_size"`
+	Requests      int     `json:"requests"`
+	Misses        int     `json:"misses"`
+	MissRatio     float64 `json:"miss_ratio"`
+	Bytes         int     `json:"bytes"`
+	MissBytes     int     `json:"miss_bytes"`
+	ByteMissRatio float64 `json:"byte_miss_ratio"`
+}
+
+// newSweepPoint returns a point computing ratios of the counters
+func newSweepPoint(cacheSize, requests, misses, bytes, missBytes int) SweepPoint {
+	point := SweepPoint{
+		CacheSize: cacheSize,
+		Requests:  requests,
+		Misses:    misses,
+		Bytes:     bytes,
+		MissBytes: missBytes,
+	}
+	if requests > 0 {
+		point.MissRatio = float64(misses) / float64(requests)
+	}
+	if bytes > 0 {
+		point.ByteMissRatio = float64(missBytes) / float64(bytes)
+	}
+	return point
+}
+
+// SweepCase is a case, whose cache size is changed by the sweep
+type SweepCase interface {
+	SetUp() ([]*model.VarnishProxy, error)
+	Proxies() []*model.VarnishProxy
+	Origins() []*model.Backend
+}
+
+// Sweep replays the requests against the case built for every cache size, front proxies
+// are picked by the load balancer of the spec (see model.NewLoadBalancer),
+// at most `parallel` replays run at once. Points are returned in order of sizes.
+func Sweep(
+	requests []*providers.Request,
+	sizes []int,
+	parallel int,
+	balancerSpec string,
+	build func(cacheSize int) (SweepCase, error),
+) ([]SweepPoint, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	bytes := 0
+	for _, req := range requests {
+		bytes += req.Size
+	}
+
+	points := make([]SweepPoint, len(sizes))
+	errs := make([]error, len(sizes))
+	semaphore := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+
+	for i, size := range sizes {
+		wg.Add(1)
+		go func(i, size int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			c, err := build(size)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			proxies, err := c.SetUp()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			balancer, err := model.NewLoadBalancer(balancerSpec, proxies)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			Replay(balancer, requests, model.NewEngine(c.Proxies(), c.Origins()))
+
+			misses, missBytes := 0, 0
+			for _, origin := range c.Origins() {
+				misses += origin.Requests()
+				missBytes += origin.Bytes()
+			}
+			points[i] = newSweepPoint(size, len(requests), misses, bytes, missBytes)
+		}(i, size)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// CanStackSweep returns if the miss-ratio curve of LRU proxies without TTL
+// may be computed by StackSweep: every object keeps its size through the trace,
+// fits into the smallest cache, and no request sets a TTL or is uncacheable.
+func CanStackSweep(requests []*providers.Request, sizes []int) bool {
+	if len(sizes) == 0 {
+		return false
+	}
+	smallest := sizes[0]
+	for _, size := range sizes {
+		if size < smallest {
+			smallest = size
+		}
+	}
+
+	objects := make(map[string]int)
+	for _, req := range requests {
+		if req.TTL != model.UnsetDuration || req.Uncacheable || req.Size > smallest {
+			return false
+		}
+		if size, ok := objects[req.CacheKey()]; ok && size != req.Size {
+			return false
+		}
+		objects[req.CacheKey()] = req.Size
+	}
+	return true
+}
+
+// StackSweep computes the miss-ratio curve of `amount` LRU proxies fed
+// by round-robin in a single pass over the requests (Mattson's stack algorithm).
+//
+// LRU cache of any size holds the most recent objects fitting in it, so the request
+// is a hit iff bytes of distinct objects requested since the previous request
+// of the object, including itself, fit into the cache. This stack distance
+// is computed in O(log n) by Fenwick tree holding sizes at last access positions.
+// Results equal to Sweep only if CanStackSweep holds.
+func StackSweep(requests []*providers.Request, sizes []int, amount int) []SweepPoint {
+	if amount < 1 {
+		amount = 1
+	}
+
+	// round-robin splits requests into independent streams, one per proxy
+	streams := make([][]*providers.Request, amount)
+	for i, req := range requests {
+		streams[i%amount] = append(streams[i%amount], req)
+	}
+
+	// distances of requests, that were requested before
+	type reuse struct {
+		distance int
+		size     int
+	}
+	reuses := make([]reuse, 0, len(requests))
+	bytes := 0
+
+	for _, stream := range streams {
+		tree := newFenwickTree(len(stream))
+		last := make(map[string]int)
+
+		for t, req := range stream {
+			// variants are distinct objects in the cache
+			key := req.CacheKey()
+			bytes += req.Size
+			if prev, ok := last[key]; ok {
+				reuses = append(reuses, reuse{tree.sum(prev, t), req.Size})
+				tree.add(prev, -req.Size)
+			}
+			tree.add(t, req.Size)
+			last[key] = t
+		}
+	}
+
+	sort.Slice(reuses, func(i, j int) bool { return reuses[i].distance < reuses[j].distance })
+	// hitBytes[i] is a sum of sizes of the first i reuses
+	hitBytes := make([]int, len(reuses)+1)
+	for i, r := range reuses {
+		hitBytes[i+1] = hitBytes[i] + r.size
+	}
+
+	points := make([]SweepPoint, 0, len(sizes))
+	for _, size := range sizes {
+		hits := sort.Search(len(reuses), func(i int) bool { return reuses[i].distance > size })
+		points = append(points, newSweepPoint(size, len(requests), len(requests)-hits, bytes, bytes-hitBytes[hits]))
+	}
+	return points
+}
+
+// fenwickTree is a binary indexed tree of prefix sums
+type fenwickTree []int
+
+func newFenwickTree(n int) fenwickTree {
+	return make(fenwickTree, n+1)
+}
+
+// add adds the value at the position
+func (f fenwickTree) add(pos, value int) {
+	for i := pos + 1; i < len(f); i += i & -i {
+		f[i] += value
+	}
+}
+
+// prefix returns a sum of values at positions [0, pos)
+func (f fenwickTree) prefix(pos int) int {
+	sum := 0
+	for i := pos; i > 0; i -= i & -i {
+		sum += f[i]
+	}
+	return sum
+}
+
+// sum returns a sum of values at positions [from, to)
+func (f fenwickTree) sum(from, to int) int {
+	return f.prefix(to) - f.prefix(from)
+}
@@ -0,0 +1,90 @@
+//  Copyright 2024 Mark Barzali
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+
+package simulation
+
+import (
+	"testing"
+	"varnish_sim/cases"
+	"varnish_sim/model"
+)
+
+func TestStackSweepEqualsReplay(t *testing.T) {
+	requests, err := Load([]string{"catalog=500", "requests=20000", "size=uniform:1,3000"}, nil, nil, "zipf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{5000, 20000, 100000, 1000000}
+	if !CanStackSweep(requests, sizes) {
+		t.Fatal("stack sweep should be exact for the trace")
+	}
+
+	const amount = 3
+	stack := StackSweep(requests, sizes, amount)
+	replay, err := Sweep(requests, sizes, 2, model.RoundRobinBalancer, func(size int) (SweepCase, error) {
+		return cases.NewOneLayer(cases.LayerConfig{Amount: amount, CacheSize: size}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range sizes {
+		if stack[i] != replay[i] {
+			t.Errorf("size %d: stack %+v, replay %+v", sizes[i], stack[i], replay[i])
+		}
+	}
+	if stack[0].Misses <= stack[len(stack)-1].Misses {
+		t.Errorf("misses should decrease with cache size: %+v", stack)
+	}
+}
+
+func TestCanStackSweep(t *testing.T) {
+	requests, err := Load([]string{"catalog=10", "requests=100", "size=constant:100"}, nil, nil, "zipf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if CanStackSweep(requests, []int{50, 1000}) {
+		t.Error("objects larger than the cache can not be swept by stack")
+	}
+
+	requests[0].TTL = 0
+	if CanStackSweep(requests, []int{1000}) {
+		t.Error("requests with TTL can not be swept by stack")
+	}
+}
+
+func TestSweepLoadBalancer(t *testing.T) {
+	requests, err := Load([]string{"catalog=500", "requests=20000", "size=uniform:1,3000"}, nil, nil, "zipf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{100000}
+	misses := make(map[string]int)
+	for _, balancer := range []string{model.RoundRobinBalancer, model.URLHashBalancer} {
+		points, err := Sweep(requests, sizes, 1, balancer, func(size int) (SweepCase, error) {
+			return cases.NewOneLayer(cases.LayerConfig{Amount: 3, CacheSize: size}), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		misses[balancer] = points[0].Misses
+	}
+	// url-hash does not duplicate objects among proxies, so they cache more of them
+	if misses[model.URLHashBalancer] >= misses[model.RoundRobinBalancer] {
+		t.Errorf("url-hash should miss less than round-robin: %v", misses)
+	}
+
+	if _, err := Sweep(requests, sizes, 1, "unknown", func(size int) (SweepCase, error) {
+		return cases.NewOneLayer(cases.LayerConfig{Amount: 3, CacheSize: size}), nil
+	}); err == nil {
+		t.Errorf("unknown load balancer should fail the sweep")
+	}
+}